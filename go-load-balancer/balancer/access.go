@@ -0,0 +1,262 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AccessConfig holds the access rules applied to the backend pool before a
+// backend is selected. Secrets may be given in plain text or as
+// "sha256:<hex digest>" so the config file does not have to hold them.
+type AccessConfig struct {
+	// Allow, when not empty, restricts access to clients in these CIDRs
+	Allow []string `json:"allow"`
+	// Deny rejects clients in these CIDRs, it takes precedence over Allow
+	Deny []string `json:"deny"`
+	// TrustedProxies are the CIDRs whose X-Forwarded-For header is trusted
+	// when working out the real client IP
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Users maps basic auth usernames to passwords
+	Users map[string]string `json:"users"`
+	// BearerTokens are static tokens accepted in an Authorization: Bearer header
+	BearerTokens []string `json:"bearer_tokens"`
+}
+
+// AccessPolicy is the compiled form of an AccessConfig
+type AccessPolicy struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+	users   map[string]secret
+	tokens  []secret
+}
+
+// secret is the SHA-256 digest of a credential, compared in constant time
+type secret [sha256.Size]byte
+
+func parseSecret(s string) (secret, error) {
+	var sec secret
+	if digest, ok := strings.CutPrefix(s, "sha256:"); ok {
+		b, err := hex.DecodeString(digest)
+		if err != nil || len(b) != sha256.Size {
+			return sec, errors.New("invalid sha256 digest")
+		}
+		copy(sec[:], b)
+		return sec, nil
+	}
+	if s == "" {
+		return sec, errors.New("empty secret")
+	}
+	return sha256.Sum256([]byte(s)), nil
+}
+
+func (sec secret) matches(candidate string) bool {
+	sum := sha256.Sum256([]byte(candidate))
+	return subtle.ConstantTimeCompare(sec[:], sum[:]) == 1
+}
+
+// NewAccessPolicy compiles the access rules, reporting every invalid entry
+func NewAccessPolicy(c AccessConfig) (*AccessPolicy, error) {
+	var errs []error
+	parse := func(field string, cidrs []string) []netip.Prefix {
+		prefixes := make([]netip.Prefix, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			p, err := parsePrefix(cidr)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("access.%s: %w", field, err))
+				continue
+			}
+			prefixes = append(prefixes, p)
+		}
+		return prefixes
+	}
+
+	p := &AccessPolicy{
+		allow:   parse("allow", c.Allow),
+		deny:    parse("deny", c.Deny),
+		trusted: parse("trusted_proxies", c.TrustedProxies),
+		users:   make(map[string]secret, len(c.Users)),
+	}
+	for user, password := range c.Users {
+		sec, err := parseSecret(password)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("access.users[%q]: %w", user, err))
+			continue
+		}
+		p.users[user] = sec
+	}
+	for i, token := range c.BearerTokens {
+		sec, err := parseSecret(token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("access.bearer_tokens[%d]: %w", i, err))
+			continue
+		}
+		p.tokens = append(p.tokens, sec)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parsePrefix accepts a CIDR or a bare IP address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the real client address. X-Forwarded-For is only
+// consulted when the connection comes from a trusted proxy, and is walked
+// from the right so a client cannot spoof its address by prepending entries.
+func (p *AccessPolicy) ClientIP(r *http.Request) (netip.Addr, bool) {
+	addr, ok := peerAddr(r)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	if !containsAddr(p.trusted, addr) {
+		return addr, true
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !containsAddr(p.trusted, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// Scheme returns the scheme the client used to reach the balancer. Like
+// X-Forwarded-For, X-Forwarded-Proto is only believed when the connection
+// comes from a trusted proxy. A nil policy trusts no proxy.
+func (p *AccessPolicy) Scheme(r *http.Request) string {
+	if p != nil {
+		if addr, ok := peerAddr(r); ok && containsAddr(p.trusted, addr) {
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+				return proto
+			}
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// peerAddr returns the address of the connection the request came on
+func peerAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Check enforces the policy. It returns 0 when the request may proceed,
+// otherwise the status code to reply with.
+func (p *AccessPolicy) Check(r *http.Request) int {
+	if len(p.allow) > 0 || len(p.deny) > 0 {
+		addr, ok := p.ClientIP(r)
+		if !ok || containsAddr(p.deny, addr) {
+			return http.StatusForbidden
+		}
+		if len(p.allow) > 0 && !containsAddr(p.allow, addr) {
+			return http.StatusForbidden
+		}
+	}
+
+	if !p.requiresAuth() || p.authenticated(r) {
+		return 0
+	}
+	return http.StatusUnauthorized
+}
+
+func (p *AccessPolicy) requiresAuth() bool {
+	return len(p.users) > 0 || len(p.tokens) > 0
+}
+
+func (p *AccessPolicy) authenticated(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		sec, found := p.users[user]
+		if !found {
+			// Compare anyway so unknown users take as long as known ones
+			sec = secret{}
+		}
+		return sec.matches(password) && found
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	valid := false
+	for _, sec := range p.tokens {
+		if sec.matches(token) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// authorize applies the current access policy, writing the error response
+// and returning false when the request is rejected. Credentials consumed by
+// the load balancer are not forwarded to the backend.
+func (lb *LoadBalancer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	p := lb.access.Load()
+	if p == nil {
+		return true
+	}
+
+	switch p.Check(r) {
+	case http.StatusForbidden:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	case http.StatusUnauthorized:
+		if len(p.users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="load-balancer", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="load-balancer"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	if p.requiresAuth() {
+		r.Header.Del("Authorization")
+	}
+	return true
+}
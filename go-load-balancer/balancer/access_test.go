@@ -0,0 +1,248 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestPolicy(t *testing.T, c AccessConfig) *AccessPolicy {
+	t.Helper()
+	p, err := NewAccessPolicy(c)
+	if err != nil {
+		t.Fatalf("NewAccessPolicy: %v", err)
+	}
+	return p
+}
+
+func TestNewAccessPolicyErrors(t *testing.T) {
+	_, err := NewAccessPolicy(AccessConfig{
+		Allow:        []string{"10.0.0.0/33"},
+		Deny:         []string{"not-an-ip"},
+		Users:        map[string]string{"alice": "sha256:zz"},
+		BearerTokens: []string{""},
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, field := range []string{"access.allow", "access.deny", `access.users["alice"]`, "access.bearer_tokens[0]"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q does not mention %s", err, field)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	p := newTestPolicy(t, AccessConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		expected   string
+	}{
+		{"Direct client", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"Spoofed XFF from untrusted peer", "203.0.113.7:1234", []string{"192.0.2.1"}, "203.0.113.7"},
+		{"XFF from trusted proxy", "10.0.0.2:1234", []string{"192.0.2.1"}, "192.0.2.1"},
+		{"Prepended entry is ignored", "10.0.0.2:1234", []string{"198.51.100.9, 192.0.2.1"}, "192.0.2.1"},
+		{"Chain of trusted proxies", "10.0.0.2:1234", []string{"192.0.2.1, 10.1.1.1"}, "192.0.2.1"},
+		{"Several XFF headers", "10.0.0.2:1234", []string{"198.51.100.9", "192.0.2.1"}, "192.0.2.1"},
+		{"Garbage hop stops the walk", "10.0.0.2:1234", []string{"192.0.2.1, garbage"}, "10.0.0.2"},
+		{"IPv4-mapped IPv6 peer", "[::ffff:203.0.113.7]:1234", nil, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			addr, ok := p.ClientIP(r)
+			if !ok || addr.String() != tt.expected {
+				t.Errorf("ClientIP = %v, %v, want %s", addr, ok, tt.expected)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "not an address"
+	if _, ok := p.ClientIP(r); ok {
+		t.Error("unparsable peer address accepted")
+	}
+}
+
+func TestAccessPolicyCIDRs(t *testing.T) {
+	p := newTestPolicy(t, AccessConfig{
+		Allow:          []string{"192.0.2.0/24", "198.51.100.5"},
+		Deny:           []string{"192.0.2.66"},
+		TrustedProxies: []string{"10.0.0.1"},
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		expected   int
+	}{
+		{"Allowed range", "192.0.2.10:1", "", 0},
+		{"Allowed single address", "198.51.100.5:1", "", 0},
+		{"Outside the allow list", "203.0.113.1:1", "", http.StatusForbidden},
+		{"Deny wins over allow", "192.0.2.66:1", "", http.StatusForbidden},
+		{"Spoofed XFF does not get in", "203.0.113.1:1", "192.0.2.10", http.StatusForbidden},
+		{"Spoofed XFF does not get around deny", "192.0.2.66:1", "192.0.2.10", http.StatusForbidden},
+		{"Allowed client behind trusted proxy", "10.0.0.1:1", "192.0.2.10", 0},
+		{"Denied client behind trusted proxy", "10.0.0.1:1", "192.0.2.66", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := p.Check(r); got != tt.expected {
+				t.Errorf("Check = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyCredentials(t *testing.T) {
+	tokenDigest := hex.EncodeToString(sha256Sum("hashed-token"))
+	p := newTestPolicy(t, AccessConfig{
+		Users:        map[string]string{"alice": "wonderland", "bob": "sha256:" + hex.EncodeToString(sha256Sum("builder"))},
+		BearerTokens: []string{"plain-token", "sha256:" + tokenDigest},
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		basicUser     string
+		basicPassword string
+		expected      int
+	}{
+		{"No credentials", "", "", "", http.StatusUnauthorized},
+		{"Right password", "", "alice", "wonderland", 0},
+		{"Right password for hashed secret", "", "bob", "builder", 0},
+		{"Wrong password", "", "alice", "looking-glass", http.StatusUnauthorized},
+		{"Password of another user", "", "alice", "builder", http.StatusUnauthorized},
+		{"Unknown user", "", "mallory", "wonderland", http.StatusUnauthorized},
+		{"Unknown user with empty password", "", "mallory", "", http.StatusUnauthorized},
+		{"Right token", "Bearer plain-token", "", "", 0},
+		{"Right token for hashed secret", "Bearer hashed-token", "", "", 0},
+		{"Scheme is case insensitive", "bearer plain-token", "", "", 0},
+		{"Wrong token", "Bearer wrong-token", "", "", http.StatusUnauthorized},
+		{"Digest itself is not a token", "Bearer sha256:" + tokenDigest, "", "", http.StatusUnauthorized},
+		{"Bearer without token", "Bearer", "", "", http.StatusUnauthorized},
+		{"Empty bearer token", "Bearer ", "", "", http.StatusUnauthorized},
+		{"Unknown scheme", "Token plain-token", "", "", http.StatusUnauthorized},
+		{"Malformed basic auth", "Basic !!!not-base64", "", "", http.StatusUnauthorized},
+		{"Basic auth without colon", "Basic YWxpY2U=", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.basicUser != "" {
+				r.SetBasicAuth(tt.basicUser, tt.basicPassword)
+			} else if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if got := p.Check(r); got != tt.expected {
+				t.Errorf("Check = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyOpen(t *testing.T) {
+	p := newTestPolicy(t, AccessConfig{})
+	if got := p.Check(httptest.NewRequest("GET", "/", nil)); got != 0 {
+		t.Errorf("Check = %d on an empty policy", got)
+	}
+}
+
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+func TestAccessPolicyScheme(t *testing.T) {
+	p := newTestPolicy(t, AccessConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		expected   string
+	}{
+		{"Plain connection", "203.0.113.7:1234", "", false, "http"},
+		{"TLS connection", "203.0.113.7:1234", "", true, "https"},
+		{"Spoofed proto from untrusted peer", "203.0.113.7:1234", "https", false, "http"},
+		{"Spoofed proto over TLS", "203.0.113.7:1234", "http", true, "https"},
+		{"Proto from trusted proxy", "10.0.0.2:1234", "https", false, "https"},
+		{"Unknown proto from trusted proxy", "10.0.0.2:1234", "javascript", false, "http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if got := p.Scheme(r); got != tt.expected {
+				t.Errorf("Scheme = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	var open *AccessPolicy
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if got := open.Scheme(r); got != "http" {
+		t.Errorf("nil policy trusted X-Forwarded-Proto: %q", got)
+	}
+}
+
+func TestRewriteLocationIgnoresSpoofedProto(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/next", http.StatusFound)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	lb := New(NewBackend(u, BackendOptions{}))
+	lb.access.Store(newTestPolicy(t, AccessConfig{TrustedProxies: []string{"10.0.0.0/8"}}))
+
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"203.0.113.7:1234", "http://lb.example.com/next"},
+		{"10.0.0.2:1234", "https://lb.example.com/next"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://lb.example.com/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("X-Forwarded-Proto", "https")
+		rr := httptest.NewRecorder()
+		lb.ServeHTTP(rr, r)
+
+		if got := rr.Header().Get("Location"); got != tt.expected {
+			t.Errorf("from %s: Location %q, want %q", tt.remoteAddr, got, tt.expected)
+		}
+	}
+}
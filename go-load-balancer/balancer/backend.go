@@ -0,0 +1,133 @@
+package balancer
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend represents a backend server
+type Backend struct {
+	URL          *url.URL
+	Alive        bool
+	mux          sync.RWMutex
+	ReverseProxy *httputil.ReverseProxy
+
+	latency  latencyStats
+	inflight atomic.Int64
+
+	// Outlier ejection state, guarded by mux
+	ejectedUntil time.Time
+	ejections    int
+}
+
+// BackendOptions configures the reverse proxy built by NewBackend
+type BackendOptions struct {
+	// Modifiers run on every response from the backend, see ResponseModifier
+	Modifiers []ResponseModifier
+}
+
+// NewBackend creates a backend for the given URL with a reverse proxy that
+// tags requests, rewrites responses and marks the backend down on errors
+func NewBackend(u *url.URL, opts BackendOptions) *Backend {
+	b := &Backend{URL: u, Alive: true}
+
+	proxy := httputil.NewSingleHostReverseProxy(u)
+
+	// Customize the reverse proxy director
+	originalDirector := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		originalDirector(r)
+		r.Header.Set("X-Proxy", "Simple-Load-Balancer")
+	}
+
+	// Rewrite backend responses before they reach the client
+	modifiers := append([]ResponseModifier{RewriteLocation(u)}, opts.Modifiers...)
+	modify := ChainModifiers(modifiers...)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		// The response headers are in, the time to stream the body depends
+		// on the client as much as on the backend
+		if a := attemptFromContext(resp.Request.Context()); a != nil {
+			a.latency = time.Since(a.start)
+		}
+		return modify(resp)
+	}
+
+	// Add custom error handler
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Proxy error: %v", err)
+
+		// Mark the backend as down
+		b.SetAlive(false)
+
+		// Leave the response unwritten when the load balancer will retry
+		// the request on another backend
+		if a := attemptFromContext(r.Context()); a != nil {
+			a.err = err
+			if !a.last {
+				return
+			}
+		}
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}
+
+	b.ReverseProxy = proxy
+	return b
+}
+
+// SetAlive updates the alive status of backend
+func (b *Backend) SetAlive(alive bool) {
+	b.mux.Lock()
+	b.Alive = alive
+	b.mux.Unlock()
+}
+
+// IsAlive returns true when backend is alive
+func (b *Backend) IsAlive() (alive bool) {
+	b.mux.RLock()
+	alive = b.Alive
+	b.mux.RUnlock()
+	return
+}
+
+// IsEjected returns true while the backend is ejected as a latency outlier
+func (b *Backend) IsEjected() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return time.Now().Before(b.ejectedUntil)
+}
+
+// Available returns true when the backend is alive and not ejected
+func (b *Backend) Available() bool {
+	return b.IsAlive() && !b.IsEjected()
+}
+
+// Latency returns the backend's peak EWMA latency and the given percentile
+// of its recent responses
+func (b *Backend) Latency(q float64) (ewma, percentile time.Duration) {
+	percentile, _ = b.latency.Percentile(q)
+	return b.latency.EWMA(), percentile
+}
+
+// InFlight returns the number of requests currently proxied to the backend
+func (b *Backend) InFlight() int64 {
+	return b.inflight.Load()
+}
+
+// serve proxies the request and records the backend's latency, the time
+// until its response headers arrived. Failed attempts are not recorded, the
+// backend is marked down for those instead.
+func (b *Backend) serve(w http.ResponseWriter, r *http.Request, a *attempt) {
+	b.inflight.Add(1)
+	a.start = time.Now()
+	b.ReverseProxy.ServeHTTP(w, r)
+	b.inflight.Add(-1)
+
+	if a.err == nil && a.latency > 0 {
+		b.latency.observe(a.latency)
+	}
+}
@@ -0,0 +1,243 @@
+package balancer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultCompressibleTypes lists the content types compressed when no
+// explicit list is configured
+var defaultCompressibleTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressionConfig controls which responses are compressed
+type CompressionConfig struct {
+	// Types is the list of media types eligible for compression.
+	// A trailing "/*" matches a whole family, e.g. "text/*".
+	Types []string
+	// MinSize is the smallest Content-Length worth compressing. Responses
+	// without a Content-Length are always considered.
+	MinSize int
+	// Level is the compression level passed to gzip and zlib
+	Level int
+}
+
+// DefaultCompressionConfig returns a config suitable for most text responses
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Types:   defaultCompressibleTypes,
+		MinSize: 256,
+		Level:   gzip.DefaultCompression,
+	}
+}
+
+// Compress wraps a handler and compresses its responses with gzip or deflate
+// depending on the client's Accept-Encoding header and the response content type
+func Compress(next http.Handler, cfg CompressionConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, cfg: cfg, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// honouring q-values. It returns an empty string when neither is acceptable.
+func negotiateEncoding(header string) string {
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, q := parseCoding(part)
+		if name != "" {
+			qs[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	// gzip comes first so it wins ties, it is the more widely supported format
+	for _, name := range []string{"gzip", "deflate"} {
+		q, ok := qs[name]
+		if !ok {
+			// "*" covers the codings not listed explicitly
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// parseCoding splits an Accept-Encoding element into its name and q-value
+func parseCoding(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	name := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+		if err != nil {
+			return name, 0
+		}
+		q = v
+	}
+	return name, q
+}
+
+// compressWriter decides on the first WriteHeader whether the response is
+// eligible for compression and, if so, routes the body through an encoder
+type compressWriter struct {
+	http.ResponseWriter
+	cfg         CompressionConfig
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	if cw.shouldCompress(status) {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", cw.encoding)
+		// The compressed bytes differ from the upstream representation, so a
+		// strong validator would no longer hold
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = cw.newEncoder()
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush pushes any buffered compressed data to the client. The reverse proxy
+// calls this for streamed responses.
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets protocol upgrades (e.g. websockets) pass through the wrapper
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("compress: underlying ResponseWriter does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close flushes and releases the encoder
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
+
+func (cw *compressWriter) shouldCompress(status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	h := cw.Header()
+	// A byte range refers to the uncompressed body, compressing it would
+	// make the range meaningless
+	if status == http.StatusPartialContent || h.Get("Content-Range") != "" {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		// Upstream already encoded the body
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < cw.cfg.MinSize {
+			return false
+		}
+	}
+	return matchesType(h.Get("Content-Type"), cw.cfg.Types)
+}
+
+func (cw *compressWriter) newEncoder() io.WriteCloser {
+	// The "deflate" content coding is the zlib format (RFC 9110 section 8.4.1.2)
+	if cw.encoding == "deflate" {
+		zw, err := zlib.NewWriterLevel(cw.ResponseWriter, cw.cfg.Level)
+		if err != nil {
+			zw = zlib.NewWriter(cw.ResponseWriter)
+		}
+		return zw
+	}
+	gw, err := gzip.NewWriterLevel(cw.ResponseWriter, cw.cfg.Level)
+	if err != nil {
+		gw = gzip.NewWriter(cw.ResponseWriter)
+	}
+	return gw
+}
+
+// matchesType reports whether contentType is in the list of allowed types
+func matchesType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == mediaType {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
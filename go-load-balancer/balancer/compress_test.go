@@ -0,0 +1,132 @@
+package balancer
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0.8, deflate;q=0.9", "deflate"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip;q=bad", ""},
+		{"br", ""},
+		{"identity", ""},
+		{"identity;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0", ""},
+		{"gzip;q=0, *", "deflate"},
+		{"gzip;q=0, deflate;q=0, *", ""},
+		{"deflate;q=0.5, *;q=0.1", "deflate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.expected {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("compress me ", 100)
+
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		compressed bool
+	}{
+		{"Text", http.StatusOK, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, body, true},
+		{"JSON", http.StatusOK, http.Header{"Content-Type": {"application/json"}}, body, true},
+		{"Small body", http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"5"}}, "small", false},
+		{"Already encoded", http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"br"}}, body, false},
+		{"Excluded type", http.StatusOK, http.Header{"Content-Type": {"image/png"}}, body, false},
+		{"Partial content", http.StatusPartialContent, http.Header{"Content-Type": {"text/plain"}, "Content-Range": {"bytes 0-1199/5000"}}, body, false},
+		{"Content-Range on 200", http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Content-Range": {"bytes */5000"}}, body, false},
+		{"No content", http.StatusNoContent, http.Header{"Content-Type": {"text/plain"}}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}), DefaultCompressionConfig())
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status %d, want %d", rr.Code, tt.status)
+			}
+			encoded := rr.Header().Get("Content-Encoding") == "gzip"
+			if encoded != tt.compressed {
+				t.Fatalf("compressed = %v, want %v (headers %v)", encoded, tt.compressed, rr.Header())
+			}
+			got := rr.Body.String()
+			if encoded {
+				zr, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(b)
+			}
+			if got != tt.body {
+				t.Errorf("body changed: got %d bytes, want %d", len(got), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompressWeakensETag(t *testing.T) {
+	tests := []struct {
+		etag     string
+		expected string
+	}{
+		{`"abc"`, `W/"abc"`},
+		{`W/"abc"`, `W/"abc"`},
+	}
+
+	for _, tt := range tests {
+		h := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", tt.etag)
+			io.WriteString(w, strings.Repeat("x", 1000))
+		}), DefaultCompressionConfig())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("ETag"); got != tt.expected {
+			t.Errorf("ETag %s became %s, want %s", tt.etag, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package balancer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"load-balancer/tracing"
+)
+
+// Duration is a time.Duration that reads from and writes to JSON as a
+// string such as "30s" or "1m"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config describes a load balancer instance
+type Config struct {
+	Port                int      `json:"port"`
+	AdminAddr           string   `json:"admin_addr"`
+	HealthCheckInterval Duration `json:"health_check_interval"`
+	Backends            []string `json:"backends"`
+	Compress            bool     `json:"compress"`
+	SecurityHeaders     bool     `json:"security_headers"`
+	// Retries is how many other backends a request without a body is sent
+	// to when the chosen backend cannot be reached
+	Retries int           `json:"retries"`
+	Tracing TracingConfig `json:"tracing"`
+	Access  AccessConfig  `json:"access"`
+	// Strategy is "round-robin" or "peak-ewma"
+	Strategy         string        `json:"strategy"`
+	OutlierDetection OutlierConfig `json:"outlier_detection"`
+}
+
+// TracingConfig selects where spans are exported
+type TracingConfig struct {
+	// Exporter is "stdout", "otlp" or empty to disable tracing
+	Exporter string `json:"exporter"`
+	// Endpoint is the OTLP/HTTP traces URL used by the otlp exporter
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"service_name"`
+}
+
+// DefaultConfig returns the configuration used when no file is given
+func DefaultConfig() Config {
+	return Config{
+		Port:                8081,
+		AdminAddr:           "localhost:9081",
+		HealthCheckInterval: Duration(time.Minute),
+		Backends: []string{
+			"http://localhost:8082",
+			"http://localhost:8083",
+			"http://localhost:8084",
+		},
+		SecurityHeaders: true,
+		Retries:         2,
+		Tracing: TracingConfig{
+			Endpoint:    tracing.DefaultOTLPEndpoint,
+			ServiceName: "load-balancer",
+		},
+		Strategy:         string(RoundRobin),
+		OutlierDetection: DefaultOutlierConfig(),
+	}
+}
+
+// LoadConfig reads a JSON config file on top of DefaultConfig and validates it
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the config for values the load balancer cannot run with
+func (c Config) Validate() error {
+	var errs []error
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d out of range", c.Port))
+	}
+	if c.HealthCheckInterval <= 0 {
+		errs = append(errs, errors.New("health_check_interval must be positive"))
+	}
+	if c.Retries < 0 {
+		errs = append(errs, errors.New("retries must not be negative"))
+	}
+	switch c.Tracing.Exporter {
+	case "", "stdout":
+	case "otlp":
+		if _, err := url.ParseRequestURI(c.Tracing.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("tracing endpoint: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown tracing exporter %q", c.Tracing.Exporter))
+	}
+	if _, err := parseStrategy(c.Strategy); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.OutlierDetection.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := NewAccessPolicy(c.Access); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.Backends) == 0 {
+		errs = append(errs, errors.New("at least one backend is required"))
+	}
+	for _, raw := range c.Backends {
+		if _, err := parseBackendURL(raw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BuildLoadBalancer creates the load balancer and its backends from the config
+func (c Config) BuildLoadBalancer() (*LoadBalancer, error) {
+	var opts BackendOptions
+	if c.SecurityHeaders {
+		opts.Modifiers = append(opts.Modifiers, SecurityHeaders(DefaultSecurityHeaders()))
+	}
+
+	strategy, err := parseStrategy(c.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := New()
+	lb.strategy = strategy
+	lb.outliers = c.OutlierDetection
+	if err := lb.Reload(c); err != nil {
+		return nil, err
+	}
+	for _, raw := range c.Backends {
+		u, err := parseBackendURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		lb.backends = append(lb.backends, NewBackend(u, opts))
+	}
+	lb.tracer = c.Tracing.newTracer()
+	return lb, nil
+}
+
+// Reload applies the settings that can change while the load balancer is
+// running: access rules and retries. Backends, listeners, compression,
+// tracing, the strategy and outlier detection are fixed at startup.
+func (lb *LoadBalancer) Reload(c Config) error {
+	policy, err := NewAccessPolicy(c.Access)
+	if err != nil {
+		return err
+	}
+	if c.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+
+	lb.access.Store(policy)
+	lb.retries.Store(int64(c.Retries))
+	return nil
+}
+
+// newTracer creates the tracer for the configured exporter, or nil when
+// tracing is disabled
+func (c TracingConfig) newTracer() *tracing.Tracer {
+	switch c.Exporter {
+	case "stdout":
+		return tracing.NewTracer(c.ServiceName, tracing.NewStdoutExporter(os.Stdout))
+	case "otlp":
+		return tracing.NewTracer(c.ServiceName, tracing.NewOTLPExporter(c.Endpoint, c.ServiceName))
+	}
+	return nil
+}
+
+func parseBackendURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("backend %q: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("backend %q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("backend %q: missing host", raw)
+	}
+	return u, nil
+}
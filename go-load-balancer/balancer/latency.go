@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWindow is the number of recent samples kept for percentiles
+	latencyWindow = 256
+	// ewmaDecay is the time constant of the peak EWMA. A sample's weight
+	// falls to 1/e after this long.
+	ewmaDecay = 10 * time.Second
+)
+
+// latencyStats tracks the response latency of one backend as a peak EWMA,
+// which reacts instantly to slow responses and decays slowly, and as a
+// sliding window of recent samples for percentiles
+type latencyStats struct {
+	mux     sync.Mutex
+	ewma    float64 // nanoseconds
+	updated time.Time
+	window  [latencyWindow]time.Duration
+	next    int
+	count   int
+}
+
+// observe records one response latency
+func (s *latencyStats) observe(d time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	rtt := float64(d)
+	switch {
+	case s.updated.IsZero():
+		s.ewma = rtt
+	case rtt > s.ewma:
+		// Peak sensitivity: jump straight to a slower observation
+		s.ewma = rtt
+	default:
+		w := s.weight(now)
+		s.ewma = s.ewma*w + rtt*(1-w)
+	}
+	s.updated = now
+
+	s.window[s.next] = d
+	s.next = (s.next + 1) % latencyWindow
+	if s.count < latencyWindow {
+		s.count++
+	}
+}
+
+// EWMA returns the current peak EWMA, zero when nothing was observed. It
+// keeps decaying while no samples arrive, or a backend that was slow once
+// would look slow until picked again, and never be picked.
+func (s *latencyStats) EWMA() time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.updated.IsZero() {
+		return 0
+	}
+	// At least a nanosecond, zero means unmeasured
+	return max(time.Duration(s.ewma*s.weight(time.Now())), 1)
+}
+
+// weight is how much of the EWMA is left at now, s.mux must be held
+func (s *latencyStats) weight(now time.Time) float64 {
+	return math.Exp(-float64(now.Sub(s.updated)) / float64(ewmaDecay))
+}
+
+// Percentile returns the q-th percentile (0-1) of the samples in the window
+// and the number of samples it was computed from
+func (s *latencyStats) Percentile(q float64) (time.Duration, int) {
+	s.mux.Lock()
+	samples := slices.Clone(s.window[:s.count])
+	s.mux.Unlock()
+
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	slices.Sort(samples)
+	idx := int(math.Ceil(q*float64(len(samples)))) - 1
+	idx = max(0, min(idx, len(samples)-1))
+	return samples[idx], len(samples)
+}
+
+// reset forgets all samples, used when a backend returns from ejection so it
+// is judged on fresh traffic
+func (s *latencyStats) reset() {
+	s.mux.Lock()
+	s.ewma = 0
+	s.updated = time.Time{}
+	s.next = 0
+	s.count = 0
+	s.mux.Unlock()
+}
@@ -0,0 +1,195 @@
+package balancer
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"load-balancer/tracing"
+)
+
+// LoadBalancer represents a load balancer
+type LoadBalancer struct {
+	backends []*Backend
+	current  uint64
+	strategy Strategy
+	outliers OutlierConfig
+	tracer   *tracing.Tracer
+
+	// Settings below can be replaced at runtime by Reload
+	retries atomic.Int64
+	access  atomic.Pointer[AccessPolicy]
+}
+
+// New creates a load balancer over the given backends
+func New(backends ...*Backend) *LoadBalancer {
+	return &LoadBalancer{
+		backends: backends,
+		strategy: RoundRobin,
+		outliers: DefaultOutlierConfig(),
+	}
+}
+
+// Backends returns the backends managed by the load balancer
+func (lb *LoadBalancer) Backends() []*Backend {
+	return lb.backends
+}
+
+// NextBackend returns the next available backend to handle the request.
+// Backends ejected as latency outliers are only used when every alive
+// backend is ejected.
+func (lb *LoadBalancer) NextBackend() *Backend {
+	if len(lb.backends) == 0 {
+		return nil
+	}
+
+	if lb.strategy == PeakEWMA {
+		candidates := make([]*Backend, 0, len(lb.backends))
+		for _, b := range lb.backends {
+			if b.Available() {
+				candidates = append(candidates, b)
+			}
+		}
+		if len(candidates) == 0 {
+			for _, b := range lb.backends {
+				if b.IsAlive() {
+					candidates = append(candidates, b)
+				}
+			}
+		}
+		return nextPeakEWMA(candidates)
+	}
+
+	// Simple round-robin
+	next := atomic.AddUint64(&lb.current, uint64(1)) % uint64(len(lb.backends))
+
+	// Find the next available backend
+	for i := 0; i < len(lb.backends); i++ {
+		idx := (int(next) + i) % len(lb.backends)
+		if lb.backends[idx].Available() {
+			return lb.backends[idx]
+		}
+	}
+	for i := 0; i < len(lb.backends); i++ {
+		idx := (int(next) + i) % len(lb.backends)
+		if lb.backends[idx].IsAlive() {
+			return lb.backends[idx]
+		}
+	}
+	return nil
+}
+
+// isBackendAlive checks whether a backend is alive by establishing a TCP connection
+func isBackendAlive(u *url.URL) bool {
+	timeout := 2 * time.Second
+	conn, err := net.DialTimeout("tcp", u.Host, timeout)
+	if err != nil {
+		log.Printf("Site unreachable: %s", err)
+		return false
+	}
+	defer conn.Close()
+	return true
+}
+
+// HealthCheck pings the backends and updates their status
+func (lb *LoadBalancer) HealthCheck() {
+	for _, b := range lb.backends {
+		status := isBackendAlive(b.URL)
+		b.SetAlive(status)
+		if status {
+			log.Printf("Backend %s is alive", b.URL)
+		} else {
+			log.Printf("Backend %s is dead", b.URL)
+		}
+	}
+}
+
+// HealthCheckPeriodically runs a routine health check every interval
+func (lb *LoadBalancer) HealthCheckPeriodically(interval time.Duration) {
+	t := time.NewTicker(interval)
+	for {
+		select {
+		case <-t.C:
+			lb.HealthCheck()
+		}
+	}
+}
+
+// Shutdown flushes pending spans when tracing is enabled
+func (lb *LoadBalancer) Shutdown(ctx context.Context) error {
+	if lb.tracer == nil {
+		return nil
+	}
+	return lb.tracer.Shutdown(ctx)
+}
+
+// attempt is the per-request retry state shared with the backend's
+// proxy error handler and ModifyResponse through the request context
+type attempt struct {
+	last bool
+	err  error
+	// start is when the request was sent, latency how long the backend
+	// took to return the response headers
+	start   time.Time
+	latency time.Duration
+	// scheme is the one the client used, for rewriting redirects
+	scheme string
+}
+
+type attemptKey struct{}
+
+func attemptFromContext(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// canRetry reports whether the request can safely be sent to another
+// backend after a failure. Requests with a body are not retried since the
+// body has already been consumed by the first attempt.
+func canRetry(r *http.Request) bool {
+	return r.Body == nil || r.Body == http.NoBody
+}
+
+// ServeHTTP implements the http.Handler interface for the LoadBalancer
+func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w, r, span := lb.startSpan(w, r)
+	if span != nil {
+		defer span.Finish()
+	}
+
+	// Reject the request before a backend is selected
+	if !lb.authorize(w, r) {
+		return
+	}
+
+	scheme := lb.access.Load().Scheme(r)
+	maxAttempts := 1
+	if canRetry(r) {
+		maxAttempts += int(lb.retries.Load())
+	}
+
+	for n := 1; ; n++ {
+		backend := lb.NextBackend()
+		if backend == nil {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Log the request
+		log.Printf("Routing request %s %s to backend %s (attempt %d)", r.Method, r.URL.Path, backend.URL, n)
+
+		// Forward the request to the backend
+		a := &attempt{last: n >= maxAttempts, scheme: scheme}
+		backend.serve(w, r.WithContext(context.WithValue(r.Context(), attemptKey{}, a)), a)
+		recordAttempt(span, backend, n, a.err)
+
+		// Nobody is waiting for the response once the client has gone away
+		if a.err == nil || a.last || r.Context().Err() != nil {
+			return
+		}
+	}
+}
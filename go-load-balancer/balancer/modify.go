@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ResponseModifier adjusts a response coming back from a backend before it
+// is copied to the client. It is installed as the ReverseProxy's ModifyResponse.
+type ResponseModifier func(*http.Response) error
+
+// ChainModifiers runs modifiers in order and stops at the first error
+func ChainModifiers(modifiers ...ResponseModifier) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		for _, m := range modifiers {
+			if err := m(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SecurityHeaders sets the given headers on every response that does not
+// already carry them
+func SecurityHeaders(headers map[string]string) ResponseModifier {
+	return func(resp *http.Response) error {
+		for name, value := range headers {
+			if resp.Header.Get(name) == "" {
+				resp.Header.Set(name, value)
+			}
+		}
+		return nil
+	}
+}
+
+// DefaultSecurityHeaders returns a conservative set of headers for
+// responses served through the balancer
+func DefaultSecurityHeaders() map[string]string {
+	return map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+	}
+}
+
+// RewriteLocation replaces the backend's host in Location and
+// Content-Location headers with the host the client originally requested,
+// so redirects do not leak internal addresses
+func RewriteLocation(backend *url.URL) ResponseModifier {
+	return func(resp *http.Response) error {
+		for _, name := range []string{"Location", "Content-Location"} {
+			value := resp.Header.Get(name)
+			if value == "" {
+				continue
+			}
+			loc, err := url.Parse(value)
+			if err != nil || !strings.EqualFold(loc.Host, backend.Host) {
+				continue
+			}
+			loc.Scheme = publicScheme(resp.Request)
+			loc.Host = resp.Request.Host
+			resp.Header.Set(name, loc.String())
+		}
+		return nil
+	}
+}
+
+// publicScheme returns the scheme the client used to reach the balancer, as
+// worked out by the access policy when the request was received
+func publicScheme(r *http.Request) string {
+	if a := attemptFromContext(r.Context()); a != nil && a.scheme != "" {
+		return a.scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
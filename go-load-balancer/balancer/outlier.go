@@ -0,0 +1,179 @@
+package balancer
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// OutlierConfig controls latency based outlier ejection. A backend whose p99
+// latency is more than Factor times the pool median p99 is taken out of
+// rotation for BaseEjection multiplied by the number of times it has been
+// ejected, capped at MaxEjection.
+type OutlierConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"`
+	Factor   float64  `json:"factor"`
+	// MinLatency ignores outliers whose p99 is below this, so a pool of
+	// fast backends does not eject one for being 3ms instead of 1ms
+	MinLatency Duration `json:"min_latency"`
+	// MinSamples is the number of recent responses a backend needs before
+	// it is judged or counted towards the median
+	MinSamples   int      `json:"min_samples"`
+	BaseEjection Duration `json:"base_ejection"`
+	MaxEjection  Duration `json:"max_ejection"`
+	// MaxEjectionPercent caps the share of the pool that can be ejected at once
+	MaxEjectionPercent int `json:"max_ejection_percent"`
+}
+
+// DefaultOutlierConfig returns conservative outlier detection settings
+func DefaultOutlierConfig() OutlierConfig {
+	return OutlierConfig{
+		Interval:           Duration(10 * time.Second),
+		Factor:             3,
+		MinLatency:         Duration(50 * time.Millisecond),
+		MinSamples:         20,
+		BaseEjection:       Duration(30 * time.Second),
+		MaxEjection:        Duration(5 * time.Minute),
+		MaxEjectionPercent: 50,
+	}
+}
+
+// Validate checks the outlier detection settings
+func (c OutlierConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	if c.Interval <= 0 {
+		errs = append(errs, errors.New("outlier_detection.interval must be positive"))
+	}
+	if c.Factor <= 1 {
+		errs = append(errs, errors.New("outlier_detection.factor must be greater than 1"))
+	}
+	if c.MinSamples < 1 {
+		errs = append(errs, errors.New("outlier_detection.min_samples must be at least 1"))
+	}
+	if c.BaseEjection <= 0 || c.MaxEjection < c.BaseEjection {
+		errs = append(errs, errors.New("outlier_detection.base_ejection must be positive and not above max_ejection"))
+	}
+	if c.MaxEjectionPercent < 0 || c.MaxEjectionPercent > 100 {
+		errs = append(errs, errors.New("outlier_detection.max_ejection_percent must be between 0 and 100"))
+	}
+	return errors.Join(errs...)
+}
+
+// DetectOutliers compares each backend's p99 latency against the pool
+// median and ejects the slow ones. Backends whose ejection has expired are
+// returned to rotation with their latency history cleared.
+func (lb *LoadBalancer) DetectOutliers() {
+	cfg := lb.outliers
+	now := time.Now()
+
+	type measured struct {
+		backend *Backend
+		p99     time.Duration
+	}
+	var sample []measured
+	ejected := 0
+	for _, b := range lb.backends {
+		if b.restoreIfExpired(now) {
+			continue
+		}
+		if b.IsEjected() {
+			ejected++
+			continue
+		}
+		if !b.IsAlive() {
+			continue
+		}
+		p99, n := b.latency.Percentile(0.99)
+		if n >= cfg.MinSamples {
+			sample = append(sample, measured{b, p99})
+		}
+	}
+
+	// A median needs at least three backends to say anything about one of them
+	if len(sample) < 3 {
+		return
+	}
+
+	p99s := make([]time.Duration, len(sample))
+	for i, m := range sample {
+		p99s[i] = m.p99
+	}
+	slices.Sort(p99s)
+	median := p99s[len(p99s)/2]
+	if len(p99s)%2 == 0 {
+		median = (p99s[len(p99s)/2-1] + p99s[len(p99s)/2]) / 2
+	}
+	threshold := time.Duration(float64(median) * cfg.Factor)
+
+	// Eject the slowest first so the cap keeps the worst ones out
+	slices.SortFunc(sample, func(a, b measured) int { return cmp.Compare(b.p99, a.p99) })
+	maxEjected := len(lb.backends) * cfg.MaxEjectionPercent / 100
+	for _, m := range sample {
+		if m.p99 <= threshold || m.p99 < time.Duration(cfg.MinLatency) {
+			// Healthy again, so the next ejection starts from the base time
+			m.backend.forgiveEjection()
+			continue
+		}
+		if ejected >= maxEjected {
+			log.Printf("Backend %s is a latency outlier (p99 %s, median %s) but the ejection cap is reached", m.backend.URL, m.p99, median)
+			continue
+		}
+		d := m.backend.eject(now, cfg)
+		ejected++
+		log.Printf("Ejecting backend %s for %s: p99 %s is above %.1fx the pool median %s", m.backend.URL, d, m.p99, cfg.Factor, median)
+	}
+}
+
+// DetectOutliersPeriodically runs outlier detection every configured interval
+func (lb *LoadBalancer) DetectOutliersPeriodically() {
+	if !lb.outliers.Enabled {
+		return
+	}
+	t := time.NewTicker(time.Duration(lb.outliers.Interval))
+	for range t.C {
+		lb.DetectOutliers()
+	}
+}
+
+// restoreIfExpired ends an expired ejection and reports whether it did
+func (b *Backend) restoreIfExpired(now time.Time) bool {
+	b.mux.Lock()
+	expired := !b.ejectedUntil.IsZero() && !now.Before(b.ejectedUntil)
+	if expired {
+		b.ejectedUntil = time.Time{}
+	}
+	b.mux.Unlock()
+
+	if expired {
+		b.latency.reset()
+		log.Printf("Backend %s returned from outlier ejection", b.URL)
+	}
+	return expired
+}
+
+// eject takes the backend out of rotation and returns the ejection time
+func (b *Backend) eject(now time.Time, cfg OutlierConfig) time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.ejections++
+	d := min(time.Duration(cfg.BaseEjection)*time.Duration(b.ejections), time.Duration(cfg.MaxEjection))
+	b.ejectedUntil = now.Add(d)
+	return d
+}
+
+// forgiveEjection lowers the ejection count of a backend that is no longer
+// an outlier
+func (b *Backend) forgiveEjection() {
+	b.mux.Lock()
+	if b.ejections > 0 {
+		b.ejections--
+	}
+	b.mux.Unlock()
+}
@@ -0,0 +1,308 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestBackend returns a backend with n latency samples of d
+func newTestBackend(t *testing.T, name string, d time.Duration, n int) *Backend {
+	t.Helper()
+	u, _ := url.Parse("http://" + name)
+	b := NewBackend(u, BackendOptions{})
+	for i := 0; i < n; i++ {
+		b.latency.observe(d)
+	}
+	return b
+}
+
+func testOutlierConfig() OutlierConfig {
+	cfg := DefaultOutlierConfig()
+	cfg.Enabled = true
+	cfg.MinSamples = 10
+	cfg.MinLatency = Duration(10 * time.Millisecond)
+	cfg.MaxEjectionPercent = 100
+	return cfg
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	var s latencyStats
+	if p, n := s.Percentile(0.99); p != 0 || n != 0 {
+		t.Errorf("empty stats: %s from %d samples", p, n)
+	}
+
+	for i := 1; i <= 100; i++ {
+		s.observe(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if p, n := s.Percentile(tt.q); p != tt.expected || n != 100 {
+			t.Errorf("Percentile(%v) = %s from %d samples, want %s", tt.q, p, n, tt.expected)
+		}
+	}
+
+	// Only the most recent samples count
+	for i := 0; i < latencyWindow; i++ {
+		s.observe(time.Second)
+	}
+	if p, n := s.Percentile(0); p != time.Second || n != latencyWindow {
+		t.Errorf("old samples kept: min %s from %d samples", p, n)
+	}
+
+	s.reset()
+	if p, n := s.Percentile(0.99); p != 0 || n != 0 || s.EWMA() != 0 {
+		t.Errorf("after reset: %s from %d samples", p, n)
+	}
+}
+
+func TestLatencyPeakEWMA(t *testing.T) {
+	var s latencyStats
+	// Reads decay the value a little, allow for the time the test takes
+	near := func(got, want time.Duration) bool {
+		return got <= want && got > want*99/100
+	}
+
+	s.observe(10 * time.Millisecond)
+	if got := s.EWMA(); !near(got, 10*time.Millisecond) {
+		t.Errorf("first sample: EWMA %s", got)
+	}
+	// A slower response is taken at once
+	s.observe(200 * time.Millisecond)
+	if got := s.EWMA(); !near(got, 200*time.Millisecond) {
+		t.Errorf("peak: EWMA %s", got)
+	}
+	// Faster ones only pull it down gradually
+	s.observe(10 * time.Millisecond)
+	if got := s.EWMA(); got <= 10*time.Millisecond || got > 200*time.Millisecond {
+		t.Errorf("decay: EWMA %s", got)
+	}
+
+	// Without samples it keeps decaying
+	s.mux.Lock()
+	s.updated = s.updated.Add(-ewmaDecay)
+	s.mux.Unlock()
+	if got := s.EWMA(); got > 200*time.Millisecond/2 {
+		t.Errorf("after %s without samples: EWMA %s", ewmaDecay, got)
+	}
+}
+
+func TestDetectOutliers(t *testing.T) {
+	tests := []struct {
+		name     string
+		p99s     []time.Duration
+		samples  int
+		expected []bool
+	}{
+		{"Slow backend is ejected",
+			[]time.Duration{20 * time.Millisecond, 22 * time.Millisecond, 25 * time.Millisecond, 200 * time.Millisecond}, 20,
+			[]bool{false, false, false, true}},
+		{"Within the factor of the median",
+			[]time.Duration{20 * time.Millisecond, 22 * time.Millisecond, 25 * time.Millisecond, 60 * time.Millisecond}, 20,
+			[]bool{false, false, false, false}},
+		{"Below the minimum latency",
+			[]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond, 8 * time.Millisecond}, 20,
+			[]bool{false, false, false, false}},
+		{"Too few samples",
+			[]time.Duration{20 * time.Millisecond, 22 * time.Millisecond, 25 * time.Millisecond, 200 * time.Millisecond}, 5,
+			[]bool{false, false, false, false}},
+		{"Too few backends for a median",
+			[]time.Duration{20 * time.Millisecond, 200 * time.Millisecond}, 20,
+			[]bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backends []*Backend
+			for i, d := range tt.p99s {
+				backends = append(backends, newTestBackend(t, fmt.Sprintf("b%d", i), d, tt.samples))
+			}
+			lb := New(backends...)
+			lb.outliers = testOutlierConfig()
+			lb.DetectOutliers()
+
+			for i, b := range backends {
+				if b.IsEjected() != tt.expected[i] {
+					t.Errorf("backend %d with p99 %s: ejected = %v, want %v", i, tt.p99s[i], b.IsEjected(), tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDetectOutliersCap(t *testing.T) {
+	fast, slow := 20*time.Millisecond, 300*time.Millisecond
+	backends := []*Backend{
+		newTestBackend(t, "b0", fast, 20),
+		newTestBackend(t, "b1", fast, 20),
+		newTestBackend(t, "b2", fast, 20),
+		newTestBackend(t, "b3", slow, 20),
+		newTestBackend(t, "b4", 2*slow, 20),
+	}
+	lb := New(backends...)
+	lb.outliers = testOutlierConfig()
+	// One of five backends may be out at a time
+	lb.outliers.MaxEjectionPercent = 20
+	lb.DetectOutliers()
+
+	if !backends[4].IsEjected() {
+		t.Error("slowest backend was not ejected")
+	}
+	if backends[3].IsEjected() {
+		t.Error("ejection cap was ignored")
+	}
+
+	// The ejected backend still counts against the cap on the next run
+	lb.DetectOutliers()
+	if backends[3].IsEjected() {
+		t.Error("ejection cap was ignored on the second run")
+	}
+}
+
+func TestDetectOutliersRestores(t *testing.T) {
+	backends := []*Backend{
+		newTestBackend(t, "b0", 20*time.Millisecond, 20),
+		newTestBackend(t, "b1", 20*time.Millisecond, 20),
+		newTestBackend(t, "b2", 20*time.Millisecond, 20),
+		newTestBackend(t, "b3", 200*time.Millisecond, 20),
+	}
+	lb := New(backends...)
+	lb.outliers = testOutlierConfig()
+	lb.DetectOutliers()
+
+	slow := backends[3]
+	if !slow.IsEjected() {
+		t.Fatal("slow backend was not ejected")
+	}
+	if lb.NextBackend() == slow {
+		t.Error("ejected backend was picked")
+	}
+
+	// Still ejected before the cooldown ends
+	lb.DetectOutliers()
+	if !slow.IsEjected() {
+		t.Fatal("backend restored before its ejection ended")
+	}
+
+	// Let the ejection expire
+	slow.mux.Lock()
+	slow.ejectedUntil = time.Now().Add(-time.Second)
+	slow.mux.Unlock()
+	lb.DetectOutliers()
+
+	if slow.IsEjected() {
+		t.Error("backend not restored after its ejection ended")
+	}
+	if _, n := slow.latency.Percentile(0.99); n != 0 {
+		t.Errorf("restored backend kept %d old samples", n)
+	}
+
+	// A second ejection lasts longer
+	for i := 0; i < 20; i++ {
+		slow.latency.observe(200 * time.Millisecond)
+	}
+	lb.DetectOutliers()
+	slow.mux.RLock()
+	remaining := time.Until(slow.ejectedUntil)
+	slow.mux.RUnlock()
+	if base := time.Duration(lb.outliers.BaseEjection); remaining <= base {
+		t.Errorf("second ejection lasts %s, want more than %s", remaining, base)
+	}
+}
+
+func TestNextPeakEWMA(t *testing.T) {
+	fast := newTestBackend(t, "fast", 10*time.Millisecond, 1)
+	slow := newTestBackend(t, "slow", 100*time.Millisecond, 1)
+
+	// With two candidates both are always compared
+	for i := 0; i < 20; i++ {
+		if got := nextPeakEWMA([]*Backend{slow, fast}); got != fast {
+			t.Fatalf("picked %s", got.URL)
+		}
+	}
+
+	// Requests in flight raise the cost of the fast backend above the slow one
+	fast.inflight.Add(20)
+	if got := nextPeakEWMA([]*Backend{slow, fast}); got != slow {
+		t.Errorf("picked %s with 20 requests in flight on fast", got.URL)
+	}
+	fast.inflight.Add(-20)
+
+	// An unmeasured backend is preferred while idle, but not flooded
+	fresh := newTestBackend(t, "fresh", 0, 0)
+	if got := nextPeakEWMA([]*Backend{slow, fresh}); got != fresh {
+		t.Errorf("idle unmeasured backend not probed, picked %s", got.URL)
+	}
+	fresh.inflight.Add(1)
+	if got := nextPeakEWMA([]*Backend{slow, fresh}); got != slow {
+		t.Errorf("unmeasured backend picked again before its first response")
+	}
+
+	// A backend that was slow once is picked again once its cost decayed
+	slowOnce := newTestBackend(t, "slow-once", time.Second, 1)
+	if got := nextPeakEWMA([]*Backend{slowOnce, fast}); got != fast {
+		t.Fatalf("picked %s right after its slow response", got.URL)
+	}
+	slowOnce.latency.mux.Lock()
+	slowOnce.latency.updated = slowOnce.latency.updated.Add(-10 * ewmaDecay)
+	slowOnce.latency.mux.Unlock()
+	if got := nextPeakEWMA([]*Backend{slowOnce, fast}); got != slowOnce {
+		t.Errorf("picked %s, slow backend still starved after %s", got.URL, 10*ewmaDecay)
+	}
+
+	if nextPeakEWMA(nil) != nil {
+		t.Error("picked a backend from no candidates")
+	}
+	if nextPeakEWMA([]*Backend{slow}) != slow {
+		t.Error("single candidate not picked")
+	}
+}
+
+// slowWriter is a client that takes long to read the response body
+type slowWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestLatencyExcludesSlowClients(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	b := NewBackend(u, BackendOptions{})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	a := &attempt{last: true}
+	w := &slowWriter{ResponseRecorder: httptest.NewRecorder(), delay: 200 * time.Millisecond}
+	b.serve(w, r.WithContext(context.WithValue(r.Context(), attemptKey{}, a)), a)
+
+	if w.Body.String() != "hello" {
+		t.Fatalf("body %q", w.Body)
+	}
+	p, n := b.latency.Percentile(1)
+	if n != 1 {
+		t.Fatalf("%d samples recorded", n)
+	}
+	if p >= 200*time.Millisecond {
+		t.Errorf("latency %s includes the time the client took to read", p)
+	}
+}
@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// BackendStatus is the reported state of a single backend
+type BackendStatus struct {
+	URL      string  `json:"url"`
+	Alive    bool    `json:"alive"`
+	Ejected  bool    `json:"ejected"`
+	InFlight int64   `json:"in_flight"`
+	EWMAMs   float64 `json:"ewma_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+}
+
+// Status is the payload served by the admin status endpoint
+type Status struct {
+	Strategy string          `json:"strategy"`
+	Backends []BackendStatus `json:"backends"`
+}
+
+// Status returns a snapshot of the backends and their health
+func (lb *LoadBalancer) Status() Status {
+	s := Status{
+		Strategy: string(lb.strategy),
+		Backends: make([]BackendStatus, 0, len(lb.backends)),
+	}
+	for _, b := range lb.backends {
+		ewma, p99 := b.Latency(0.99)
+		s.Backends = append(s.Backends, BackendStatus{
+			URL:      b.URL.String(),
+			Alive:    b.IsAlive(),
+			Ejected:  b.IsEjected(),
+			InFlight: b.InFlight(),
+			EWMAMs:   milliseconds(ewma),
+			P99Ms:    milliseconds(p99),
+		})
+	}
+	return s
+}
+
+// StatusHandler serves the load balancer status as JSON. It is meant to be
+// mounted on a separate admin listener so it never shadows a backend path.
+func (lb *LoadBalancer) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lb.Status())
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
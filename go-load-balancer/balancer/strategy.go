@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Strategy selects how NextBackend chooses between available backends
+type Strategy string
+
+const (
+	// RoundRobin cycles through the backends in order
+	RoundRobin Strategy = "round-robin"
+	// PeakEWMA picks the cheaper of two random backends, where the cost is
+	// the backend's peak EWMA latency weighted by its in-flight requests
+	PeakEWMA Strategy = "peak-ewma"
+)
+
+// unmeasuredPenalty is the cost assigned to a backend with requests in
+// flight but no latency sample yet, so a new backend is probed without
+// being flooded before its first response comes back
+const unmeasuredPenalty = float64(time.Minute)
+
+func parseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", RoundRobin:
+		return RoundRobin, nil
+	case PeakEWMA:
+		return PeakEWMA, nil
+	}
+	return "", fmt.Errorf("unknown strategy %q", s)
+}
+
+// cost is the peak EWMA load estimate of a backend
+func (b *Backend) cost() float64 {
+	ewma := float64(b.latency.EWMA())
+	inflight := float64(b.InFlight())
+	if ewma == 0 {
+		if inflight == 0 {
+			return 0
+		}
+		return unmeasuredPenalty + inflight
+	}
+	return ewma * (inflight + 1)
+}
+
+// nextPeakEWMA applies power of two choices over the candidates
+func nextPeakEWMA(candidates []*Backend) *Backend {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	if b.cost() < a.cost() {
+		return b
+	}
+	return a
+}
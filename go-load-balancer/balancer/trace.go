@@ -0,0 +1,75 @@
+package balancer
+
+import (
+	"net/http"
+
+	"load-balancer/tracing"
+)
+
+// startSpan starts a server span for the request when tracing is enabled and
+// propagates its context to the backend through the traceparent header. The
+// returned span is nil when tracing is disabled.
+func (lb *LoadBalancer) startSpan(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, *tracing.Span) {
+	if lb.tracer == nil {
+		return w, r, nil
+	}
+
+	span := lb.tracer.StartServerSpan(r)
+	r = r.WithContext(tracing.ContextWithSpan(r.Context(), span))
+	tracing.Inject(span.Context(), r.Header)
+
+	return &tracedWriter{ResponseWriter: w, span: span}, r, span
+}
+
+// tracedWriter records the response status on the span
+type tracedWriter struct {
+	http.ResponseWriter
+	span        *tracing.Span
+	wroteHeader bool
+}
+
+func (tw *tracedWriter) WriteHeader(status int) {
+	// Informational responses are followed by the real status
+	if !tw.wroteHeader && status >= http.StatusOK {
+		tw.wroteHeader = true
+		tw.span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			tw.span.SetStatus(tracing.StatusError, http.StatusText(status))
+		}
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *tracedWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController so
+// flushing and protocol upgrades keep working through the wrapper
+func (tw *tracedWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// recordAttempt adds the outcome of one proxy attempt to the span
+func recordAttempt(span *tracing.Span, b *Backend, n int, err error) {
+	if span == nil {
+		return
+	}
+
+	attrs := map[string]any{
+		"lb.attempt":     n,
+		"lb.backend.url": b.URL.String(),
+	}
+	if err != nil {
+		attrs["error.message"] = err.Error()
+	}
+	span.AddEvent("lb.attempt", attrs)
+
+	span.SetAttribute("lb.backend.url", b.URL.String())
+	span.SetAttribute("server.upstream.address", b.URL.Host)
+	span.SetAttribute("lb.attempts", n)
+	span.SetAttribute("lb.retries", n-1)
+}
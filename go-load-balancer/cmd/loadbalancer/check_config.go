@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"load-balancer/balancer"
+)
+
+func checkConfig(args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to the JSON config file to validate")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return errors.New("-config is required")
+	}
+
+	cfg, err := balancer.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: OK\n", *configPath)
+	fmt.Printf("  port:                  %d\n", cfg.Port)
+	fmt.Printf("  admin_addr:            %s\n", cfg.AdminAddr)
+	fmt.Printf("  health_check_interval: %s\n", time.Duration(cfg.HealthCheckInterval))
+	fmt.Printf("  compress:              %t\n", cfg.Compress)
+	fmt.Printf("  security_headers:      %t\n", cfg.SecurityHeaders)
+	fmt.Printf("  retries:               %d\n", cfg.Retries)
+	fmt.Printf("  strategy:              %s\n", cfg.Strategy)
+	if od := cfg.OutlierDetection; od.Enabled {
+		fmt.Printf("  outlier detection:     p99 > %.1fx median every %s, eject %s-%s\n",
+			od.Factor, time.Duration(od.Interval), time.Duration(od.BaseEjection), time.Duration(od.MaxEjection))
+	} else {
+		fmt.Printf("  outlier detection:     disabled\n")
+	}
+	if cfg.Tracing.Exporter != "" {
+		fmt.Printf("  tracing:               %s (%s)\n", cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
+		if cfg.Tracing.Exporter == "otlp" {
+			fmt.Printf("  tracing endpoint:      %s\n", cfg.Tracing.Endpoint)
+		}
+	} else {
+		fmt.Printf("  tracing:               disabled\n")
+	}
+	fmt.Printf("  access allow:          %s\n", listOrNone(cfg.Access.Allow))
+	fmt.Printf("  access deny:           %s\n", listOrNone(cfg.Access.Deny))
+	fmt.Printf("  trusted proxies:       %s\n", listOrNone(cfg.Access.TrustedProxies))
+	fmt.Printf("  basic auth users:      %d\n", len(cfg.Access.Users))
+	fmt.Printf("  bearer tokens:         %d\n", len(cfg.Access.BearerTokens))
+	fmt.Printf("  backends:\n")
+	for _, b := range cfg.Backends {
+		fmt.Printf("    - %s\n", b)
+	}
+	return nil
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
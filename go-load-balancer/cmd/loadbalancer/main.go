@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a CLI subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve-lb", "run the load balancer", serveLB},
+	{"serve-backend", "run a simple backend server for testing", serveBackend},
+	{"check-config", "validate a load balancer config file", checkConfig},
+	{"status", "show the backend status of a running load balancer", status},
+	{"serve-collector", "run a minimal OTLP/HTTP collector that prints received spans", serveCollector},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: loadbalancer <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'loadbalancer <command> -h' for command flags.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if name != "-h" && name != "help" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
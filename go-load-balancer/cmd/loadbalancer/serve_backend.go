@@ -9,10 +9,11 @@ import (
 	"time"
 )
 
-func main() {
-	// Parse command line flags
-	port := flag.Int("port", 8081, "Port to serve on")
-	flag.Parse()
+func serveBackend(args []string) error {
+	fs := flag.NewFlagSet("serve-backend", flag.ExitOnError)
+	port := fs.Int("port", 8082, "Port to serve on")
+	delay := fs.Duration("delay", 100*time.Millisecond, "Delay added to each request to simulate processing time")
+	fs.Parse(args)
 
 	// Create a simple HTTP server
 	mux := http.NewServeMux()
@@ -26,7 +27,7 @@ func main() {
 		}
 
 		// Add a short delay to simulate processing time (optional)
-		time.Sleep(100 * time.Millisecond)
+		time.Sleep(*delay)
 
 		// Log the request
 		log.Printf("Backend %d received request: %s %s", *port, r.Method, r.URL.Path)
@@ -59,7 +60,5 @@ func main() {
 	}
 
 	log.Printf("Backend server started at :%d\n", *port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
-	}
+	return runServers(server)
 }
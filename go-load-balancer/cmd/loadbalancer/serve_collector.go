@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"load-balancer/tracing"
+)
+
+// serveCollector runs a stand-in for an OpenTelemetry collector. It accepts
+// OTLP/JSON trace exports and prints one line per span, which is enough to
+// check propagation locally without running a real collector.
+func serveCollector(args []string) error {
+	fs := flag.NewFlagSet("serve-collector", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:4318", "Address to listen on")
+	fs.Parse(args)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			http.Error(w, "only OTLP/JSON is supported", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var req tracing.ExportTraceServiceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, rs := range req.ResourceSpans {
+			service := attribute(rs.Resource.Attributes, "service.name")
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					printSpan(service, s)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	})
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	log.Printf("Collector listening at http://%s/v1/traces\n", *addr)
+	return runServers(server)
+}
+
+func attribute(kvs []tracing.KeyValue, key string) string {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return kv.Value.String()
+		}
+	}
+	return ""
+}
+
+func printSpan(service string, s tracing.OTLPSpan) {
+	attrs := make([]string, 0, len(s.Attributes))
+	for _, kv := range s.Attributes {
+		attrs = append(attrs, kv.Key+"="+kv.Value.String())
+	}
+	sort.Strings(attrs)
+
+	parent := s.ParentSpanID
+	if parent == "" {
+		parent = "-"
+	}
+	fmt.Printf("[%s] trace=%s span=%s parent=%s %q %s\n",
+		service, s.TraceID, s.SpanID, parent, s.Name, strings.Join(attrs, " "))
+	for _, ev := range s.Events {
+		evAttrs := make([]string, 0, len(ev.Attributes))
+		for _, kv := range ev.Attributes {
+			evAttrs = append(evAttrs, kv.Key+"="+kv.Value.String())
+		}
+		sort.Strings(evAttrs)
+		fmt.Printf("    event %s %s\n", ev.Name, strings.Join(evAttrs, " "))
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"load-balancer/balancer"
+)
+
+func serveLB(args []string) error {
+	fs := flag.NewFlagSet("serve-lb", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to a JSON config file (flags below are ignored when set)")
+	defaults := balancer.DefaultConfig()
+	port := fs.Int("port", defaults.Port, "Port to serve on")
+	adminAddr := fs.String("admin-addr", defaults.AdminAddr, "Address for the admin status endpoint, empty to disable")
+	checkInterval := fs.Duration("check-interval", time.Duration(defaults.HealthCheckInterval), "Interval for health checking backends")
+	backends := fs.String("backends", strings.Join(defaults.Backends, ","), "Comma-separated list of backend URLs")
+	compress := fs.Bool("compress", defaults.Compress, "Compress responses with gzip or deflate when the client accepts it")
+	securityHeaders := fs.Bool("security-headers", defaults.SecurityHeaders, "Add security headers to backend responses")
+	retries := fs.Int("retries", defaults.Retries, "Number of other backends to try when a backend cannot be reached")
+	traceExporter := fs.String("trace-exporter", defaults.Tracing.Exporter, "Span exporter: stdout, otlp or empty to disable tracing")
+	traceEndpoint := fs.String("trace-endpoint", defaults.Tracing.Endpoint, "OTLP/HTTP traces endpoint for the otlp exporter")
+	strategy := fs.String("strategy", defaults.Strategy, "Balancing strategy: round-robin or peak-ewma")
+	outlierDetection := fs.Bool("outlier-detection", defaults.OutlierDetection.Enabled, "Eject backends whose p99 latency is far above the pool median")
+	fs.Parse(args)
+
+	var cfg balancer.Config
+	if *configPath != "" {
+		var err error
+		if cfg, err = balancer.LoadConfig(*configPath); err != nil {
+			return err
+		}
+	} else {
+		cfg = balancer.Config{
+			Port:                *port,
+			AdminAddr:           *adminAddr,
+			HealthCheckInterval: balancer.Duration(*checkInterval),
+			Backends:            strings.Split(*backends, ","),
+			Compress:            *compress,
+			SecurityHeaders:     *securityHeaders,
+			Retries:             *retries,
+			Tracing: balancer.TracingConfig{
+				Exporter:    *traceExporter,
+				Endpoint:    *traceEndpoint,
+				ServiceName: defaults.Tracing.ServiceName,
+			},
+			Strategy:         *strategy,
+			OutlierDetection: defaults.OutlierDetection,
+		}
+		cfg.OutlierDetection.Enabled = *outlierDetection
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+	}
+
+	lb, err := cfg.BuildLoadBalancer()
+	if err != nil {
+		return err
+	}
+	for _, b := range lb.Backends() {
+		log.Printf("Configured backend: %s", b.URL)
+	}
+
+	// Re-read the config file on SIGHUP
+	if *configPath != "" {
+		go reloadOnSignal(*configPath, cfg, lb)
+	}
+
+	// Initial health check
+	lb.HealthCheck()
+
+	// Start periodic health check
+	go lb.HealthCheckPeriodically(time.Duration(cfg.HealthCheckInterval))
+
+	// Start latency outlier detection
+	go lb.DetectOutliersPeriodically()
+
+	var handler http.Handler = lb
+	if cfg.Compress {
+		handler = balancer.Compress(handler, balancer.DefaultCompressionConfig())
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: handler,
+		// Set reasonable timeouts
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	servers := []*http.Server{server}
+
+	if cfg.AdminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/status", lb.StatusHandler())
+		servers = append(servers, &http.Server{
+			Addr:              cfg.AdminAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		})
+		log.Printf("Admin endpoint at http://%s/status\n", cfg.AdminAddr)
+	}
+
+	log.Printf("Load Balancer started at :%d\n", cfg.Port)
+	err = runServers(servers...)
+
+	// Flush spans recorded before shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if shutdownErr := lb.Shutdown(ctx); shutdownErr != nil {
+		log.Printf("Failed to shut down tracer: %v", shutdownErr)
+	}
+	return err
+}
+
+// reloadOnSignal reloads the config file every time the process receives
+// SIGHUP and applies the settings that can change at runtime
+func reloadOnSignal(path string, current balancer.Config, lb *balancer.LoadBalancer) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		cfg, err := balancer.LoadConfig(path)
+		if err != nil {
+			log.Printf("Config reload failed, keeping current settings: %v", err)
+			continue
+		}
+		if err := lb.Reload(cfg); err != nil {
+			log.Printf("Config reload failed, keeping current settings: %v", err)
+			continue
+		}
+		if !slices.Equal(cfg.Backends, current.Backends) || cfg.Port != current.Port ||
+			cfg.AdminAddr != current.AdminAddr || cfg.Compress != current.Compress ||
+			cfg.SecurityHeaders != current.SecurityHeaders || cfg.Tracing != current.Tracing ||
+			cfg.HealthCheckInterval != current.HealthCheckInterval ||
+			cfg.Strategy != current.Strategy || cfg.OutlierDetection != current.OutlierDetection {
+			log.Printf("Config reloaded; changes to backends, listeners, compression, headers, tracing, health checks, strategy or outlier detection need a restart")
+		} else {
+			log.Printf("Config reloaded")
+		}
+	}
+}
+
+// runServers starts the servers and shuts them all down gracefully on
+// SIGINT/SIGTERM or when any of them fails
+func runServers(servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s *http.Server) {
+			if err := s.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}(s)
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, s := range servers {
+		s.Shutdown(shutdownCtx)
+	}
+	return err
+}
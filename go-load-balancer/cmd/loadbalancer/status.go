@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"load-balancer/balancer"
+)
+
+func status(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	admin := fs.String("admin", "http://"+balancer.DefaultConfig().AdminAddr, "Base URL of the load balancer admin endpoint")
+	asJSON := fs.Bool("json", false, "Print the raw JSON status")
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(*admin, "/") + "/status")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	var s balancer.Status
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return fmt.Errorf("decode status: %w", err)
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(s, "", "  ")
+		fmt.Println(string(out))
+		return nil
+	}
+
+	fmt.Printf("strategy: %s\n\n", s.Strategy)
+	fmt.Printf("%-40s %-8s %9s %9s %9s\n", "BACKEND", "STATE", "IN-FLIGHT", "EWMA", "P99")
+	alive := 0
+	for _, b := range s.Backends {
+		state := "dead"
+		if b.Alive {
+			state = "alive"
+			alive++
+		}
+		if b.Alive && b.Ejected {
+			state = "ejected"
+		}
+		fmt.Printf("%-40s %-8s %9d %7.1fms %7.1fms\n", b.URL, state, b.InFlight, b.EWMAMs, b.P99Ms)
+	}
+	fmt.Printf("\n%d/%d backends alive\n", alive, len(s.Backends))
+	return nil
+}
@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultOTLPEndpoint is the standard OTLP/HTTP traces endpoint of a local collector
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// OTLP span kinds, see opentelemetry-proto trace.proto
+const otlpSpanKindServer = 2
+
+// The types below are the subset of the OTLP/JSON trace encoding used by the
+// exporter. Field names follow the protobuf JSON mapping.
+
+// ExportTraceServiceRequest is the body of an OTLP/HTTP traces request
+type ExportTraceServiceRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+// ResourceSpans groups the spans produced by one service
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// Resource describes the entity producing spans
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeSpans groups spans by instrumentation scope
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+// Scope names the instrumentation library
+type Scope struct {
+	Name string `json:"name"`
+}
+
+// OTLPSpan is a span in OTLP/JSON form
+type OTLPSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	TraceState        string      `json:"traceState,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []KeyValue  `json:"attributes,omitempty"`
+	Events            []OTLPEvent `json:"events,omitempty"`
+	Status            OTLPStatus  `json:"status"`
+}
+
+// OTLPEvent is a span event in OTLP/JSON form
+type OTLPEvent struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes,omitempty"`
+}
+
+// OTLPStatus is a span status in OTLP/JSON form
+type OTLPStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// KeyValue is an attribute in OTLP/JSON form
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds exactly one typed attribute value
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// String returns the value formatted for display
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// OTLPExporter posts spans as OTLP/JSON to a collector's HTTP endpoint
+type OTLPExporter struct {
+	Endpoint string
+	Service  string
+	Client   *http.Client
+}
+
+// NewOTLPExporter creates an exporter for the given endpoint, falling back
+// to DefaultOTLPEndpoint when it is empty
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Service:  service,
+		Client:   &http.Client{Timeout: exportTimeout},
+	}
+}
+
+// ExportSpans sends one OTLP request containing all spans
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown is a no-op, the tracer flushes before calling it
+func (e *OTLPExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func (e *OTLPExporter) request(spans []*Span) ExportTraceServiceRequest {
+	out := make([]OTLPSpan, 0, len(spans))
+	for _, s := range spans {
+		span := OTLPSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.TraceState,
+			Name:              s.Name,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        keyValues(s.Attributes),
+			Status:            OTLPStatus{Code: int(s.Status), Message: s.StatusMessage},
+		}
+		if s.ParentSpanID.IsValid() {
+			span.ParentSpanID = s.ParentSpanID.String()
+		}
+		for _, ev := range s.Events {
+			span.Events = append(span.Events, OTLPEvent{
+				TimeUnixNano: unixNano(ev.Time),
+				Name:         ev.Name,
+				Attributes:   keyValues(ev.Attributes),
+			})
+		}
+		out = append(out, span)
+	}
+
+	return ExportTraceServiceRequest{
+		ResourceSpans: []ResourceSpans{{
+			Resource: Resource{Attributes: keyValues(map[string]any{"service.name": e.Service})},
+			ScopeSpans: []ScopeSpans{{
+				Scope: Scope{Name: "load-balancer/tracing"},
+				Spans: out,
+			}},
+		}},
+	}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func keyValues(attrs map[string]any) []KeyValue {
+	kvs := make([]KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, KeyValue{Key: k, Value: anyValue(v)})
+	}
+	return kvs
+}
+
+func anyValue(v any) AnyValue {
+	switch v := v.(type) {
+	case string:
+		return AnyValue{StringValue: &v}
+	case bool:
+		return AnyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return AnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return AnyValue{IntValue: &s}
+	case float64:
+		return AnyValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return AnyValue{StringValue: &s}
+	}
+}
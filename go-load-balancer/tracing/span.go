@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StatusCode is the outcome of a span
+type StatusCode int
+
+// Span status codes, numbered as in OpenTelemetry
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	default:
+		return "unset"
+	}
+}
+
+// Event is a timestamped annotation on a span
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]any
+}
+
+// Span records a single operation. Attribute values should be strings,
+// booleans, integers or floats so every exporter can encode them.
+type Span struct {
+	Name          string
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID
+	TraceState    string
+	Sampled       bool
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]any
+	Events        []Event
+	Status        StatusCode
+	StatusMessage string
+
+	mux    sync.Mutex
+	tracer *Tracer
+	ended  bool
+}
+
+// Context returns the span context to propagate to downstream services
+func (s *Span) Context() SpanContext {
+	return SpanContext{
+		TraceID:    s.TraceID,
+		SpanID:     s.SpanID,
+		Sampled:    s.Sampled,
+		TraceState: s.TraceState,
+	}
+}
+
+// SetAttribute records a key/value pair on the span
+func (s *Span) SetAttribute(key string, value any) {
+	s.mux.Lock()
+	s.Attributes[key] = value
+	s.mux.Unlock()
+}
+
+// AddEvent appends a timestamped event to the span
+func (s *Span) AddEvent(name string, attrs map[string]any) {
+	s.mux.Lock()
+	s.Events = append(s.Events, Event{Name: name, Time: time.Now(), Attributes: attrs})
+	s.mux.Unlock()
+}
+
+// SetStatus records the outcome of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.mux.Lock()
+	s.Status = code
+	s.StatusMessage = message
+	s.mux.Unlock()
+}
+
+// Finish ends the span and hands it to the tracer for export. Calling it
+// more than once has no effect.
+func (s *Span) Finish() {
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mux.Unlock()
+
+	if s.tracer != nil && s.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying the span
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span stored in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
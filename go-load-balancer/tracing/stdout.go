@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// StdoutExporter writes each span as one JSON object per line
+type StdoutExporter struct {
+	mux sync.Mutex
+	enc *json.Encoder
+}
+
+// NewStdoutExporter creates an exporter writing JSON lines to w
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{enc: json.NewEncoder(w)}
+}
+
+type jsonEvent struct {
+	Name       string         `json:"name"`
+	Time       time.Time      `json:"time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+type jsonSpan struct {
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	TraceState    string         `json:"tracestate,omitempty"`
+	Name          string         `json:"name"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	DurationMs    float64        `json:"duration_ms"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Events        []jsonEvent    `json:"events,omitempty"`
+	Status        string         `json:"status"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// ExportSpans writes the spans to the underlying writer
+func (e *StdoutExporter) ExportSpans(_ context.Context, spans []*Span) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	for _, s := range spans {
+		out := jsonSpan{
+			TraceID:       s.TraceID.String(),
+			SpanID:        s.SpanID.String(),
+			TraceState:    s.TraceState,
+			Name:          s.Name,
+			Start:         s.Start,
+			End:           s.End,
+			DurationMs:    float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+			Attributes:    s.Attributes,
+			Status:        s.Status.String(),
+			StatusMessage: s.StatusMessage,
+		}
+		if s.ParentSpanID.IsValid() {
+			out.ParentSpanID = s.ParentSpanID.String()
+		}
+		for _, ev := range s.Events {
+			out.Events = append(out.Events, jsonEvent(ev))
+		}
+		if err := e.enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown is a no-op, spans are written as they are exported
+func (e *StdoutExporter) Shutdown(context.Context) error {
+	return nil
+}
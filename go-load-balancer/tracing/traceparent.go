@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Header names defined by the W3C Trace Context specification
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// TraceID identifies a whole trace
+type TraceID [16]byte
+
+// SpanID identifies a single span within a trace
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the trace id is not all zeroes
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the span id is not all zeroes
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span that is propagated between services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// ParseTraceparent decodes a version 00 traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, errors.New("traceparent: expected 4 fields")
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	var v [1]byte
+	if err := decodeHex(v[:], version); err != nil || version == "ff" {
+		return sc, fmt.Errorf("traceparent: invalid version %q", version)
+	}
+	// Version 00 has exactly four fields, later versions may append more
+	if version == "00" && len(parts) != 4 {
+		return sc, errors.New("traceparent: unexpected fields for version 00")
+	}
+	if err := decodeHex(sc.TraceID[:], traceID); err != nil || !sc.TraceID.IsValid() {
+		return sc, fmt.Errorf("traceparent: invalid trace id %q", traceID)
+	}
+	if err := decodeHex(sc.SpanID[:], spanID); err != nil || !sc.SpanID.IsValid() {
+		return sc, fmt.Errorf("traceparent: invalid parent id %q", spanID)
+	}
+	var f [1]byte
+	if err := decodeHex(f[:], flags); err != nil {
+		return sc, fmt.Errorf("traceparent: invalid flags %q", flags)
+	}
+	sc.Sampled = f[0]&0x01 == 0x01
+	return sc, nil
+}
+
+// Traceparent encodes the span context as a version 00 traceparent value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Extract reads the incoming span context from request headers. The second
+// return value is false when the request carries no valid traceparent.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.TraceState = h.Get(TracestateHeader)
+	return sc, true
+}
+
+// Inject writes the span context into outgoing request headers
+func Inject(sc SpanContext, h http.Header) {
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
+
+// decodeHex decodes a lowercase hex string of exactly len(dst) bytes
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return errors.New("bad length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func newTraceID() (t TraceID) {
+	rand.Read(t[:])
+	return
+}
+
+func newSpanID() (s SpanID) {
+	rand.Read(s[:])
+	return
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"Sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"Not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"Other flags keep the sampled bit", "00-" + traceID + "-" + spanID + "-03", true, true},
+		{"Surrounding spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"Future version with more fields", "01-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"Empty", "", false, false},
+		{"Too few fields", "00-" + traceID + "-" + spanID, false, false},
+		{"Extra field in version 00", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"Invalid version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"Version not hex", "zz-" + traceID + "-" + spanID + "-01", false, false},
+		{"Version too long", "000-" + traceID + "-" + spanID + "-01", false, false},
+		{"All-zero trace id", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false, false},
+		{"All-zero span id", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"Short trace id", "00-" + traceID[:30] + "-" + spanID + "-01", false, false},
+		{"Long span id", "00-" + traceID + "-" + spanID + "ab-01", false, false},
+		{"Uppercase trace id", "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", false, false},
+		{"Trace id not hex", "00-" + strings.Repeat("g", 32) + "-" + spanID + "-01", false, false},
+		{"Bad flags", "00-" + traceID + "-" + spanID + "-1", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err == nil) != tt.valid {
+				t.Fatalf("ParseTraceparent(%q) error = %v, want valid %v", tt.value, err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tt.sampled {
+				t.Errorf("got %s %s sampled %v", sc.TraceID, sc.SpanID, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestInjectExtractRoundTrip(t *testing.T) {
+	for _, sc := range []SpanContext{
+		{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true, TraceState: "vendor=value"},
+		{TraceID: newTraceID(), SpanID: newSpanID()},
+	} {
+		h := http.Header{}
+		// A stale tracestate must not leak into the outgoing request
+		h.Set(TracestateHeader, "stale=1")
+		Inject(sc, h)
+
+		got, ok := Extract(h)
+		if !ok {
+			t.Fatalf("Extract failed on %q", h.Get(TraceparentHeader))
+		}
+		if got != sc {
+			t.Errorf("round trip changed %+v to %+v", sc, got)
+		}
+	}
+
+	if _, ok := Extract(http.Header{}); ok {
+		t.Error("Extract succeeded without a traceparent")
+	}
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans to a tracing backend
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	queueSize     = 2048
+	batchSize     = 128
+	flushInterval = 2 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// Tracer creates spans and exports them in batches from a background
+// goroutine so request handling never waits on the exporter
+type Tracer struct {
+	Service string
+
+	exporter Exporter
+	queue    chan *Span
+	done     chan struct{}
+
+	// mux guards closed, so no span is sent on the queue after Shutdown
+	// closed it
+	mux    sync.Mutex
+	closed bool
+}
+
+// NewTracer creates a tracer for the named service and starts its export loop
+func NewTracer(service string, exporter Exporter) *Tracer {
+	t := &Tracer{
+		Service:  service,
+		exporter: exporter,
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// StartSpan starts a span. When parent is valid the span joins its trace,
+// otherwise a new sampled trace is started.
+func (t *Tracer) StartSpan(name string, parent SpanContext) *Span {
+	s := &Span{
+		Name:       name,
+		SpanID:     newSpanID(),
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+	if parent.TraceID.IsValid() {
+		s.TraceID = parent.TraceID
+		s.ParentSpanID = parent.SpanID
+		s.Sampled = parent.Sampled
+		s.TraceState = parent.TraceState
+	} else {
+		s.TraceID = newTraceID()
+		s.Sampled = true
+	}
+	return s
+}
+
+// StartServerSpan continues the trace carried by the request headers, or
+// starts a new one, and records the standard HTTP request attributes
+func (t *Tracer) StartServerSpan(r *http.Request) *Span {
+	parent, _ := Extract(r.Header)
+	s := t.StartSpan(r.Method+" "+r.URL.Path, parent)
+	s.SetAttribute("http.request.method", r.Method)
+	s.SetAttribute("url.path", r.URL.Path)
+	s.SetAttribute("server.address", r.Host)
+	s.SetAttribute("client.address", r.RemoteAddr)
+	if ua := r.UserAgent(); ua != "" {
+		s.SetAttribute("user_agent.original", ua)
+	}
+	return s
+}
+
+// Shutdown flushes queued spans and shuts the exporter down
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mux.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mux.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// enqueue hands a finished span to the export loop, dropping it when the
+// queue is full rather than blocking the request, or after Shutdown
+func (t *Tracer) enqueue(s *Span) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		log.Printf("Tracing queue full, dropping span %s", s.SpanID)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(batch), err)
+		}
+		cancel()
+		batch = make([]*Span, 0, batchSize)
+	}
+
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
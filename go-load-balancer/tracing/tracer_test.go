@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryExporter keeps exported spans
+type memoryExporter struct {
+	mux   sync.Mutex
+	spans []*Span
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []*Span) error {
+	e.mux.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mux.Unlock()
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+func TestTracerExportsOnShutdown(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("test", exporter)
+
+	root := tracer.StartSpan("root", SpanContext{})
+	child := tracer.StartSpan("child", root.Context())
+	child.Finish()
+	root.Finish()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exporter.spans))
+	}
+	if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID {
+		t.Error("child span did not join the trace of its parent")
+	}
+}
+
+func TestTracerAfterShutdown(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("test", exporter)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// Spans finished late are dropped, concurrently with a second Shutdown
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracer.StartSpan("late", SpanContext{}).Finish()
+		}()
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown: %v", err)
+	}
+	wg.Wait()
+
+	if len(exporter.spans) != 0 {
+		t.Errorf("exported %d spans after shutdown", len(exporter.spans))
+	}
+}
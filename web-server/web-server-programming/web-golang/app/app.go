@@ -0,0 +1,255 @@
+// Package app wires the storage, token service and routes together into the
+// server, so main and tests set it up the same way.
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/conglt10/web-golang/audit"
+	jwt "github.com/conglt10/web-golang/auth"
+	db "github.com/conglt10/web-golang/database"
+	"github.com/conglt10/web-golang/database/sqldb"
+	"github.com/conglt10/web-golang/mail"
+	"github.com/conglt10/web-golang/middlewares"
+	"github.com/conglt10/web-golang/routes"
+)
+
+// App is the configured server. It owns the database connection and the log
+// files, Close releases them.
+type App struct {
+	Config  Config
+	Handler *routes.Handler
+
+	router  http.Handler
+	closers []func() error
+}
+
+// New connects to the database and sets up the handlers and routes
+func New(cfg Config) (*App, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &App{Config: cfg}
+	if err := a.setup(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *App) setup() error {
+	cfg := a.Config
+
+	h, err := a.openStorage()
+	if err != nil {
+		return fmt.Errorf("initialize database: %v", err)
+	}
+	a.Handler = h
+
+	signingKeyID, keys, err := jwt.LoadKeys(cfg.JWTKeysFile, cfg.JWTSecret)
+	if err != nil {
+		return fmt.Errorf("load token keys: %v", err)
+	}
+	h.Tokens, err = jwt.NewManager(jwt.Config{
+		Issuer:       cfg.JWTIssuer,
+		Audience:     cfg.JWTAudience,
+		SigningKeyID: signingKeyID,
+		Keys:         keys,
+	})
+	if err != nil {
+		return fmt.Errorf("load token keys: %v", err)
+	}
+
+	users := jwt.DefaultUserThrottle
+	users.LockoutAfter = cfg.LoginMaxFailures
+	users.LockoutFor = cfg.LoginLockout
+	h.Logins = jwt.NewLoginThrottle(users, jwt.DefaultIPThrottle)
+	h.PasswordCost = cfg.BcryptCost
+
+	auditLog, err := a.openLog(cfg.AuditLog)
+	if err != nil {
+		return fmt.Errorf("open audit log: %v", err)
+	}
+	h.Audit = audit.NewJSONLogger(auditLog)
+
+	if cfg.SMTPHost != "" {
+		h.Mailer = mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	} else {
+		mailLog, err := a.openLog(cfg.MailLog)
+		if err != nil {
+			return fmt.Errorf("open mail log: %v", err)
+		}
+		h.Mailer = mail.NewLogMailer(mailLog)
+	}
+	h.AppURL = cfg.AppURL
+
+	h.OIDC = make(map[string]*jwt.OIDCProvider, len(cfg.OIDC))
+	for _, p := range cfg.OIDC {
+		h.OIDC[p.Name] = jwt.NewOIDCProvider(p, &http.Client{Timeout: 10 * time.Second})
+	}
+
+	accessLog, err := a.openLog(cfg.AccessLog)
+	if err != nil {
+		return fmt.Errorf("open access log: %v", err)
+	}
+
+	grantAdmins(h.Users, cfg.AdminUsernames)
+
+	// Every request gets an id first, so the logs and errors below carry it
+	a.router = middlewares.Chain(
+		middlewares.RequestID,
+		middlewares.AccessLog(slog.New(slog.NewJSONHandler(accessLog, nil))),
+		middlewares.Recover,
+		middlewares.SecurityHeaders,
+		middlewares.CORS(middlewares.CORSConfig{
+			AllowedOrigins: cfg.CORSOrigins,
+			MaxAge:         10 * time.Minute,
+		}),
+		middlewares.MaxBodySize(cfg.MaxBodyBytes),
+	)(newRouter(h))
+	return nil
+}
+
+// openStorage builds the handlers on top of the storage selected by DBDriver
+func (a *App) openStorage() (*routes.Handler, error) {
+	cfg := a.Config
+	switch cfg.DBDriver {
+	case "mongo":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		client, err := db.Connect(ctx, cfg.MongoURI)
+		if err != nil {
+			return nil, err
+		}
+		a.closers = append(a.closers, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return client.Disconnect(ctx)
+		})
+
+		database := client.Database(cfg.DBName)
+		db.InitDatabase(database)
+		return &routes.Handler{
+			Users:         db.NewUserRepository(database.Collection(db.UsersCollection)),
+			Posts:         db.NewPostRepository(database.Collection(db.PostsCollection)),
+			Comments:      db.NewCommentRepository(database.Collection(db.CommentsCollection)),
+			Likes:         db.NewLikeRepository(database.Collection(db.LikesCollection)),
+			RefreshTokens: db.NewRefreshTokenRepository(database.Collection(db.RefreshTokensCollection)),
+			UserTokens:    db.NewUserTokenRepository(database.Collection(db.UserTokensCollection)),
+			Identities:    db.NewIdentityRepository(database.Collection(db.IdentitiesCollection)),
+			APIKeys:       db.NewAPIKeyRepository(database.Collection(db.APIKeysCollection)),
+		}, nil
+	case "sqlite", "postgres":
+		driver, dsn := sqldb.DriverPostgres, cfg.DatabaseURL
+		if cfg.DBDriver == "sqlite" {
+			driver = sqldb.DriverSQLite
+			if dsn == "" {
+				dsn = "web-golang.db"
+			}
+		}
+		conn, err := sqldb.Open(driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		a.closers = append(a.closers, conn.Close)
+
+		return &routes.Handler{
+			Users:         sqldb.NewUserRepository(conn),
+			Posts:         sqldb.NewPostRepository(conn),
+			Comments:      sqldb.NewCommentRepository(conn),
+			Likes:         sqldb.NewLikeRepository(conn),
+			RefreshTokens: sqldb.NewRefreshTokenRepository(conn),
+			UserTokens:    sqldb.NewUserTokenRepository(conn),
+			Identities:    sqldb.NewIdentityRepository(conn),
+			APIKeys:       sqldb.NewAPIKeyRepository(conn),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q, expected mongo, sqlite or postgres", cfg.DBDriver)
+	}
+}
+
+// openLog opens the file at path for appending, stdout when path is empty
+func (a *App) openLog(path string) (io.Writer, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.closers = append(a.closers, f.Close)
+	return f, nil
+}
+
+// ServeHTTP serves the API, so the App can be passed to httptest.NewServer
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
+}
+
+// Run listens on the configured address and serves until ctx is done
+func (a *App) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", a.Config.Addr)
+	if err != nil {
+		return err
+	}
+	return a.Serve(ctx, listener)
+}
+
+// Serve serves requests from listener until ctx is done. Requests in flight
+// then get ShutdownTimeout to finish.
+func (a *App) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           a,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", listener.Addr())
+		errc <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %v", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close disconnects from the database and closes the log files
+func (a *App) Close() error {
+	// Mail still being sent needs the repositories
+	if a.Handler != nil {
+		a.Handler.Wait()
+	}
+	var errs []error
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		if err := a.closers[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	a.closers = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,321 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository/memory"
+	"github.com/conglt10/web-golang/routes"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testConfig returns a config for an app on a fresh in-memory SQLite
+// database, logging to files in a temporary directory
+func testConfig(t *testing.T) Config {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.DBDriver = "sqlite"
+	cfg.DatabaseURL = ":memory:"
+	cfg.JWTSecret = "test-secret"
+	cfg.BcryptCost = bcrypt.MinCost
+	cfg.AuditLog = filepath.Join(dir, "audit.log")
+	cfg.MailLog = filepath.Join(dir, "mail.log")
+	cfg.AccessLog = filepath.Join(dir, "access.log")
+	return cfg
+}
+
+func newTestApp(t *testing.T, cfg Config) *App {
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func TestLoadConfig(t *testing.T) {
+	env := map[string]string{
+		"ADDR":                     ":9000",
+		"DB_DRIVER":                "sqlite",
+		"SECRET_JWT":               "secret",
+		"ADMIN_USERNAMES":          "alice, bob,",
+		"LOGIN_LOCKOUT":            "5m",
+		"BCRYPT_COST":              "10",
+		"OIDC_PROVIDERS":           "Google",
+		"OIDC_GOOGLE_ISSUER":       "https://accounts.google.com",
+		"OIDC_GOOGLE_CLIENT_ID":    "client",
+		"OIDC_GOOGLE_REDIRECT_URL": "http://localhost:8000/auth/oidc/google/callback",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	cfg, err := LoadConfig([]string{"-addr", "127.0.0.1:9001", "-database-url", "test.db"}, getenv)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Addr != "127.0.0.1:9001" || cfg.DatabaseURL != "test.db" {
+		t.Errorf("flags not applied: %+v", cfg)
+	}
+	if cfg.DBDriver != "sqlite" || cfg.JWTSecret != "secret" || cfg.LoginLockout != 5*time.Minute || cfg.BcryptCost != 10 {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+	if len(cfg.AdminUsernames) != 2 || cfg.AdminUsernames[1] != "bob" {
+		t.Errorf("admins = %q", cfg.AdminUsernames)
+	}
+	if len(cfg.OIDC) != 1 || cfg.OIDC[0].Name != "google" || cfg.OIDC[0].ClientID != "client" {
+		t.Errorf("OIDC = %+v", cfg.OIDC)
+	}
+	// Defaults are kept
+	if cfg.JWTIssuer != "web-golang" || cfg.ShutdownTimeout != 15*time.Second {
+		t.Errorf("defaults lost: %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected []string
+	}{
+		{"Missing secret and Mongo URI", nil, nil,
+			[]string{"MONGODB_URI", "SECRET_JWT"}},
+		{"Unknown driver", nil, map[string]string{"DB_DRIVER": "mysql", "SECRET_JWT": "s"},
+			[]string{"mysql"}},
+		{"Bad number", nil, map[string]string{"BCRYPT_COST": "high"},
+			[]string{"BCRYPT_COST must be a number"}},
+		{"Bad duration", []string{"-shutdown-timeout", "soon"}, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s"},
+			[]string{"shutdown-timeout"}},
+		{"Unexpected argument", []string{"serve"}, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s"},
+			[]string{"unexpected arguments"}},
+		{"SMTP without sender", nil, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s", "SMTP_HOST": "smtp"},
+			[]string{"MAIL_FROM"}},
+		{"Incomplete OIDC provider", nil, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s", "OIDC_PROVIDERS": "google"},
+			[]string{"OIDC_GOOGLE_ISSUER"}},
+		{"Bad CORS origin", nil, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s", "CORS_ALLOWED_ORIGINS": "https://app.example.com/login"},
+			[]string{"app.example.com/login"}},
+		{"Bad body limit", nil, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s", "MAX_BODY_BYTES": "0"},
+			[]string{"MAX_BODY_BYTES"}},
+		{"Bad OIDC provider name", nil, map[string]string{"DB_DRIVER": "sqlite", "SECRET_JWT": "s", "OIDC_PROVIDERS": "my-idp"},
+			[]string{"my-idp"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(tt.args, func(name string) string { return tt.env[name] })
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("error %q does not mention %s", err, s)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigHelp(t *testing.T) {
+	_, err := LoadConfig([]string{"-h"}, func(string) string { return "" })
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("got %v want %v", err, flag.ErrHelp)
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.JWTSecret = ""
+	if _, err := New(cfg); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestGrantAdminsKeepsRoles(t *testing.T) {
+	users := memory.NewUserRepository()
+	ctx := context.Background()
+	for _, user := range []*models.User{
+		{Username: "alice", Email: "alice@example.com", Roles: []string{models.RoleUser, models.RoleModerator}},
+		{Username: "bob", Email: "bob@example.com"},
+		{Username: "carol", Email: "carol@example.com", Roles: []string{models.RoleAdmin}},
+	} {
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	grantAdmins(users, []string{"alice", "bob", "carol", "ghost"})
+
+	tests := []struct {
+		username string
+		want     []string
+	}{
+		{"alice", []string{models.RoleUser, models.RoleModerator, models.RoleAdmin}},
+		{"bob", []string{models.RoleUser, models.RoleAdmin}},
+		{"carol", []string{models.RoleAdmin}},
+	}
+	for _, tt := range tests {
+		user, err := users.FindByUsername(ctx, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(user.GetRoles(), ","); got != strings.Join(tt.want, ",") {
+			t.Errorf("roles of %s = %s, want %s", tt.username, got, strings.Join(tt.want, ","))
+		}
+	}
+	if _, err := users.FindByUsername(ctx, "ghost"); err == nil {
+		t.Error("granting admin created a user")
+	}
+}
+
+func TestAppServesAPI(t *testing.T) {
+	cfg := testConfig(t)
+	a := newTestApp(t, cfg)
+	server := httptest.NewServer(a)
+	defer server.Close()
+
+	post := func(path string, body interface{}) *http.Response {
+		b, _ := json.Marshal(body)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post("/auth/register", routes.RegisterRequest{Username: "alice", Password: "password123", Email: "alice@example.com"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register returned %v", resp.StatusCode)
+	}
+	user, err := a.Handler.Users.FindByUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(user.Password)); cost != cfg.BcryptCost {
+		t.Errorf("password hashed with cost %d, want %d", cost, cfg.BcryptCost)
+	}
+
+	resp = post("/auth/login", routes.LoginRequest{Username: "alice", Password: "password123"})
+	var tokens routes.TokenResponse
+	json.NewDecoder(resp.Body).Decode(&tokens)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || tokens.Token == "" {
+		t.Fatalf("login returned %v", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/posts", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /posts returned %v", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/nowhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown route returned %v", resp.StatusCode)
+	}
+}
+
+func TestAppMiddlewares(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CORSOrigins = []string{"https://app.example.com"}
+	server := httptest.NewServer(newTestApp(t, cfg))
+	defer server.Close()
+
+	// Preflight requests are answered before routing
+	req, _ := http.NewRequest("OPTIONS", server.URL+"/posts", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("preflight returned %v with headers %v", resp.StatusCode, resp.Header)
+	}
+
+	// Login bodies have the small limit of their route
+	body := `{"username": "alice", "password": "` + strings.Repeat("a", smallBodyBytes) + `"}`
+	resp, err = http.Post(server.URL+"/auth/login", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problem struct {
+		Code      string `json:"code"`
+		RequestID string `json:"request_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&problem)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || problem.Code != "payload_too_large" {
+		t.Errorf("large login returned %v %q", resp.StatusCode, problem.Code)
+	}
+	if id := resp.Header.Get("X-Request-ID"); id == "" || id != problem.RequestID {
+		t.Errorf("request id header %q, in body %q", id, problem.RequestID)
+	}
+	if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("security headers missing")
+	}
+
+	// So do the routes that expect no body at all
+	resp, err = http.Post(server.URL+"/auth/verify-email/resend", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("large resend returned %v", resp.StatusCode)
+	}
+}
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	a := newTestApp(t, testConfig(t))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Serve(ctx, listener) }()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/.well-known/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("JWKS returned %v", resp.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the context was canceled")
+	}
+	if _, err := http.Get("http://" + listener.Addr().String()); err == nil {
+		t.Error("server still accepts connections")
+	}
+}
@@ -0,0 +1,284 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	"github.com/conglt10/web-golang/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is everything the application is set up from. LoadConfig reads it
+// from the environment and flags, tests fill it in directly.
+type Config struct {
+	// Addr is where the server listens, ":8000" by default
+	Addr string
+	// ShutdownTimeout is how long requests in flight get to finish when the
+	// server stops
+	ShutdownTimeout time.Duration
+	// AccessLog is the file requests are logged to, stdout when empty
+	AccessLog string
+	// CORSOrigins are the origins browsers may call the API from, such as
+	// the front-end. "*" allows any.
+	CORSOrigins []string
+	// MaxBodyBytes limits request bodies
+	MaxBodyBytes int64
+
+	// DBDriver is mongo, sqlite or postgres
+	DBDriver string
+	// DatabaseURL is the SQLite file or Postgres connection string
+	DatabaseURL string
+	MongoURI    string
+	// DBName is the MongoDB database
+	DBName string
+
+	JWTIssuer   string
+	JWTAudience string
+	// JWTKeysFile names a file of signing keys, see auth/keys.go. Without
+	// it JWTSecret is used as a single HS256 key.
+	JWTKeysFile string
+	JWTSecret   string
+
+	// AdminUsernames are granted the admin role on startup
+	AdminUsernames []string
+	// AuditLog is the file audit events are appended to, stdout when empty
+	AuditLog string
+
+	BcryptCost int
+	// LoginMaxFailures is how many failed logins lock an account for
+	// LoginLockout, 0 turns the lockout off
+	LoginMaxFailures int
+	LoginLockout     time.Duration
+
+	// AppURL is where the links in emails point to
+	AppURL string
+	// Emails go through SMTPHost when it is set. Otherwise they are appended
+	// to MailLog, or printed to stdout.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailLog      string
+
+	// OIDC are the login providers
+	OIDC []jwt.OIDCConfig
+}
+
+// DefaultConfig returns the settings used for what is not configured
+func DefaultConfig() Config {
+	return Config{
+		Addr:             ":8000",
+		ShutdownTimeout:  15 * time.Second,
+		MaxBodyBytes:     1 << 20,
+		DBDriver:         "mongo",
+		DBName:           "demo-web-server-2",
+		JWTIssuer:        "web-golang",
+		JWTAudience:      "web-golang",
+		BcryptCost:       models.DefaultPasswordCost,
+		LoginMaxFailures: jwt.DefaultUserThrottle.LockoutAfter,
+		LoginLockout:     jwt.DefaultUserThrottle.LockoutFor,
+		SMTPPort:         587,
+	}
+}
+
+// LoadConfig reads the configuration from the environment, see .env.example.
+// Flags in args override the environment. The result is validated.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+	env := envReader{getenv: getenv}
+
+	env.str("ADDR", &cfg.Addr)
+	env.duration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	env.str("ACCESS_LOG", &cfg.AccessLog)
+	cfg.CORSOrigins = splitList(getenv("CORS_ALLOWED_ORIGINS"))
+	env.int64("MAX_BODY_BYTES", &cfg.MaxBodyBytes)
+	env.str("DB_DRIVER", &cfg.DBDriver)
+	env.str("DATABASE_URL", &cfg.DatabaseURL)
+	env.str("MONGODB_URI", &cfg.MongoURI)
+	env.str("DB_NAME", &cfg.DBName)
+	env.str("JWT_ISSUER", &cfg.JWTIssuer)
+	env.str("JWT_AUDIENCE", &cfg.JWTAudience)
+	env.str("JWT_KEYS_FILE", &cfg.JWTKeysFile)
+	env.str("SECRET_JWT", &cfg.JWTSecret)
+	cfg.AdminUsernames = splitList(getenv("ADMIN_USERNAMES"))
+	env.str("AUDIT_LOG", &cfg.AuditLog)
+	env.int("BCRYPT_COST", &cfg.BcryptCost)
+	env.int("LOGIN_MAX_FAILURES", &cfg.LoginMaxFailures)
+	env.duration("LOGIN_LOCKOUT", &cfg.LoginLockout)
+	env.str("APP_URL", &cfg.AppURL)
+	env.str("SMTP_HOST", &cfg.SMTPHost)
+	env.int("SMTP_PORT", &cfg.SMTPPort)
+	env.str("SMTP_USERNAME", &cfg.SMTPUsername)
+	env.str("SMTP_PASSWORD", &cfg.SMTPPassword)
+	env.str("MAIL_FROM", &cfg.MailFrom)
+	env.str("MAIL_LOG", &cfg.MailLog)
+
+	for _, name := range splitList(getenv("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg.OIDC = append(cfg.OIDC, jwt.OIDCConfig{
+			Name:         name,
+			Issuer:       getenv(prefix + "ISSUER"),
+			ClientID:     getenv(prefix + "CLIENT_ID"),
+			ClientSecret: getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  getenv(prefix + "REDIRECT_URL"),
+		})
+	}
+	if err := errors.Join(env.errs...); err != nil {
+		return cfg, err
+	}
+
+	flags := flag.NewFlagSet("web-golang", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	flags.StringVar(&cfg.DBDriver, "db-driver", cfg.DBDriver, "mongo, sqlite or postgres")
+	flags.StringVar(&cfg.DatabaseURL, "database-url", cfg.DatabaseURL, "SQLite file or Postgres connection string")
+	flags.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long requests get to finish on shutdown")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+		}
+		return cfg, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments %q", flags.Args())
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate reports every setting that is missing or out of range
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Addr == "" {
+		fail("ADDR must not be empty")
+	}
+	if c.ShutdownTimeout < 0 {
+		fail("SHUTDOWN_TIMEOUT must not be negative")
+	}
+	if c.MaxBodyBytes <= 0 {
+		fail("MAX_BODY_BYTES must be positive")
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin != "*" && !validOrigin(origin) {
+			fail("CORS origin %q must be a scheme and host such as https://app.example.com, or *", origin)
+		}
+	}
+	switch c.DBDriver {
+	case "mongo":
+		if c.MongoURI == "" {
+			fail("MONGODB_URI must be set for the mongo driver")
+		}
+	case "sqlite":
+	case "postgres":
+		if c.DatabaseURL == "" {
+			fail("DATABASE_URL must be set for the postgres driver")
+		}
+	default:
+		fail("unknown DB_DRIVER %q, expected mongo, sqlite or postgres", c.DBDriver)
+	}
+	if c.JWTKeysFile == "" && c.JWTSecret == "" {
+		fail("SECRET_JWT or JWT_KEYS_FILE must be set")
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		fail("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if c.LoginMaxFailures < 0 {
+		fail("LOGIN_MAX_FAILURES must not be negative, 0 disables the lockout")
+	}
+	if c.SMTPHost != "" && c.MailFrom == "" {
+		fail("MAIL_FROM must be set to send through SMTP")
+	}
+
+	for _, p := range c.OIDC {
+		// The name ends up in URLs and cookie paths
+		if p.Name == "" || strings.IndexFunc(p.Name, func(r rune) bool { return (r < 'a' || r > 'z') && (r < '0' || r > '9') }) >= 0 {
+			fail("OIDC provider name %q may only contain letters and digits", p.Name)
+			continue
+		}
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+			fail("%sISSUER, %sCLIENT_ID and %sREDIRECT_URL must be set", prefix, prefix, prefix)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// envReader parses environment variables into config fields. Unset
+// variables leave the field alone, parse errors are collected.
+type envReader struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (e *envReader) str(name string, dst *string) {
+	if v := e.getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+func (e *envReader) int(name string, dst *int) {
+	if v := e.getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s must be a number", name))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) int64(name string, dst *int64) {
+	if v := e.getenv(name); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s must be a number", name))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) duration(name string, dst *time.Duration) {
+	if v := e.getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %v", name, err))
+			return
+		}
+		*dst = d
+	}
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validOrigin reports whether origin is what browsers send in the Origin
+// header: a scheme, a host and maybe a port, nothing more
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/middlewares"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	"github.com/conglt10/web-golang/routes"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// smallBodyBytes limits the bodies of login and account routes
+const smallBodyBytes = 16 << 10
+
+// newRouter registers the routes of the API
+func newRouter(h *routes.Handler) *httprouter.Router {
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res.Error(w, r, apierr.NotFound("Route not found"))
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res.Error(w, r, apierr.New(apierr.CodeMethodNotAllowed, "Method not allowed"))
+	})
+
+	// Routes with scopes also take API keys, the others need a login
+	authn := &jwt.Authenticator{Tokens: h.Tokens, APIKeys: h.APIKeys}
+	// Credentials and account settings are small, posts and comments get
+	// the global body limit
+	small := func(next httprouter.Handle) httprouter.Handle {
+		return middlewares.Route(next, middlewares.MaxBodySize(smallBodyBytes))
+	}
+
+	router.POST("/auth/login", small(h.Login))
+	router.POST("/auth/register", small(h.Register))
+	router.POST("/auth/refresh", small(h.Refresh))
+	router.POST("/auth/logout", small(h.Logout))
+	router.POST("/auth/verify-email", small(h.VerifyEmail))
+	router.POST("/auth/verify-email/resend", small(middlewares.CheckJwt(authn, h.ResendVerification)))
+	router.POST("/auth/forgot-password", small(h.ForgotPassword))
+	router.POST("/auth/reset-password", small(h.ResetPassword))
+	router.GET("/auth/oidc/:provider/login", h.OIDCLogin)
+	router.GET("/auth/oidc/:provider/callback", h.OIDCCallback)
+	router.GET("/.well-known/jwks.json", h.JWKS)
+
+	router.GET("/posts", middlewares.CheckJwt(authn, h.GetAllPosts, jwt.ScopePostsRead))
+	router.GET("/posts/:id", middlewares.CheckJwt(authn, h.GetPost, jwt.ScopePostsRead))
+	router.GET("/me/posts", middlewares.CheckJwt(authn, h.GetMyPosts, jwt.ScopePostsRead))
+	router.GET("/me", middlewares.CheckJwt(authn, h.GetMe))
+	router.PATCH("/me", small(middlewares.CheckJwt(authn, h.UpdateMe)))
+	router.DELETE("/me", small(middlewares.CheckJwt(authn, h.DeleteMe)))
+	router.PUT("/me/password", small(middlewares.CheckJwt(authn, h.ChangePassword)))
+	router.GET("/me/api-keys", middlewares.CheckJwt(authn, h.ListAPIKeys))
+	router.POST("/me/api-keys", small(middlewares.CheckJwt(authn, h.CreateAPIKey)))
+	router.DELETE("/me/api-keys/:id", middlewares.CheckJwt(authn, h.RevokeAPIKey))
+	router.GET("/users/:username", middlewares.CheckJwt(authn, h.GetUser, jwt.ScopePostsRead))
+	router.POST("/posts", middlewares.CheckJwt(authn, h.CreatePost, jwt.ScopePostsWrite))
+	router.PUT("/posts/:id", middlewares.CheckJwt(authn, h.EditPost, jwt.ScopePostsWrite))
+	router.DELETE("/posts/:id", middlewares.CheckJwt(authn, h.DeletePost, jwt.ScopePostsWrite))
+
+	router.GET("/posts/:id/comments", middlewares.CheckJwt(authn, h.ListComments, jwt.ScopePostsRead))
+	router.POST("/posts/:id/comments", middlewares.CheckJwt(authn, h.CreateComment, jwt.ScopeCommentsWrite))
+	router.PUT("/posts/:id/comments/:comment_id", middlewares.CheckJwt(authn, h.EditComment, jwt.ScopeCommentsWrite))
+	router.DELETE("/posts/:id/comments/:comment_id", middlewares.CheckJwt(authn, h.DeleteComment, jwt.ScopeCommentsWrite))
+	router.PUT("/posts/:id/like", middlewares.CheckJwt(authn, h.LikePost, jwt.ScopePostsWrite))
+	router.DELETE("/posts/:id/like", middlewares.CheckJwt(authn, h.UnlikePost, jwt.ScopePostsWrite))
+
+	router.GET("/admin/users", middlewares.CheckJwt(authn,
+		middlewares.RequirePermission(jwt.PermManageUsers, h.ListUsers)))
+	router.PUT("/admin/users/:username/roles", small(middlewares.CheckJwt(authn,
+		middlewares.RequirePermission(jwt.PermManageUsers, h.SetUserRoles))))
+
+	return router
+}
+
+// grantAdmins adds the admin role to the users, so the first admin can be
+// created without editing the database by hand. Their other roles are kept.
+func grantAdmins(users repository.UserRepository, usernames []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, username := range usernames {
+		user, err := users.FindByUsername(ctx, username)
+		if err != nil {
+			log.Printf("Warning: Failed to grant admin to %s: %v", username, err)
+			continue
+		}
+		roles := user.GetRoles()
+		if hasRole(roles, models.RoleAdmin) {
+			continue
+		}
+		err = users.SetRoles(ctx, username, append(roles, models.RoleAdmin))
+		if err != nil {
+			log.Printf("Warning: Failed to grant admin to %s: %v", username, err)
+			continue
+		}
+		log.Printf("Granted admin to %s", username)
+	}
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
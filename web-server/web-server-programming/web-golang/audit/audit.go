@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event records a privileged action
+type Event struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	// Details holds action specific values such as the roles granted
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Logger records audit events. Implementations must be safe for concurrent
+// use.
+type Logger interface {
+	Record(ctx context.Context, e Event)
+}
+
+// JSONLogger writes one JSON object per event
+type JSONLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+func (l *JSONLogger) Record(_ context.Context, e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := json.NewEncoder(l.w).Encode(e); err != nil {
+		log.Printf("Failed to write audit event %s: %v", e.Action, err)
+	}
+}
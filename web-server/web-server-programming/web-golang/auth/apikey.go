@@ -0,0 +1,120 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+)
+
+// APIKeyHeader carries an API key instead of an access token
+const APIKeyHeader = "X-API-Key"
+
+// Scopes limit what an API key can do. Access tokens have every scope.
+const (
+	// ScopePostsRead reads posts, comments and profiles
+	ScopePostsRead = "posts:read"
+	// ScopePostsWrite creates, edits, deletes and likes posts
+	ScopePostsWrite = "posts:write"
+	// ScopeCommentsWrite creates, edits and deletes comments
+	ScopeCommentsWrite = "comments:write"
+)
+
+// Scopes lists the scopes an API key can be given
+var Scopes = []string{ScopePostsRead, ScopePostsWrite, ScopeCommentsWrite}
+
+// ErrUnauthenticated is returned for a request without valid credentials
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+const (
+	// apiKeyPrefix starts every key, so leaked keys are easy to scan for
+	apiKeyPrefix = "wgk_"
+	// apiKeyTouchInterval limits how often the last use of a key is stored
+	apiKeyTouchInterval = time.Minute
+)
+
+// ValidScope reports whether an API key can be given the scope
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKey returns a random API key, the hash that should be stored for it
+// and the prefix it is shown by. Keys are hashed like refresh tokens.
+func NewAPIKey() (key, hash, prefix string, err error) {
+	token, _, err := NewRefreshToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + token
+	return key, HashAPIKey(key), key[:len(apiKeyPrefix)+6], nil
+}
+
+// HashAPIKey returns the stored form of an API key
+func HashAPIKey(key string) string {
+	return HashRefreshToken(key)
+}
+
+// Authenticate returns the caller of a request with an access token
+func (m *Manager) Authenticate(r *http.Request) (*Principal, error) {
+	claims, err := m.Verify(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return claims.Principal(), nil
+}
+
+// Authenticator accepts an API key in the X-API-Key header, or else an
+// access token
+type Authenticator struct {
+	Tokens  *Manager
+	APIKeys repository.APIKeyRepository
+}
+
+// Authenticate returns the caller of a request. It returns an error wrapping
+// ErrUnauthenticated for missing or invalid credentials.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	raw := r.Header.Get(APIKeyHeader)
+	if raw == "" {
+		return a.Tokens.Authenticate(r)
+	}
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return nil, fmt.Errorf("%w: malformed API key", ErrUnauthenticated)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	key, err := a.APIKeys.FindByHash(ctx, HashAPIKey(raw))
+	if err == repository.ErrNotFound {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Saves a write per request for busy keys
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := a.APIKeys.Touch(ctx, key.ID, now); err != nil {
+			log.Printf("Warning: Failed to record the use of API key %s: %v", key.ID, err)
+		}
+	}
+
+	// Keys act as a plain user, moderating needs a login
+	return &Principal{
+		Username: key.Username,
+		Roles:    []string{models.RoleUser},
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}, nil
+}
@@ -1,69 +1,160 @@
 package jwt
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
 )
 
-func Create(username string) (string, error) {
-	claims := jwt.MapClaims{}
-	claims["authorized"] = true
-	claims["username"] = username
-	claims["exp"] = time.Now().Add(time.Hour * 12).Unix() //Token expires after 12 hour
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("SECRET_JWT")))
+var (
+	ErrMissingToken    = errors.New("missing bearer token")
+	ErrUnknownKey      = errors.New("token signed with an unknown key")
+	ErrInvalidIssuer   = errors.New("token has an invalid issuer")
+	ErrInvalidAudience = errors.New("token has an invalid audience")
+)
+
+// Claims are the claims carried by access tokens. The username is the
+// subject.
+type Claims struct {
+	Roles []string `json:"roles,omitempty"`
+	jwt.StandardClaims
 }
 
-func Verify(r *http.Request) error {
-	tokenString := Extract(r)
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("SECRET_JWT")), nil
-	})
+// Config configures a Manager
+type Config struct {
+	Issuer   string
+	Audience string
+	// AccessTTL defaults to AccessTokenTTL
+	AccessTTL time.Duration
+	// SigningKeyID selects the key new tokens are signed with. The other
+	// keys are only used to verify tokens issued before a rotation.
+	SigningKeyID string
+	Keys         []Key
+}
 
-	if err != nil {
-		return err
+// Manager issues and verifies access tokens
+type Manager struct {
+	issuer    string
+	audience  string
+	accessTTL time.Duration
+	signing   Key
+	keys      map[string]Key
+}
+
+func NewManager(cfg Config) (*Manager, error) {
+	m := &Manager{
+		issuer:    cfg.Issuer,
+		audience:  cfg.Audience,
+		accessTTL: cfg.AccessTTL,
+		keys:      make(map[string]Key, len(cfg.Keys)),
+	}
+	if m.accessTTL == 0 {
+		m.accessTTL = AccessTokenTTL
 	}
 
-	return nil
+	for _, k := range cfg.Keys {
+		if k.ID == "" {
+			return nil, errors.New("every key needs a kid")
+		}
+		if _, ok := m.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate kid %q", k.ID)
+		}
+		m.keys[k.ID] = k
+	}
+
+	signing, ok := m.keys[cfg.SigningKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not configured", cfg.SigningKeyID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", cfg.SigningKeyID)
+	}
+	m.signing = signing
+	return m, nil
 }
 
-func Extract(r *http.Request) string {
-	keys := r.URL.Query()
-	token := keys.Get("token")
+// AccessTTL is how long issued access tokens are valid
+func (m *Manager) AccessTTL() time.Duration {
+	return m.accessTTL
+}
 
-	if token != "" {
-		return token
+// Create issues an access token for the user
+func (m *Manager) Create(username string, roles []string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Roles: roles,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   username,
+			Issuer:    m.issuer,
+			Audience:  m.audience,
+			Id:        uuid.NewV4().String(),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(m.accessTTL).Unix(),
+		},
 	}
+	token := jwt.NewWithClaims(m.signing.Method, claims)
+	token.Header["kid"] = m.signing.ID
+	return token.SignedString(m.signing.signKey)
+}
 
-	bearerToken := r.Header.Get("Authorization")
-	return strings.Split(bearerToken, " ")[1]
+// Parse verifies the token signature, lifetime, issuer and audience
+func (m *Manager) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, m.keyFunc); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(m.issuer, true) {
+		return nil, ErrInvalidIssuer
+	}
+	if !claims.VerifyAudience(m.audience, true) {
+		return nil, ErrInvalidAudience
+	}
+	return claims, nil
 }
 
-func ExtractUsernameFromToken(r *http.Request) (string, error) {
-	var username string
-	tokenString := Extract(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("SECRET_JWT")), nil
-	})
+func (m *Manager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	// The key decides the algorithm, never the token
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
 
+// Verify parses the token sent with the request
+func (m *Manager) Verify(r *http.Request) (*Claims, error) {
+	token, err := Extract(r)
 	if err != nil {
-		return username, err
+		return nil, err
 	}
+	return m.Parse(token)
+}
+
+// Extract returns the token from the token query parameter or the
+// "Authorization: Bearer <token>" header. It returns ErrMissingToken when
+// neither is present or the header is malformed.
+func Extract(r *http.Request) (string, error) {
+	keys := r.URL.Query()
+	token := keys.Get("token")
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username = fmt.Sprintf("%v", claims["username"])
+	if token != "" {
+		return token, nil
 	}
 
-	return username, nil
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", ErrMissingToken
+	}
+	return token, nil
 }
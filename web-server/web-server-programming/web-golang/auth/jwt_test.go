@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func newTestManager(t *testing.T, signing string, keys ...Key) *Manager {
+	m, err := NewManager(Config{Issuer: "issuer", Audience: "audience", SigningKeyID: signing, Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCreateAndParse(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"HS256", NewHMACKey("hs", []byte("secret"))},
+		{"RS256", NewRSAKey("rs", rsaKey)},
+		{"EdDSA", NewEd25519Key("ed", edKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, tt.key.ID, tt.key)
+			token, err := m.Create("alice", []string{"admin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := m.Parse(token)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if claims.Subject != "alice" || len(claims.Roles) != 1 || claims.Roles[0] != "admin" || claims.Id == "" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := NewHMACKey("old", []byte("old-secret"))
+	newKey := NewHMACKey("new", []byte("new-secret"))
+
+	before := newTestManager(t, "old", oldKey)
+	issuedBefore, _ := before.Create("alice", nil)
+
+	// After rotating, the old key only verifies
+	after := newTestManager(t, "new", oldKey, newKey)
+	if _, err := after.Parse(issuedBefore); err != nil {
+		t.Errorf("token from the previous key was rejected: %v", err)
+	}
+	issuedAfter, _ := after.Create("alice", nil)
+
+	// Once the old key is dropped, its tokens stop working
+	retired := newTestManager(t, "new", newKey)
+	if _, err := retired.Parse(issuedBefore); err == nil {
+		t.Error("token from a retired key was accepted")
+	}
+	if _, err := retired.Parse(issuedAfter); err != nil {
+		t.Errorf("token from the current key was rejected: %v", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	key := NewHMACKey("hs", []byte("secret"))
+	m := newTestManager(t, "hs", key)
+
+	sign := func(claims Claims, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	valid := func() Claims {
+		return Claims{StandardClaims: jwt.StandardClaims{Subject: "alice", Issuer: "issuer", Audience: "audience"}}
+	}
+
+	wrongIssuer := valid()
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := valid()
+	wrongAudience.Audience = "other-service"
+	expired := valid()
+	expired.ExpiresAt = 1
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	withPublicKey := newTestManager(t, "hs", key, NewRSAPublicKey("rs", &rsaKey.PublicKey))
+
+	tests := []struct {
+		name  string
+		m     *Manager
+		token string
+	}{
+		{"Wrong issuer", m, sign(wrongIssuer, "hs")},
+		{"Wrong audience", m, sign(wrongAudience, "hs")},
+		{"Expired", m, sign(expired, "hs")},
+		{"Unknown kid", m, sign(valid(), "missing")},
+		{"Missing kid", m, sign(valid(), "")},
+		// An HS256 token must not be checked against an RSA key's kid
+		{"Algorithm mismatch", withPublicKey, sign(valid(), "rs")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.m.Parse(tt.token); err == nil {
+				t.Error("Parse accepted an invalid token")
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	m := newTestManager(t, "ed",
+		NewHMACKey("hs", []byte("secret")),
+		NewRSAPublicKey("rs", &rsaKey.PublicKey),
+		NewEd25519Key("ed", edKey),
+	)
+
+	set := m.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want the 2 public keys: %+v", len(set.Keys), set.Keys)
+	}
+	ed, rs := set.Keys[0], set.Keys[1]
+	if ed.Kid != "ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" {
+		t.Errorf("unexpected Ed25519 key %+v", ed)
+	}
+	if x, _ := jwt.DecodeSegment(ed.X); string(x) != string(edPublic) {
+		t.Error("Ed25519 key has the wrong public key")
+	}
+	if rs.Kid != "rs" || rs.Kty != "RSA" || rs.Alg != "RS256" || rs.E != "AQAB" {
+		t.Errorf("unexpected RSA key %+v", rs)
+	}
+}
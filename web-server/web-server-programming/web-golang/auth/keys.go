@@ -0,0 +1,196 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Key is a signing key identified by its kid. Keys without a private part
+// can only verify tokens.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+func NewRSAKey(id string, private *rsa.PrivateKey) Key {
+	return Key{ID: id, Method: jwt.SigningMethodRS256, signKey: private, verifyKey: &private.PublicKey}
+}
+
+func NewRSAPublicKey(id string, public *rsa.PublicKey) Key {
+	return Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: public}
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) Key {
+	return Key{ID: id, Method: SigningMethodEdDSA, signKey: private, verifyKey: private.Public()}
+}
+
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) Key {
+	return Key{ID: id, Method: SigningMethodEdDSA, verifyKey: public}
+}
+
+// keyFile is the format of the file named by JWT_KEYS_FILE. Key file paths
+// are relative to the directory of the keys file.
+//
+//	{
+//	  "signing_key": "2024-06",
+//	  "keys": [
+//	    {"kid": "2024-06", "alg": "EdDSA", "private_key_file": "ed25519.pem"},
+//	    {"kid": "2024-01", "alg": "RS256", "public_key_file": "rsa.pub.pem"},
+//	    {"kid": "legacy", "alg": "HS256", "secret": "..."}
+//	  ]
+//	}
+type keyFile struct {
+	SigningKey string `json:"signing_key"`
+	Keys       []struct {
+		ID             string `json:"kid"`
+		Alg            string `json:"alg"`
+		Secret         string `json:"secret"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+// LoadKeys returns the keys of the key file at path and the id of the one
+// to sign with. Without a file, secret is used as a single HS256 key.
+func LoadKeys(path, secret string) (signingKeyID string, keys []Key, err error) {
+	if path != "" {
+		id, keys, err := loadKeyFile(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("load %s: %v", path, err)
+		}
+		return id, keys, nil
+	}
+
+	if secret == "" {
+		return "", nil, errors.New("a JWT secret or key file must be set")
+	}
+	return "default", []Key{NewHMACKey("default", []byte(secret))}, nil
+}
+
+func loadKeyFile(path string) (string, []Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", nil, err
+	}
+
+	dir := filepath.Dir(path)
+	readPEM := func(name string) ([]byte, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return os.ReadFile(name)
+	}
+
+	var keys []Key
+	for _, k := range f.Keys {
+		key, err := parseKey(k.ID, k.Alg, k.Secret, k.PrivateKeyFile, k.PublicKeyFile, readPEM)
+		if err != nil {
+			return "", nil, fmt.Errorf("key %q: %v", k.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	return f.SigningKey, keys, nil
+}
+
+func parseKey(id, alg, secret, privateFile, publicFile string, readPEM func(string) ([]byte, error)) (Key, error) {
+	switch alg {
+	case "HS256":
+		if secret == "" {
+			return Key{}, errors.New("HS256 keys need a secret")
+		}
+		return NewHMACKey(id, []byte(secret)), nil
+	case "RS256", "EdDSA":
+	default:
+		return Key{}, fmt.Errorf("unsupported alg %q", alg)
+	}
+
+	if privateFile != "" {
+		data, err := readPEM(privateFile)
+		if err != nil {
+			return Key{}, err
+		}
+		if alg == "RS256" {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return Key{}, err
+			}
+			return NewRSAKey(id, private), nil
+		}
+		private, err := parseEd25519PrivateKey(data)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewEd25519Key(id, private), nil
+	}
+
+	if publicFile == "" {
+		return Key{}, errors.New("private_key_file or public_key_file is required")
+	}
+	data, err := readPEM(publicFile)
+	if err != nil {
+		return Key{}, err
+	}
+	if alg == "RS256" {
+		public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewRSAPublicKey(id, public), nil
+	}
+	public, err := parseEd25519PublicKey(data)
+	if err != nil {
+		return Key{}, err
+	}
+	return NewEd25519PublicKey(id, public), nil
+}
+
+func parseEd25519PrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+	return private, nil
+}
+
+func parseEd25519PublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return public, nil
+}
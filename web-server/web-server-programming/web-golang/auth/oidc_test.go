@@ -0,0 +1,243 @@
+// The tests are outside the package, oidctest imports it
+package jwt_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	auth "github.com/conglt10/web-golang/auth"
+	"github.com/conglt10/web-golang/auth/oidctest"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const testRedirectURL = "http://localhost/auth/oidc/test/callback"
+
+func newTestOIDC(t *testing.T, secret string) (*oidctest.Provider, *auth.OIDCProvider) {
+	mock := oidctest.NewProvider("client", secret)
+	t.Cleanup(mock.Close)
+	mock.SignIn(oidctest.User{Subject: "123", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{
+		Name:         "test",
+		Issuer:       mock.URL,
+		ClientID:     "client",
+		ClientSecret: secret,
+		RedirectURL:  testRedirectURL,
+	}, nil)
+	return mock, provider
+}
+
+// login starts a login and returns the code the provider redirected back
+// with, and the nonce and verifier the login was started with
+func login(t *testing.T, mock *oidctest.Provider, provider *auth.OIDCProvider) (code, nonce, verifier string) {
+	state, _ := auth.NewOIDCSecret()
+	nonce, _ = auth.NewOIDCSecret()
+	verifier, _ = auth.NewOIDCSecret()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Scheme + "://" + callback.Host + callback.Path; got != testRedirectURL {
+		t.Fatalf("redirected to %s, want %s", got, testRedirectURL)
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("state = %q, want %q", callback.Query().Get("state"), state)
+	}
+	return callback.Query().Get("code"), nonce, verifier
+}
+
+func TestOIDCExchange(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		secret string
+	}{
+		{"Confidential client", "s3cret:/?"},
+		{"Public client", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, provider := newTestOIDC(t, tt.secret)
+			code, nonce, verifier := login(t, mock, provider)
+
+			identity, err := provider.Exchange(context.Background(), code, verifier, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := auth.OIDCIdentity{Subject: "123", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+			if *identity != want {
+				t.Errorf("identity = %+v, want %+v", *identity, want)
+			}
+
+			// Codes are single use
+			if _, err := provider.Exchange(context.Background(), code, verifier, nonce); !errors.Is(err, auth.ErrOIDCRejected) {
+				t.Errorf("second exchange: got %v, want ErrOIDCRejected", err)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	mock, provider := newTestOIDC(t, "secret")
+	code, nonce, _ := login(t, mock, provider)
+
+	// An intercepted code is useless without the verifier
+	other, _ := auth.NewOIDCSecret()
+	if _, err := provider.Exchange(context.Background(), code, other, nonce); !errors.Is(err, auth.ErrOIDCRejected) {
+		t.Errorf("got %v, want ErrOIDCRejected", err)
+	}
+}
+
+func TestOIDCExchangeRejectsBadIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		nonce  string
+	}{
+		{"Other nonce", nil, "other"},
+		{"Other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, ""},
+		{"Other audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, ""},
+		{"Several audiences without azp", func(c jwt.MapClaims) { c["aud"] = []string{"client", "other-client"} }, ""},
+		{"Expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, ""},
+		{"No subject", func(c jwt.MapClaims) { delete(c, "sub") }, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, provider := newTestOIDC(t, "secret")
+			mock.ModifyClaims(tt.modify)
+			code, nonce, verifier := login(t, mock, provider)
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			if _, err := provider.Exchange(context.Background(), code, verifier, nonce); !errors.Is(err, auth.ErrInvalidIDToken) {
+				t.Errorf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeAcceptsAuthorizedParty(t *testing.T) {
+	mock, provider := newTestOIDC(t, "secret")
+	mock.ModifyClaims(func(c jwt.MapClaims) {
+		c["aud"] = []string{"client", "other-client"}
+		c["azp"] = "client"
+	})
+	code, nonce, verifier := login(t, mock, provider)
+
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); err != nil {
+		t.Errorf("got %v, want a valid identity", err)
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	mock, provider := newTestOIDC(t, "secret")
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != mock.Listener.Addr().String() || u.Path != "/authorize" {
+		t.Errorf("URL = %s", authURL)
+	}
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        auth.PKCEChallenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	got := auth.PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("PKCEChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestOIDCDiscoveryChecksIssuer(t *testing.T) {
+	mock := oidctest.NewProvider("client", "")
+	defer mock.Close()
+
+	// The provider announces its own URL, which is not the configured issuer
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:   "http://" + mock.Listener.Addr().String() + "/other",
+		ClientID: "client",
+	}, nil)
+	if _, err := provider.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Error("configuration of another issuer was accepted")
+	}
+}
+
+func TestParseJWK(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []auth.Key{auth.NewRSAKey("rs", rsaKey), auth.NewEd25519Key("ed", edKey)} {
+		t.Run(key.ID, func(t *testing.T) {
+			signer, err := auth.NewManager(auth.Config{Issuer: "issuer", Audience: "audience", SigningKeyID: key.ID, Keys: []auth.Key{key}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := signer.Create("alice", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The published key verifies the tokens of the signer
+			public, err := auth.ParseJWK(signer.JWKS().Keys[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier, err := auth.NewManager(auth.Config{
+				Issuer:       "issuer",
+				Audience:     "audience",
+				SigningKeyID: "hs",
+				Keys:         []auth.Key{auth.NewHMACKey("hs", []byte("secret")), public},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := verifier.Parse(token); err != nil {
+				t.Errorf("token was rejected: %v", err)
+			}
+		})
+	}
+
+	if _, err := auth.ParseJWK(auth.JWK{Kty: "RSA", Kid: "small", N: "AQAB", E: "AQAB"}); err == nil {
+		t.Error("a tiny RSA key was accepted")
+	}
+	if _, err := auth.ParseJWK(auth.JWK{Kty: "oct", Kid: "secret"}); err == nil {
+		t.Error("a symmetric key was accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package jwt
+
+import "context"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Username string
+	Roles    []string
+	// TokenID is the jti of the access token the request was made with
+	TokenID string
+	// APIKeyID is the API key the request was made with, and Scopes what
+	// the key may do. Both are empty for access tokens.
+	APIKeyID string
+	Scopes   []string
+}
+
+// Principal returns the caller the claims were issued to
+func (c *Claims) Principal() *Principal {
+	return &Principal{Username: c.Subject, Roles: c.Roles, TokenID: c.Id}
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by the CheckJwt
+// middleware, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
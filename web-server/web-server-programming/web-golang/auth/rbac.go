@@ -0,0 +1,58 @@
+package jwt
+
+import "github.com/conglt10/web-golang/models"
+
+// Permission is an action that is not open to every user
+type Permission string
+
+const (
+	PermEditAnyPost      Permission = "posts:edit_any"
+	PermDeleteAnyPost    Permission = "posts:delete_any"
+	PermDeleteAnyComment Permission = "comments:delete_any"
+	PermManageUsers      Permission = "users:manage"
+)
+
+// rolePermissions lists what each role may do. Every user may edit and
+// delete their own posts and comments, so that needs no permission.
+// Comments can only ever be edited by their author.
+var rolePermissions = map[string][]Permission{
+	models.RoleUser:      {},
+	models.RoleModerator: {PermEditAnyPost, PermDeleteAnyPost, PermDeleteAnyComment},
+	models.RoleAdmin:     {PermEditAnyPost, PermDeleteAnyPost, PermDeleteAnyComment, PermManageUsers},
+}
+
+// HasRole reports whether the principal has the role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the principal's roles grants the permission
+func (p *Principal) Can(perm Permission) bool {
+	for _, r := range p.Roles {
+		for _, granted := range rolePermissions[r] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the principal may act in the scope. Access tokens
+// have every scope, API keys only the ones they were given.
+func (p *Principal) HasScope(scope string) bool {
+	if p.APIKeyID == "" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// AccessTokenTTL is the default lifetime of access tokens
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long a session can go without being refreshed
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// NewRefreshToken returns a random opaque refresh token and the hash that
+// should be stored for it
+func NewRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the stored form of a refresh token. The tokens
+// are random, so a fast unsalted hash is enough to keep a database leak from
+// handing out live sessions.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottleConfig sets how quickly repeated failures are slowed down
+type ThrottleConfig struct {
+	// FreeFailures are allowed without any delay
+	FreeFailures int
+	// BaseDelay is the wait after the first failure past the free ones, it
+	// doubles with every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter failures, further attempts are refused for LockoutFor.
+	// Zero disables the lockout.
+	LockoutAfter int
+	LockoutFor   time.Duration
+	// ResetAfter without failures, the count starts over
+	ResetAfter time.Duration
+}
+
+var (
+	// DefaultUserThrottle guards a single account against password guessing
+	DefaultUserThrottle = ThrottleConfig{
+		FreeFailures: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     30 * time.Second,
+		LockoutAfter: 5,
+		LockoutFor:   15 * time.Minute,
+		ResetAfter:   15 * time.Minute,
+	}
+	// DefaultIPThrottle is looser, many users can share an address
+	DefaultIPThrottle = ThrottleConfig{
+		FreeFailures: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockoutAfter: 100,
+		LockoutFor:   time.Hour,
+		ResetAfter:   time.Hour,
+	}
+)
+
+// Throttle counts failures per key in memory and says how long a key has
+// to wait before its next attempt. Counts are lost on restart and are not
+// shared between instances.
+type Throttle struct {
+	config ThrottleConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	failures  map[string]*failures
+	lastSweep time.Time
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+func NewThrottle(config ThrottleConfig) *Throttle {
+	return &Throttle{config: config, now: time.Now, failures: make(map[string]*failures)}
+}
+
+// Wait returns how long key must wait before its next attempt, zero when it
+// may try now
+func (t *Throttle) Wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := t.failures[key]
+	if f == nil {
+		return 0
+	}
+	now := t.now()
+	if t.expired(f, now) {
+		delete(t.failures, key)
+		return 0
+	}
+	if wait := f.last.Add(t.delay(f.count)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Fail records a failed attempt of key
+func (t *Throttle) Fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.fail(key, t.now())
+}
+
+// Attempt returns how long key must wait like Wait. When it may try now,
+// the attempt is counted as a failure in the same step, so concurrent
+// attempts cannot all get past the check before any of them fails. Release
+// or Reset takes it back when the attempt succeeds.
+func (t *Throttle) Attempt(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if f := t.failures[key]; f != nil && !t.expired(f, now) {
+		if wait := f.last.Add(t.delay(f.count)).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	t.fail(key, now)
+	return 0
+}
+
+// Release takes back one attempt of key counted by Attempt
+func (t *Throttle) Release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := t.failures[key]
+	if f == nil {
+		return
+	}
+	if f.count--; f.count <= 0 {
+		delete(t.failures, key)
+	}
+}
+
+// Reset forgets the failures of key
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, key)
+}
+
+// fail counts a failure of key at now, t.mu must be held
+func (t *Throttle) fail(key string, now time.Time) {
+	t.sweep(now)
+	f := t.failures[key]
+	if f == nil || t.expired(f, now) {
+		f = &failures{}
+		t.failures[key] = f
+	}
+	f.count++
+	f.last = now
+}
+
+// delay is the wait after count failures
+func (t *Throttle) delay(count int) time.Duration {
+	c := t.config
+	if c.LockoutAfter > 0 && count >= c.LockoutAfter {
+		return c.LockoutFor
+	}
+	over := count - c.FreeFailures
+	if over <= 0 {
+		return 0
+	}
+	delay := c.BaseDelay
+	for i := 1; i < over && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	return delay
+}
+
+func (t *Throttle) expired(f *failures, now time.Time) bool {
+	// A lockout outlasts ResetAfter when it is the longer of the two
+	keep := t.config.ResetAfter
+	if d := t.delay(f.count); d > keep {
+		keep = d
+	}
+	return now.Sub(f.last) >= keep
+}
+
+// sweep drops expired entries at most once a minute, so keys that never
+// come back do not pile up
+func (t *Throttle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+	for key, f := range t.failures {
+		if t.expired(f, now) {
+			delete(t.failures, key)
+		}
+	}
+}
+
+// LoginThrottle slows down password guessing, per username and per client
+// IP. A nil *LoginThrottle never throttles.
+type LoginThrottle struct {
+	users *Throttle
+	ips   *Throttle
+}
+
+func NewLoginThrottle(users, ips ThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{users: NewThrottle(users), ips: NewThrottle(ips)}
+}
+
+// Attempt returns how long a login for username from ip has to wait, zero
+// when it may go ahead. An attempt that goes ahead is counted as failed
+// until Succeeded or Release is called, so parallel guesses are throttled
+// as well. Unknown usernames count too, so lockouts do not reveal which
+// accounts exist.
+func (l *LoginThrottle) Attempt(username, ip string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if wait := l.users.Attempt(username); wait > 0 {
+		return wait
+	}
+	if wait := l.ips.Attempt(ip); wait > 0 {
+		l.users.Release(username)
+		return wait
+	}
+	return 0
+}
+
+// Succeeded ends an attempt with the right password and clears the
+// failures of username. The IP only gets the attempt back, or one account
+// the attacker owns would reset its count between guesses.
+func (l *LoginThrottle) Succeeded(username, ip string) {
+	if l == nil {
+		return
+	}
+	l.users.Reset(username)
+	l.ips.Release(ip)
+}
+
+// Release takes back an attempt that ended before the password was
+// checked, such as on a database error
+func (l *LoginThrottle) Release(username, ip string) {
+	if l == nil {
+		return
+	}
+	l.users.Release(username)
+	l.ips.Release(ip)
+}
+
+// Reset clears the failures of username, after its password was changed
+// without logging in or the account was deleted
+func (l *LoginThrottle) Reset(username string) {
+	if l == nil {
+		return
+	}
+	l.users.Reset(username)
+}
@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for throttles
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+func newTestThrottle(config ThrottleConfig) (*Throttle, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	t := NewThrottle(config)
+	t.now = clock.Now
+	return t, clock
+}
+
+var testThrottle = ThrottleConfig{
+	FreeFailures: 1,
+	BaseDelay:    time.Second,
+	MaxDelay:     4 * time.Second,
+	LockoutAfter: 6,
+	LockoutFor:   time.Hour,
+	ResetAfter:   10 * time.Minute,
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	throttle, _ := newTestThrottle(testThrottle)
+
+	// The wait right after each failure
+	for i, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Hour} {
+		throttle.Fail("alice")
+		if got := throttle.Wait("alice"); got != want {
+			t.Errorf("after %d failures: wait %v, want %v", i+1, got, want)
+		}
+	}
+	if got := throttle.Wait("bob"); got != 0 {
+		t.Errorf("other keys must not wait, got %v", got)
+	}
+}
+
+func TestThrottleWaitElapses(t *testing.T) {
+	throttle, clock := newTestThrottle(testThrottle)
+
+	throttle.Fail("alice")
+	throttle.Fail("alice")
+	clock.Advance(600 * time.Millisecond)
+	if got := throttle.Wait("alice"); got != 400*time.Millisecond {
+		t.Errorf("wait = %v, want 400ms", got)
+	}
+	clock.Advance(400 * time.Millisecond)
+	if got := throttle.Wait("alice"); got != 0 {
+		t.Errorf("wait after the delay = %v, want 0", got)
+	}
+
+	// The count is kept until ResetAfter, the next failure waits longer
+	throttle.Fail("alice")
+	if got := throttle.Wait("alice"); got != 2*time.Second {
+		t.Errorf("wait after third failure = %v, want 2s", got)
+	}
+	clock.Advance(10 * time.Minute)
+	throttle.Fail("alice")
+	if got := throttle.Wait("alice"); got != 0 {
+		t.Errorf("failures should be forgotten after ResetAfter, wait = %v", got)
+	}
+}
+
+func TestThrottleLockoutOutlastsReset(t *testing.T) {
+	throttle, clock := newTestThrottle(testThrottle)
+	for i := 0; i < testThrottle.LockoutAfter; i++ {
+		throttle.Fail("alice")
+	}
+
+	clock.Advance(30 * time.Minute)
+	if got := throttle.Wait("alice"); got != 30*time.Minute {
+		t.Errorf("wait = %v, want the rest of the lockout", got)
+	}
+	clock.Advance(30 * time.Minute)
+	if got := throttle.Wait("alice"); got != 0 {
+		t.Errorf("wait after the lockout = %v, want 0", got)
+	}
+}
+
+func TestThrottleAttempt(t *testing.T) {
+	throttle, _ := newTestThrottle(testThrottle)
+
+	// An attempt counts as a failure right away
+	if got := throttle.Attempt("alice"); got != 0 {
+		t.Fatalf("first attempt waited %v", got)
+	}
+	if got := throttle.Attempt("alice"); got != 0 {
+		t.Fatalf("free attempt waited %v", got)
+	}
+	if got := throttle.Attempt("alice"); got != time.Second {
+		t.Errorf("attempt after two failures: wait %v, want 1s", got)
+	}
+
+	// Released attempts are taken back
+	throttle.Release("alice")
+	throttle.Release("alice")
+	if got := throttle.Wait("alice"); got != 0 {
+		t.Errorf("wait after releasing = %v", got)
+	}
+	throttle.Release("bob")
+}
+
+func TestThrottleConcurrentAttempts(t *testing.T) {
+	config := ThrottleConfig{LockoutAfter: 3, LockoutFor: time.Hour, ResetAfter: time.Hour}
+	throttle := NewThrottle(config)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if throttle.Attempt("alice") == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != int32(config.LockoutAfter) {
+		t.Errorf("%d parallel attempts got through, want %d", got, config.LockoutAfter)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	logins := NewLoginThrottle(testThrottle, ThrottleConfig{FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Minute, ResetAfter: time.Hour})
+
+	logins.Attempt("alice", "10.0.0.1")
+	logins.Attempt("alice", "10.0.0.1")
+	if logins.Attempt("alice", "10.0.0.2") == 0 {
+		t.Error("the username must wait from any address")
+	}
+	logins.Reset("alice")
+	if got := logins.Attempt("alice", "10.0.0.2"); got != 0 {
+		t.Errorf("reset should clear the username, wait = %v", got)
+	}
+	logins.Succeeded("alice", "10.0.0.2")
+
+	// A success gives the address its attempt back, no more
+	logins.Attempt("bob", "10.0.0.3")
+	logins.Succeeded("bob", "10.0.0.3")
+	logins.Attempt("bob", "10.0.0.3")
+	logins.Attempt("carol", "10.0.0.3")
+	if got := logins.Attempt("dave", "10.0.0.3"); got != 0 {
+		t.Errorf("address waited %v after two failures", got)
+	}
+	if logins.Attempt("erin", "10.0.0.3") == 0 {
+		t.Error("the address must wait for any username after its third failure")
+	}
+
+	// Refused by the address, the username keeps no attempt
+	if got := logins.users.Wait("erin"); got != 0 {
+		t.Errorf("username refused by its address waits %v", got)
+	}
+
+	var disabled *LoginThrottle
+	disabled.Succeeded("alice", "10.0.0.1")
+	disabled.Release("alice", "10.0.0.1")
+	disabled.Reset("alice")
+	if got := disabled.Attempt("alice", "10.0.0.1"); got != 0 {
+		t.Errorf("nil throttle waited %v", got)
+	}
+}
@@ -0,0 +1,23 @@
+package jwt
+
+import "time"
+
+const (
+	// VerifyEmailTTL is how long an email verification link works
+	VerifyEmailTTL = 24 * time.Hour
+	// ResetPasswordTTL is how long a password reset link works. It is short,
+	// the link is as good as the password.
+	ResetPasswordTTL = time.Hour
+)
+
+// NewUserToken returns a random single-use token for a link mailed to a
+// user, and the hash that should be stored for it. They are made and hashed
+// like refresh tokens.
+func NewUserToken() (token, hash string, err error) {
+	return NewRefreshToken()
+}
+
+// HashUserToken returns the stored form of a user token
+func HashUserToken(token string) string {
+	return HashRefreshToken(token)
+}
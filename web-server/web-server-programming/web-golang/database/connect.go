@@ -2,39 +2,72 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"os"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var (
-	client *mongo.Client
-	once   sync.Once
+// Collections of the application in its database
+const (
+	UsersCollection         = "users"
+	PostsCollection         = "posts"
+	CommentsCollection      = "comments"
+	LikesCollection         = "likes"
+	RefreshTokensCollection = "refresh_tokens"
+	UserTokensCollection    = "user_tokens"
+	IdentitiesCollection    = "identities"
+	APIKeysCollection       = "api_keys"
+	// MigrationsCollection holds a document per data migration that has run
+	MigrationsCollection = "migrations"
 )
 
-func init() {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found: %v", err)
+// Connect opens a client for the MongoDB at uri and checks that it answers
+func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		uri = "mongodb://" + uri
 	}
+
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetConnectTimeout(10 * time.Second).
+		SetServerSelectionTimeout(5 * time.Second)
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("ping MongoDB: %v", err)
+	}
+
+	log.Println("Successfully connected to MongoDB")
+	return client, nil
 }
 
-func InitDatabase() {
-	// Initialize collections and indexes
-	initUsers()
-	initPosts()
+// InitDatabase upgrades documents written by older versions and creates the
+// indexes of every collection
+func InitDatabase(database *mongo.Database) {
+	// Upgrade existing documents, then initialize collections and indexes
+	migrateDocuments(database)
+	initUsers(database)
+	initPosts(database)
+	initRefreshTokens(database)
+	initUserTokens(database)
+	initIdentities(database)
+	initAPIKeys(database)
+	initComments(database)
+	initLikes(database)
 	log.Println("Database initialized successfully")
 }
 
-func initUsers() {
-	collection := ConnectUsers()
+func initUsers(database *mongo.Database) {
+	collection := database.Collection(UsersCollection)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -61,29 +94,63 @@ func initUsers() {
 	if err != nil {
 		log.Printf("Warning: Failed to create email index: %v", err)
 	}
+
+	// Create unique index for id
+	_, err = collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create id index: %v", err)
+	}
 }
 
-func initPosts() {
-	collection := ConnectPosts()
+func initPosts(database *mongo.Database) {
+	collection := database.Collection(PostsCollection)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Create index for user_id for faster queries
+	// Create index for filtering by author, sorted like the feed
 	_, err := collection.Indexes().CreateOne(
 		ctx,
 		mongo.IndexModel{
-			Keys: bson.D{{Key: "user_id", Value: 1}},
+			Keys: bson.D{{Key: "author", Value: 1}, {Key: "created_at", Value: -1}, {Key: "id", Value: -1}},
 		},
 	)
 	if err != nil {
-		log.Printf("Warning: Failed to create user_id index: %v", err)
+		log.Printf("Warning: Failed to create author index: %v", err)
 	}
 
-	// Create index for created_at for sorting
+	// Create index for author_id to find a user's posts
 	_, err = collection.Indexes().CreateOne(
 		ctx,
 		mongo.IndexModel{
-			Keys: bson.D{{Key: "created_at", Value: -1}},
+			Keys: bson.D{{Key: "author_id", Value: 1}},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create author_id index: %v", err)
+	}
+
+	// Create index for filtering by tag
+	_, err = collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "tags", Value: 1}, {Key: "created_at", Value: -1}, {Key: "id", Value: -1}},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create tags index: %v", err)
+	}
+
+	// Create index for created_at for sorting and cursor pagination
+	_, err = collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "id", Value: -1}},
 		},
 	)
 	if err != nil {
@@ -91,54 +158,170 @@ func initPosts() {
 	}
 }
 
-// connectDB creates a singleton MongoDB client
-func connectDB() *mongo.Client {
-	once.Do(func() {
-		mongoURI := os.Getenv("MONGODB_URI")
-		if mongoURI == "" {
-			log.Fatal("MONGODB_URI not set in environment")
-		}
-
-		if !strings.HasPrefix(mongoURI, "mongodb://") && !strings.HasPrefix(mongoURI, "mongodb+srv://") {
-			mongoURI = "mongodb://" + mongoURI
-		}
-
-		clientOptions := options.Client().
-			ApplyURI(mongoURI).
-			SetConnectTimeout(10 * time.Second).
-			SetServerSelectionTimeout(5 * time.Second)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		var err error
-		client, err = mongo.Connect(ctx, clientOptions)
-		if err != nil {
-			log.Fatal("Failed to connect to MongoDB:", err)
-		}
-
-		err = client.Ping(ctx, nil)
-		if err != nil {
-			log.Fatal("Failed to ping MongoDB:", err)
-		}
-
-		log.Println("Successfully connected to MongoDB")
-	})
-	return client
+func initRefreshTokens(database *mongo.Database) {
+	collection := database.Collection(RefreshTokensCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Create unique index for token_hash, used to look up presented tokens
+	_, err := collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create token_hash index: %v", err)
+	}
+
+	// Create indexes for family and username, used to revoke sessions
+	_, err = collection.Indexes().CreateMany(
+		ctx,
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "family", Value: 1}}},
+			{Keys: bson.D{{Key: "username", Value: 1}}},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create refresh token indexes: %v", err)
+	}
+
+	// Let MongoDB delete expired sessions
+	_, err = collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create expires_at index: %v", err)
+	}
+}
+
+func initUserTokens(database *mongo.Database) {
+	collection := database.Collection(UserTokensCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(
+		ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "token_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			// Used to drop older tokens when a new one is sent
+			{Keys: bson.D{{Key: "username", Value: 1}, {Key: "purpose", Value: 1}}},
+			// Let MongoDB delete expired tokens
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create user token indexes: %v", err)
+	}
+}
+
+func initComments(database *mongo.Database) {
+	collection := database.Collection(CommentsCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Create unique index for id
+	_, err := collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create comment id index: %v", err)
+	}
+
+	// Create indexes for listing the comments of a post, for deleting
+	// replies and for anonymizing the comments of deleted users
+	_, err = collection.Indexes().CreateMany(
+		ctx,
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "id", Value: 1}}},
+			{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+			{Keys: bson.D{{Key: "author", Value: 1}}},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create comment indexes: %v", err)
+	}
 }
 
-func ConnectUsers() *mongo.Collection {
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "demo-web-server-2"
+func initLikes(database *mongo.Database) {
+	collection := database.Collection(LikesCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A user likes a post at most once, the index also serves counting
+	_, err := collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "post_id", Value: 1}, {Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create likes index: %v", err)
+	}
+
+	// Create index for deleting the likes of deleted users
+	_, err = collection.Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{Keys: bson.D{{Key: "username", Value: 1}}},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create likes username index: %v", err)
 	}
-	return connectDB().Database(dbName).Collection("users")
 }
 
-func ConnectPosts() *mongo.Collection {
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "demo-web-server-2"
+func initIdentities(database *mongo.Database) {
+	collection := database.Collection(IdentitiesCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(
+		ctx,
+		[]mongo.IndexModel{
+			// A subject is linked to one user at most
+			{
+				Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "username", Value: 1}}},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create identity indexes: %v", err)
+	}
+}
+
+func initAPIKeys(database *mongo.Database) {
+	collection := database.Collection(APIKeysCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(
+		ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "key_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to create API key indexes: %v", err)
 	}
-	return connectDB().Database(dbName).Collection("posts")
 }
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"html"
+	"log"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateDocuments brings documents written by older versions up to the
+// current models. Each step only matches documents that still need it, so
+// running it on every start is cheap and safe.
+func migrateDocuments(database *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	users := database.Collection(UsersCollection)
+	posts := database.Collection(PostsCollection)
+
+	// Users need ids before posts can reference them
+	if err := migrateUsers(ctx, users); err != nil {
+		log.Printf("Warning: Failed to migrate users: %v", err)
+		return
+	}
+	if err := migratePosts(ctx, posts, users); err != nil {
+		log.Printf("Warning: Failed to migrate posts: %v", err)
+		return
+	}
+
+	// Usernames, emails, titles, bodies and comments used to be HTML escaped
+	// before they were stored. Unescaping twice would corrupt text, so this
+	// runs once.
+	err := runOnce(ctx, database, "unescape-text", func() error {
+		if err := unescapeUsers(ctx, database); err != nil {
+			return err
+		}
+		for _, field := range []string{"title", "body"} {
+			if err := unescapeField(ctx, posts, field); err != nil {
+				return err
+			}
+		}
+		return unescapeField(ctx, database.Collection(CommentsCollection), "body")
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to unescape stored text: %v", err)
+	}
+}
+
+// runOnce runs migrate unless a migration with the name has completed
+func runOnce(ctx context.Context, database *mongo.Database, name string, migrate func() error) error {
+	migrations := database.Collection(MigrationsCollection)
+	count, err := migrations.CountDocuments(ctx, bson.M{"_id": name})
+	if err != nil || count > 0 {
+		return err
+	}
+	if err := migrate(); err != nil {
+		return err
+	}
+	_, err = migrations.InsertOne(ctx, bson.M{"_id": name, "applied_at": time.Now().UTC()})
+	return err
+}
+
+// unescapeField undoes the HTML escaping of field on every document that
+// contains an entity
+func unescapeField(ctx context.Context, collection *mongo.Collection, field string) error {
+	cursor, err := collection.Find(ctx, bson.M{field: bson.M{"$regex": "&"}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	n := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		text, _ := doc[field].(string)
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": doc["_id"]},
+			bson.M{"$set": bson.M{field: html.UnescapeString(text)}},
+		)
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Unescaped %s of %d documents in %s", field, n, collection.Name())
+	}
+	return nil
+}
+
+// usernameRefs are the fields that hold a username, by collection
+var usernameRefs = map[string]string{
+	PostsCollection:         "author",
+	CommentsCollection:      "author",
+	LikesCollection:         "username",
+	RefreshTokensCollection: "username",
+	UserTokensCollection:    "username",
+	IdentitiesCollection:    "username",
+	APIKeysCollection:       "username",
+}
+
+// unescapeUsers undoes the HTML escaping of usernames and emails. A renamed
+// user's posts, comments and other documents follow the new username.
+func unescapeUsers(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection(UsersCollection)
+	cursor, err := users.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"username": bson.M{"$regex": "&"}},
+		bson.M{"email": bson.M{"$regex": "&"}},
+	}})
+	if err != nil {
+		return err
+	}
+	var escaped []models.User
+	if err := cursor.All(ctx, &escaped); err != nil {
+		return err
+	}
+
+	for _, user := range escaped {
+		username := html.UnescapeString(user.Username)
+		_, err := users.UpdateOne(ctx,
+			bson.M{"username": user.Username},
+			bson.M{"$set": bson.M{"username": username, "email": html.UnescapeString(user.Email)}},
+		)
+		if err != nil {
+			return err
+		}
+		if username == user.Username {
+			continue
+		}
+		for collection, field := range usernameRefs {
+			_, err := database.Collection(collection).UpdateMany(ctx,
+				bson.M{field: user.Username},
+				bson.M{"$set": bson.M{field: username}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(escaped) > 0 {
+		log.Printf("Unescaped the username or email of %d users", len(escaped))
+	}
+	return nil
+}
+
+func migrateUsers(ctx context.Context, users *mongo.Collection) error {
+	cursor, err := users.Find(ctx, bson.M{"id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	n := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ObjectID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		_, err := users.UpdateOne(ctx,
+			bson.M{"_id": doc.ObjectID},
+			bson.M{"$set": bson.M{"id": uuid.NewV4().String()}},
+		)
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Assigned ids to %d users", n)
+	}
+
+	return setMissingTimestamps(ctx, users)
+}
+
+func migratePosts(ctx context.Context, posts, users *mongo.Collection) error {
+	result, err := posts.UpdateMany(ctx,
+		bson.M{"creater": bson.M{"$exists": true}},
+		bson.M{"$rename": bson.M{"creater": "author"}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Renamed creater to author on %d posts", result.ModifiedCount)
+	}
+
+	if err := setMissingTimestamps(ctx, posts); err != nil {
+		return err
+	}
+	// Posts written before drafts existed were all public
+	_, err = posts.UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": models.PostPublished, "tags": bson.A{}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	authors, err := posts.Distinct(ctx, "author", bson.M{"author_id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	for _, author := range authors {
+		var user struct {
+			ID string `bson:"id"`
+		}
+		err := users.FindOne(ctx, bson.M{"username": author}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			log.Printf("Warning: Posts by %v have no matching user", author)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = posts.UpdateMany(ctx,
+			bson.M{"author": author, "author_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"author_id": user.ID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setMissingTimestamps sets created_at and updated_at to now where missing
+func setMissingTimestamps(ctx context.Context, collection *mongo.Collection) error {
+	for _, field := range []string{"created_at", "updated_at"} {
+		_, err := collection.UpdateMany(ctx,
+			bson.M{field: bson.M{"$exists": false}},
+			bson.M{"$currentDate": bson.M{field: true}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
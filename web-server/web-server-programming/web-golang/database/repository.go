@@ -0,0 +1,650 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is the MongoDB error code for unique index violations
+const duplicateKeyCode = 11000
+
+// UserRepository is the MongoDB implementation of repository.UserRepository
+type UserRepository struct {
+	collection *mongo.Collection
+}
+
+func NewUserRepository(collection *mongo.Collection) *UserRepository {
+	return &UserRepository{collection: collection}
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) ExistsByUsernameOrEmail(ctx context.Context, username, email string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"$or": []bson.M{
+		{"username": username},
+		{"email": email},
+	}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	_, err := r.collection.InsertOne(ctx, user)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, cursor.Err()
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"username": username},
+		bson.M{"$set": bson.M{"roles": roles}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, user *models.User) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"username": user.Username},
+		bson.M{"$set": bson.M{
+			"password":        user.Password,
+			"password_scheme": user.PasswordScheme,
+			"updated_at":      time.Now().UTC().Truncate(time.Millisecond),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetEmailVerified(ctx context.Context, username, email string) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"username": username, "email": email},
+		bson.M{"$set": bson.M{
+			"email_verified": true,
+			"updated_at":     time.Now().UTC().Truncate(time.Millisecond),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"username": user.Username},
+		bson.M{"$set": bson.M{
+			"display_name":   user.DisplayName,
+			"email":          user.Email,
+			"email_verified": user.EmailVerified,
+			"updated_at":     user.UpdatedAt,
+		}},
+	)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	} else if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, username string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"username": username})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// PostRepository is the MongoDB implementation of repository.PostRepository
+type PostRepository struct {
+	collection *mongo.Collection
+}
+
+func NewPostRepository(collection *mongo.Collection) *PostRepository {
+	return &PostRepository{collection: collection}
+}
+
+func (r *PostRepository) Find(ctx context.Context, q repository.PostQuery) ([]models.Post, error) {
+	filter := postFilter(q.PostFilter)
+	dir, cmp := -1, "$lt"
+	if q.Order == repository.OldestFirst {
+		dir, cmp = 1, "$gt"
+	}
+	if c := q.After; c != nil {
+		// The filter may already use $or for visibility
+		filter = bson.M{"$and": []bson.M{filter, {"$or": []bson.M{
+			{"created_at": bson.M{cmp: c.CreatedAt}},
+			{"created_at": c.CreatedAt, "id": bson.M{cmp: c.ID}},
+		}}}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: dir}, {Key: "id", Value: dir}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	return r.find(ctx, filter, opts)
+}
+
+func (r *PostRepository) Count(ctx context.Context, f repository.PostFilter) (int64, error) {
+	if f == (repository.PostFilter{}) {
+		// Reads the collection metadata instead of scanning it
+		return r.collection.EstimatedDocumentCount(ctx)
+	}
+	return r.collection.CountDocuments(ctx, postFilter(f))
+}
+
+func postFilter(f repository.PostFilter) bson.M {
+	filter := bson.M{}
+	if f.Author != "" {
+		filter["author"] = f.Author
+	}
+	if f.TitleContains != "" {
+		filter["title"] = primitive.Regex{Pattern: regexp.QuoteMeta(f.TitleContains), Options: "i"}
+	}
+	if f.Tag != "" {
+		filter["tags"] = f.Tag
+	}
+	if f.VisibleTo != "" {
+		filter["$or"] = []bson.M{
+			{"status": bson.M{"$ne": models.PostDraft}},
+			{"author": f.VisibleTo},
+		}
+	}
+	return filter
+}
+
+func (r *PostRepository) FindByAuthor(ctx context.Context, username string) ([]models.Post, error) {
+	return r.find(ctx, bson.M{"author": username})
+}
+
+func (r *PostRepository) find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]models.Post, error) {
+	cursor, err := r.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var posts []models.Post
+	for cursor.Next(ctx) {
+		var post models.Post
+		if err := cursor.Decode(&post); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, cursor.Err()
+}
+
+func (r *PostRepository) FindByID(ctx context.Context, id string) (*models.Post, error) {
+	var post models.Post
+	err := r.collection.FindOne(ctx, bson.M{"id": id}).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (r *PostRepository) Create(ctx context.Context, post *models.Post) error {
+	_, err := r.collection.InsertOne(ctx, post)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *PostRepository) Update(ctx context.Context, post *models.Post) error {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"id": post.ID}, post)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func isDuplicateKey(err error) bool {
+	if we, ok := err.(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UserTokenRepository is the MongoDB implementation of
+// repository.UserTokenRepository
+type UserTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewUserTokenRepository(collection *mongo.Collection) *UserTokenRepository {
+	return &UserTokenRepository{collection: collection}
+}
+
+func (r *UserTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	_, err := r.collection.InsertOne(ctx, token)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *UserTokenRepository) Consume(ctx context.Context, hash, purpose string, now time.Time) (*models.UserToken, error) {
+	var token models.UserToken
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"token_hash": hash, "purpose": purpose, "used": false, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"used": true}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *UserTokenRepository) DeleteForUser(ctx context.Context, username, purpose string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"username": username, "purpose": purpose})
+	return err
+}
+
+// IdentityRepository is the MongoDB implementation of
+// repository.IdentityRepository
+type IdentityRepository struct {
+	collection *mongo.Collection
+}
+
+func NewIdentityRepository(collection *mongo.Collection) *IdentityRepository {
+	return &IdentityRepository{collection: collection}
+}
+
+func (r *IdentityRepository) Find(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	err := r.collection.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&identity)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *IdentityRepository) Create(ctx context.Context, identity *models.Identity) error {
+	_, err := r.collection.InsertOne(ctx, identity)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *IdentityRepository) DeleteForUser(ctx context.Context, username string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"username": username})
+	return err
+}
+
+// APIKeyRepository is the MongoDB implementation of
+// repository.APIKeyRepository
+type APIKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAPIKeyRepository(collection *mongo.Collection) *APIKeyRepository {
+	return &APIKeyRepository{collection: collection}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	_, err := r.collection.InsertOne(ctx, key)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.collection.FindOne(ctx, bson.M{"key_hash": hash}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) ListForUser(ctx context.Context, username string) ([]models.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "id", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"username": username}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var keys []models.APIKey
+	for cursor.Next(ctx) {
+		var key models.APIKey
+		if err := cursor.Decode(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, cursor.Err()
+}
+
+func (r *APIKeyRepository) Delete(ctx context.Context, username, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"id": id, "username": username})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) DeleteForUser(ctx context.Context, username string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"username": username})
+	return err
+}
+
+func (r *APIKeyRepository) Touch(ctx context.Context, id string, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+// RefreshTokenRepository is the MongoDB implementation of
+// repository.RefreshTokenRepository
+type RefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRefreshTokenRepository(collection *mongo.Collection) *RefreshTokenRepository {
+	return &RefreshTokenRepository{collection: collection}
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.collection.InsertOne(ctx, token)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.collection.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, id string) error {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"id": id, "rotated": false, "revoked": false},
+		bson.M{"$set": bson.M{"rotated": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, family string) error {
+	_, err := r.collection.UpdateMany(ctx, bson.M{"family": family}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, username string) error {
+	_, err := r.collection.UpdateMany(ctx, bson.M{"username": username}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+// CommentRepository is the MongoDB implementation of
+// repository.CommentRepository
+type CommentRepository struct {
+	collection *mongo.Collection
+}
+
+func NewCommentRepository(collection *mongo.Collection) *CommentRepository {
+	return &CommentRepository{collection: collection}
+}
+
+func (r *CommentRepository) FindByPost(ctx context.Context, postID string) ([]models.Comment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"post_id": postID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var comments []models.Comment
+	for cursor.Next(ctx) {
+		var comment models.Comment
+		if err := cursor.Decode(&comment); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, cursor.Err()
+}
+
+func (r *CommentRepository) FindByID(ctx context.Context, id string) (*models.Comment, error) {
+	var comment models.Comment
+	err := r.collection.FindOne(ctx, bson.M{"id": id}).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+func (r *CommentRepository) Create(ctx context.Context, comment *models.Comment) error {
+	_, err := r.collection.InsertOne(ctx, comment)
+	if isDuplicateKey(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *CommentRepository) Update(ctx context.Context, comment *models.Comment) error {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"id": comment.ID}, comment)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *CommentRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return repository.ErrNotFound
+	}
+	_, err = r.collection.DeleteMany(ctx, bson.M{"parent_id": id})
+	return err
+}
+
+func (r *CommentRepository) DeleteByPost(ctx context.Context, postID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"post_id": postID})
+	return err
+}
+
+func (r *CommentRepository) AnonymizeAuthor(ctx context.Context, username string) error {
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"author": username},
+		bson.M{"$set": bson.M{"author_id": "", "author": ""}},
+	)
+	return err
+}
+
+// LikeRepository is the MongoDB implementation of repository.LikeRepository.
+// Each like is a document with a unique (post_id, username) pair.
+type LikeRepository struct {
+	collection *mongo.Collection
+}
+
+func NewLikeRepository(collection *mongo.Collection) *LikeRepository {
+	return &LikeRepository{collection: collection}
+}
+
+func (r *LikeRepository) Like(ctx context.Context, postID, username string) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"post_id": postID, "username": username},
+		bson.M{"$setOnInsert": bson.M{"created_at": time.Now().UTC()}},
+		options.Update().SetUpsert(true),
+	)
+	// Two concurrent upserts can both try to insert, the loser already has
+	// what it asked for
+	if isDuplicateKey(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *LikeRepository) Unlike(ctx context.Context, postID, username string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"post_id": postID, "username": username})
+	return err
+}
+
+func (r *LikeRepository) Count(ctx context.Context, postIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(postIDs) == 0 {
+		return counts, nil
+	}
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"post_id": bson.M{"$in": postIDs}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$post_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			PostID string `bson:"_id"`
+			Count  int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		counts[row.PostID] = row.Count
+	}
+	return counts, cursor.Err()
+}
+
+func (r *LikeRepository) DeleteByPost(ctx context.Context, postID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"post_id": postID})
+	return err
+}
+
+func (r *LikeRepository) DeleteByUser(ctx context.Context, username string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"username": username})
+	return err
+}
@@ -0,0 +1,448 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// migration is a schema change applied once, in order of version
+type migration struct {
+	version     int
+	description string
+	statements  []string
+	// backfill runs after the statements, in the same transaction, for data
+	// changes that cannot be written portably in SQL
+	backfill func(tx *sql.Tx, rebind func(string) string) error
+}
+
+// migrations must only ever be appended to. The SQL is kept to the subset
+// understood by both SQLite and Postgres.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create users and posts",
+		statements: []string{
+			// Unique constraints match the Mongo indexes created in initUsers
+			`CREATE TABLE users (
+				username TEXT NOT NULL,
+				email    TEXT NOT NULL,
+				password TEXT NOT NULL,
+				CONSTRAINT users_username_key UNIQUE (username),
+				CONSTRAINT users_email_key UNIQUE (email)
+			)`,
+			`CREATE TABLE posts (
+				id      TEXT PRIMARY KEY,
+				creater TEXT NOT NULL,
+				title   TEXT NOT NULL
+			)`,
+			`CREATE INDEX posts_creater_idx ON posts (creater)`,
+		},
+	},
+	{
+		version:     2,
+		description: "create refresh_tokens",
+		statements: []string{
+			`CREATE TABLE refresh_tokens (
+				id         TEXT PRIMARY KEY,
+				family     TEXT NOT NULL,
+				username   TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+				token_hash TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				rotated    BOOLEAN NOT NULL DEFAULT FALSE,
+				revoked    BOOLEAN NOT NULL DEFAULT FALSE,
+				CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash)
+			)`,
+			`CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family)`,
+			`CREATE INDEX refresh_tokens_username_idx ON refresh_tokens (username)`,
+		},
+	},
+	{
+		version:     3,
+		description: "add users.roles",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN roles TEXT NOT NULL DEFAULT 'user'`,
+		},
+	},
+	{
+		version:     4,
+		description: "add posts.created_at",
+		statements: []string{
+			// SQLite cannot add a column defaulting to the current time,
+			// so existing posts are backfilled separately
+			`ALTER TABLE posts ADD COLUMN created_at TIMESTAMP`,
+			`CREATE INDEX posts_created_at_idx ON posts (created_at, id)`,
+			`CREATE INDEX posts_creater_created_at_idx ON posts (creater, created_at, id)`,
+			`DROP INDEX posts_creater_idx`,
+		},
+		backfill: func(tx *sql.Tx, rebind func(string) string) error {
+			// Bound rather than CURRENT_TIMESTAMP, so SQLite stores the
+			// text in the format page cursors are compared with
+			_, err := tx.Exec(rebind(`UPDATE posts SET created_at = ?`), time.Now().UTC())
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "add ids and timestamps, rename posts.creater to author",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN id TEXT`,
+			`ALTER TABLE users ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE users ADD COLUMN updated_at TIMESTAMP`,
+			`ALTER TABLE posts RENAME COLUMN creater TO author`,
+			`ALTER TABLE posts ADD COLUMN author_id TEXT`,
+			`ALTER TABLE posts ADD COLUMN body TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE posts ADD COLUMN updated_at TIMESTAMP`,
+			`UPDATE posts SET updated_at = created_at`,
+			`DROP INDEX posts_creater_created_at_idx`,
+			`CREATE INDEX posts_author_created_at_idx ON posts (author, created_at, id)`,
+			`CREATE INDEX posts_author_id_idx ON posts (author_id)`,
+		},
+		backfill: func(tx *sql.Tx, rebind func(string) string) error {
+			now := time.Now().UTC()
+			if _, err := tx.Exec(rebind(`UPDATE users SET created_at = ?, updated_at = ?`), now, now); err != nil {
+				return err
+			}
+
+			rows, err := tx.Query(`SELECT username FROM users`)
+			if err != nil {
+				return err
+			}
+			var usernames []string
+			for rows.Next() {
+				var username string
+				if err := rows.Scan(&username); err != nil {
+					rows.Close()
+					return err
+				}
+				usernames = append(usernames, username)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, username := range usernames {
+				_, err := tx.Exec(rebind(`UPDATE users SET id = ? WHERE username = ?`), uuid.NewV4().String(), username)
+				if err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec(`CREATE UNIQUE INDEX users_id_key ON users (id)`); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE posts SET author_id = (SELECT id FROM users WHERE users.username = posts.author)`)
+			return err
+		},
+	},
+	{
+		version:     6,
+		description: "add posts.tags and posts.status",
+		statements: []string{
+			`ALTER TABLE posts ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+			// Posts written before drafts existed were all public
+			`ALTER TABLE posts ADD COLUMN status TEXT NOT NULL DEFAULT 'published'`,
+		},
+	},
+	{
+		version:     7,
+		description: "create comments and post_likes",
+		statements: []string{
+			// Replies and comments of deleted posts are deleted with them
+			`CREATE TABLE comments (
+				id         TEXT PRIMARY KEY,
+				post_id    TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+				parent_id  TEXT REFERENCES comments (id) ON DELETE CASCADE,
+				author_id  TEXT NOT NULL,
+				author     TEXT NOT NULL,
+				body       TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX comments_post_id_created_at_idx ON comments (post_id, created_at, id)`,
+			`CREATE INDEX comments_parent_id_idx ON comments (parent_id)`,
+			`CREATE TABLE post_likes (
+				post_id    TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+				username   TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (post_id, username)
+			)`,
+		},
+	},
+	{
+		version:     8,
+		description: "store text unescaped and add users.password_scheme",
+		statements: []string{
+			// Existing hashes were made from the HTML escaped password
+			`ALTER TABLE users ADD COLUMN password_scheme INTEGER NOT NULL DEFAULT 0`,
+		},
+		backfill: func(tx *sql.Tx, rebind func(string) string) error {
+			if err := unescapeUsers(tx, rebind); err != nil {
+				return err
+			}
+			if err := unescapeColumn(tx, rebind, "posts", "title"); err != nil {
+				return err
+			}
+			if err := unescapeColumn(tx, rebind, "posts", "body"); err != nil {
+				return err
+			}
+			return unescapeColumn(tx, rebind, "comments", "body")
+		},
+	},
+	{
+		version:     9,
+		description: "add users.email_verified and create user_tokens",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE TABLE user_tokens (
+				id         TEXT PRIMARY KEY,
+				username   TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+				purpose    TEXT NOT NULL,
+				email      TEXT NOT NULL,
+				token_hash TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				used       BOOLEAN NOT NULL DEFAULT FALSE,
+				CONSTRAINT user_tokens_token_hash_key UNIQUE (token_hash)
+			)`,
+			`CREATE INDEX user_tokens_username_purpose_idx ON user_tokens (username, purpose)`,
+		},
+	},
+	{
+		version:     10,
+		description: "add users.display_name and index content by user",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''`,
+			// For anonymizing and deleting the content of deleted users
+			`CREATE INDEX comments_author_idx ON comments (author)`,
+			`CREATE INDEX post_likes_username_idx ON post_likes (username)`,
+		},
+	},
+	{
+		version:     11,
+		description: "create identities",
+		statements: []string{
+			`CREATE TABLE identities (
+				provider   TEXT NOT NULL,
+				subject    TEXT NOT NULL,
+				username   TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+				email      TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (provider, subject)
+			)`,
+			`CREATE INDEX identities_username_idx ON identities (username)`,
+		},
+	},
+	{
+		version:     12,
+		description: "create api_keys",
+		statements: []string{
+			`CREATE TABLE api_keys (
+				id           TEXT PRIMARY KEY,
+				username     TEXT NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+				name         TEXT NOT NULL,
+				prefix       TEXT NOT NULL,
+				key_hash     TEXT NOT NULL,
+				scopes       TEXT NOT NULL DEFAULT '',
+				last_used_at TIMESTAMP,
+				created_at   TIMESTAMP NOT NULL,
+				CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
+			)`,
+			`CREATE INDEX api_keys_username_idx ON api_keys (username, created_at)`,
+		},
+	},
+	{
+		version:     13,
+		description: "store backfilled timestamps in the bound format",
+		// Migrations 4 and 5 used to backfill with CURRENT_TIMESTAMP, which
+		// SQLite stores as text that does not compare with bound times
+		backfill: func(tx *sql.Tx, rebind func(string) string) error {
+			if err := rewriteTimes(tx, rebind, "posts", "id", "created_at", "updated_at"); err != nil {
+				return err
+			}
+			return rewriteTimes(tx, rebind, "users", "username", "created_at", "updated_at")
+		},
+	},
+}
+
+// unescapeUsers undoes the HTML escaping of usernames and emails. A renamed
+// user's posts, comments and likes follow the new username, their sessions
+// reference the old one and are ended.
+func unescapeUsers(tx *sql.Tx, rebind func(string) string) error {
+	rows, err := tx.Query(`SELECT username, email FROM users WHERE username LIKE '%&%' OR email LIKE '%&%'`)
+	if err != nil {
+		return err
+	}
+	escaped := map[string]string{}
+	for rows.Next() {
+		var username, email string
+		if err := rows.Scan(&username, &email); err != nil {
+			rows.Close()
+			return err
+		}
+		escaped[username] = email
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for old, email := range escaped {
+		username := html.UnescapeString(old)
+		if username != old {
+			if _, err := tx.Exec(rebind(`DELETE FROM refresh_tokens WHERE username = ?`), old); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(rebind(`UPDATE users SET username = ?, email = ? WHERE username = ?`), username, html.UnescapeString(email), old)
+		if err != nil {
+			return err
+		}
+		if username == old {
+			continue
+		}
+		for _, ref := range [][2]string{{"posts", "author"}, {"comments", "author"}, {"post_likes", "username"}} {
+			table, column := ref[0], ref[1]
+			if _, err := tx.Exec(rebind(`UPDATE `+table+` SET `+column+` = ? WHERE `+column+` = ?`), username, old); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteTimes reads the timestamp columns of every row and writes them back
+// as bound parameters, so they are all stored the same way. Rows are matched
+// by key, table and columns are never user input.
+func rewriteTimes(tx *sql.Tx, rebind func(string) string, table, key string, columns ...string) error {
+	rows, err := tx.Query(`SELECT ` + key + `, ` + strings.Join(columns, `, `) + ` FROM ` + table)
+	if err != nil {
+		return err
+	}
+	stored := map[string][]interface{}{}
+	for rows.Next() {
+		var k string
+		times := make([]sql.NullTime, len(columns))
+		dest := []interface{}{&k}
+		for i := range times {
+			dest = append(dest, &times[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		args := make([]interface{}, len(times))
+		for i, t := range times {
+			if t.Valid {
+				args[i] = t.Time.UTC()
+			}
+		}
+		stored[k] = args
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	set := make([]string, len(columns))
+	for i, c := range columns {
+		set[i] = c + ` = ?`
+	}
+	query := rebind(`UPDATE ` + table + ` SET ` + strings.Join(set, `, `) + ` WHERE ` + key + ` = ?`)
+	for k, args := range stored {
+		if _, err := tx.Exec(query, append(args, k)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unescapeColumn undoes the HTML escaping that was applied to text before
+// it was stored. Rows are only read and written by id, table and column are
+// never user input.
+func unescapeColumn(tx *sql.Tx, rebind func(string) string, table, column string) error {
+	rows, err := tx.Query(`SELECT id, ` + column + ` FROM ` + table + ` WHERE ` + column + ` LIKE '%&%'`)
+	if err != nil {
+		return err
+	}
+	escaped := map[string]string{}
+	for rows.Next() {
+		var id, text string
+		if err := rows.Scan(&id, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		escaped[id] = text
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, text := range escaped {
+		_, err := tx.Exec(rebind(`UPDATE `+table+` SET `+column+` = ? WHERE id = ?`), html.UnescapeString(text), id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrate applies every migration newer than the current schema version,
+// each one in its own transaction
+func (db *DB) Migrate() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %v", err)
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("read schema version: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.version, m.description, err)
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+func (db *DB) apply(m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if m.backfill != nil {
+		if err := m.backfill(tx, db.rebind); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(db.rebind(`INSERT INTO schema_migrations (version, description) VALUES (?, ?)`), m.version, m.description)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
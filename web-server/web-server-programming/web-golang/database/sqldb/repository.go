@@ -0,0 +1,725 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+)
+
+// UserRepository is the SQL implementation of repository.UserRepository
+type UserRepository struct {
+	db *DB
+}
+
+func NewUserRepository(db *DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+const userColumns = `id, username, display_name, email, email_verified, password, password_scheme, roles, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	var user models.User
+	var roles string
+	err := row.Scan(&user.ID, &user.Username, &user.DisplayName, &user.Email, &user.EmailVerified, &user.Password, &user.PasswordScheme, &roles,
+		&user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = splitList(roles)
+	return &user, nil
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT `+userColumns+` FROM users WHERE username = ?`), username))
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	return user, err
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT `+userColumns+` FROM users WHERE email = ?`), email))
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	return user, err
+}
+
+func (r *UserRepository) ExistsByUsernameOrEmail(ctx context.Context, username, email string) (bool, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT COUNT(*) FROM users WHERE username = ? OR email = ?`), username, email,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		user.ID, user.Username, user.DisplayName, user.Email, user.EmailVerified, user.Password, user.PasswordScheme, joinList(user.GetRoles()),
+		user.CreatedAt.UTC(), user.UpdatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE users SET roles = ?, updated_at = ? WHERE username = ?`),
+		joinList(roles), time.Now().UTC(), username)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, user *models.User) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE users SET password = ?, password_scheme = ?, updated_at = ? WHERE username = ?`),
+		user.Password, user.PasswordScheme, time.Now().UTC(), user.Username)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *UserRepository) SetEmailVerified(ctx context.Context, username, email string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE users SET email_verified = ?, updated_at = ? WHERE username = ? AND email = ?`),
+		true, time.Now().UTC(), username, email)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE users SET display_name = ?, email = ?, email_verified = ?, updated_at = ? WHERE username = ?`),
+		user.DisplayName, user.Email, user.EmailVerified, user.UpdatedAt.UTC(), user.Username)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	} else if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// Delete relies on the foreign keys to delete the sessions and mailed tokens
+// of the user
+func (r *UserRepository) Delete(ctx context.Context, username string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(`DELETE FROM users WHERE username = ?`), username)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// Roles and tags are stored as comma separated lists, they never contain
+// commas
+func joinList(items []string) string {
+	return strings.Join(items, ",")
+}
+
+func splitList(items string) []string {
+	if items == "" {
+		return nil
+	}
+	return strings.Split(items, ",")
+}
+
+// PostRepository is the SQL implementation of repository.PostRepository
+type PostRepository struct {
+	db *DB
+}
+
+func NewPostRepository(db *DB) *PostRepository {
+	return &PostRepository{db: db}
+}
+
+const postColumns = `id, author_id, author, title, body, tags, status, created_at, updated_at`
+
+func scanPost(row scanner) (*models.Post, error) {
+	var post models.Post
+	var tags string
+	err := row.Scan(&post.ID, &post.AuthorID, &post.Author, &post.Title, &post.Body, &tags, &post.Status,
+		&post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	post.Tags = append([]string{}, splitList(tags)...)
+	return &post, nil
+}
+
+func (r *PostRepository) Find(ctx context.Context, q repository.PostQuery) ([]models.Post, error) {
+	where, args := postFilter(q.PostFilter)
+	dir, cmp := "DESC", "<"
+	if q.Order == repository.OldestFirst {
+		dir, cmp = "ASC", ">"
+	}
+	if c := q.After; c != nil {
+		where = append(where, `(created_at `+cmp+` ? OR (created_at = ? AND id `+cmp+` ?))`)
+		args = append(args, c.CreatedAt.UTC(), c.CreatedAt.UTC(), c.ID)
+	}
+
+	query := `SELECT ` + postColumns + ` FROM posts` + whereClause(where) +
+		` ORDER BY created_at ` + dir + `, id ` + dir
+	if q.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(q.Limit)
+	}
+	return r.find(ctx, query, args...)
+}
+
+func (r *PostRepository) Count(ctx context.Context, f repository.PostFilter) (int64, error) {
+	where, args := postFilter(f)
+	var n int64
+	err := r.db.QueryRowContext(ctx, r.db.rebind(`SELECT COUNT(*) FROM posts`+whereClause(where)), args...).Scan(&n)
+	return n, err
+}
+
+func postFilter(f repository.PostFilter) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.Author != "" {
+		where = append(where, `author = ?`)
+		args = append(args, f.Author)
+	}
+	if f.TitleContains != "" {
+		where = append(where, `LOWER(title) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(f.TitleContains))+"%")
+	}
+	if f.Tag != "" {
+		where = append(where, `(',' || tags || ',') LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+likeEscaper.Replace(f.Tag)+",%")
+	}
+	if f.VisibleTo != "" {
+		where = append(where, `(status <> ? OR author = ?)`)
+		args = append(args, models.PostDraft, f.VisibleTo)
+	}
+	return where, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `)
+}
+
+func (r *PostRepository) FindByAuthor(ctx context.Context, username string) ([]models.Post, error) {
+	return r.find(ctx, `SELECT `+postColumns+` FROM posts WHERE author = ? ORDER BY created_at DESC, id DESC`, username)
+}
+
+func (r *PostRepository) find(ctx context.Context, query string, args ...interface{}) ([]models.Post, error) {
+	rows, err := r.db.QueryContext(ctx, r.db.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, *post)
+	}
+	return posts, rows.Err()
+}
+
+func (r *PostRepository) FindByID(ctx context.Context, id string) (*models.Post, error) {
+	post, err := scanPost(r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT `+postColumns+` FROM posts WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	return post, err
+}
+
+func (r *PostRepository) Create(ctx context.Context, post *models.Post) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO posts (id, author_id, author, title, body, tags, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		post.ID, post.AuthorID, post.Author, post.Title, post.Body, joinList(post.Tags), post.Status,
+		post.CreatedAt.UTC(), post.UpdatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *PostRepository) Update(ctx context.Context, post *models.Post) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE posts SET author_id = ?, author = ?, title = ?, body = ?, tags = ?, status = ?, updated_at = ?
+		WHERE id = ?`),
+		post.AuthorID, post.Author, post.Title, post.Body, joinList(post.Tags), post.Status,
+		post.UpdatedAt.UTC(), post.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *PostRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(`DELETE FROM posts WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// expectAffected maps a statement that matched no rows to ErrNotFound
+func expectAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// UserTokenRepository is the SQL implementation of
+// repository.UserTokenRepository
+type UserTokenRepository struct {
+	db *DB
+}
+
+func NewUserTokenRepository(db *DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+func (r *UserTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO user_tokens (id, username, purpose, email, token_hash, expires_at, created_at, used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		token.ID, token.Username, token.Purpose, token.Email, token.TokenHash,
+		token.ExpiresAt.UTC(), token.CreatedAt.UTC(), token.Used,
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *UserTokenRepository) Consume(ctx context.Context, hash, purpose string, now time.Time) (*models.UserToken, error) {
+	// The update decides which request gets the token, the row is only
+	// read once it is ours
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE user_tokens SET used = ? WHERE token_hash = ? AND purpose = ? AND used = ? AND expires_at > ?`),
+		true, hash, purpose, false, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := expectAffected(result); err != nil {
+		return nil, err
+	}
+
+	var token models.UserToken
+	err = r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT id, username, purpose, email, token_hash, expires_at, created_at, used
+		FROM user_tokens WHERE token_hash = ?`), hash,
+	).Scan(&token.ID, &token.Username, &token.Purpose, &token.Email, &token.TokenHash,
+		&token.ExpiresAt, &token.CreatedAt, &token.Used)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *UserTokenRepository) DeleteForUser(ctx context.Context, username, purpose string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`DELETE FROM user_tokens WHERE username = ? AND purpose = ?`), username, purpose)
+	return err
+}
+
+// IdentityRepository is the SQL implementation of
+// repository.IdentityRepository
+type IdentityRepository struct {
+	db *DB
+}
+
+func NewIdentityRepository(db *DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+func (r *IdentityRepository) Find(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	err := r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT provider, subject, username, email, created_at FROM identities WHERE provider = ? AND subject = ?`),
+		provider, subject,
+	).Scan(&identity.Provider, &identity.Subject, &identity.Username, &identity.Email, &identity.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *IdentityRepository) Create(ctx context.Context, identity *models.Identity) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO identities (provider, subject, username, email, created_at) VALUES (?, ?, ?, ?, ?)`),
+		identity.Provider, identity.Subject, identity.Username, identity.Email, identity.CreatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *IdentityRepository) DeleteForUser(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`DELETE FROM identities WHERE username = ?`), username)
+	return err
+}
+
+// APIKeyRepository is the SQL implementation of repository.APIKeyRepository
+type APIKeyRepository struct {
+	db *DB
+}
+
+func NewAPIKeyRepository(db *DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+const apiKeyColumns = `id, username, name, prefix, key_hash, scopes, last_used_at, created_at`
+
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsed sql.NullTime
+	err := row.Scan(&key.ID, &key.Username, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &lastUsed, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = splitList(scopes)
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	var lastUsed sql.NullTime
+	if key.LastUsedAt != nil {
+		lastUsed = sql.NullTime{Time: key.LastUsedAt.UTC(), Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO api_keys (`+apiKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		key.ID, key.Username, key.Name, key.Prefix, key.KeyHash, joinList(key.Scopes), lastUsed, key.CreatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`), hash))
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	return key, err
+}
+
+func (r *APIKeyRepository) ListForUser(ctx context.Context, username string) ([]models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, r.db.rebind(
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE username = ? ORDER BY created_at DESC, id DESC`), username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *APIKeyRepository) Delete(ctx context.Context, username, id string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`DELETE FROM api_keys WHERE id = ? AND username = ?`), id, username)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *APIKeyRepository) DeleteForUser(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`DELETE FROM api_keys WHERE username = ?`), username)
+	return err
+}
+
+func (r *APIKeyRepository) Touch(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE api_keys SET last_used_at = ? WHERE id = ?`), at.UTC(), id)
+	return err
+}
+
+// RefreshTokenRepository is the SQL implementation of
+// repository.RefreshTokenRepository
+type RefreshTokenRepository struct {
+	db *DB
+}
+
+func NewRefreshTokenRepository(db *DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO refresh_tokens (id, family, username, token_hash, expires_at, created_at, rotated, revoked)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		token.ID, token.Family, token.Username, token.TokenHash,
+		token.ExpiresAt.UTC(), token.CreatedAt.UTC(), token.Rotated, token.Revoked,
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT id, family, username, token_hash, expires_at, created_at, rotated, revoked
+		FROM refresh_tokens WHERE token_hash = ?`), hash,
+	).Scan(&token.ID, &token.Family, &token.Username, &token.TokenHash,
+		&token.ExpiresAt, &token.CreatedAt, &token.Rotated, &token.Revoked)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE refresh_tokens SET rotated = ? WHERE id = ? AND rotated = ? AND revoked = ?`), true, id, false, false)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, family string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE refresh_tokens SET revoked = ? WHERE family = ?`), true, family)
+	return err
+}
+
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE refresh_tokens SET revoked = ? WHERE username = ?`), true, username)
+	return err
+}
+
+// CommentRepository is the SQL implementation of repository.CommentRepository
+type CommentRepository struct {
+	db *DB
+}
+
+func NewCommentRepository(db *DB) *CommentRepository {
+	return &CommentRepository{db: db}
+}
+
+const commentColumns = `id, post_id, parent_id, author_id, author, body, created_at, updated_at`
+
+func scanComment(row scanner) (*models.Comment, error) {
+	var comment models.Comment
+	var parentID sql.NullString
+	err := row.Scan(&comment.ID, &comment.PostID, &parentID, &comment.AuthorID, &comment.Author, &comment.Body,
+		&comment.CreatedAt, &comment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	comment.ParentID = parentID.String
+	return &comment, nil
+}
+
+func (r *CommentRepository) FindByPost(ctx context.Context, postID string) ([]models.Comment, error) {
+	rows, err := r.db.QueryContext(ctx, r.db.rebind(
+		`SELECT `+commentColumns+` FROM comments WHERE post_id = ? ORDER BY created_at, id`), postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, *comment)
+	}
+	return comments, rows.Err()
+}
+
+func (r *CommentRepository) FindByID(ctx context.Context, id string) (*models.Comment, error) {
+	comment, err := scanComment(r.db.QueryRowContext(ctx, r.db.rebind(
+		`SELECT `+commentColumns+` FROM comments WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	return comment, err
+}
+
+func (r *CommentRepository) Create(ctx context.Context, comment *models.Comment) error {
+	// Top level comments have a NULL parent so the foreign key is not checked
+	var parentID sql.NullString
+	if comment.ParentID != "" {
+		parentID = sql.NullString{String: comment.ParentID, Valid: true}
+	}
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO comments (`+commentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		comment.ID, comment.PostID, parentID, comment.AuthorID, comment.Author, comment.Body,
+		comment.CreatedAt.UTC(), comment.UpdatedAt.UTC(),
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+func (r *CommentRepository) Update(ctx context.Context, comment *models.Comment) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE comments SET body = ?, updated_at = ? WHERE id = ?`),
+		comment.Body, comment.UpdatedAt.UTC(), comment.ID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// Delete relies on the foreign key to delete the replies
+func (r *CommentRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, r.db.rebind(`DELETE FROM comments WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (r *CommentRepository) DeleteByPost(ctx context.Context, postID string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(`DELETE FROM comments WHERE post_id = ?`), postID)
+	return err
+}
+
+func (r *CommentRepository) AnonymizeAuthor(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`UPDATE comments SET author_id = '', author = '' WHERE author = ?`), username)
+	return err
+}
+
+// LikeRepository is the SQL implementation of repository.LikeRepository
+type LikeRepository struct {
+	db *DB
+}
+
+func NewLikeRepository(db *DB) *LikeRepository {
+	return &LikeRepository{db: db}
+}
+
+func (r *LikeRepository) Like(ctx context.Context, postID, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`INSERT INTO post_likes (post_id, username, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (post_id, username) DO NOTHING`),
+		postID, username, time.Now().UTC())
+	return err
+}
+
+func (r *LikeRepository) Unlike(ctx context.Context, postID, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(
+		`DELETE FROM post_likes WHERE post_id = ? AND username = ?`), postID, username)
+	return err
+}
+
+func (r *LikeRepository) Count(ctx context.Context, postIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(postIDs) == 0 {
+		return counts, nil
+	}
+	args := make([]interface{}, len(postIDs))
+	for i, id := range postIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(postIDs)), ", ")
+	rows, err := r.db.QueryContext(ctx, r.db.rebind(
+		`SELECT post_id, COUNT(*) FROM post_likes WHERE post_id IN (`+placeholders+`) GROUP BY post_id`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID string
+		var n int64
+		if err := rows.Scan(&postID, &n); err != nil {
+			return nil, err
+		}
+		counts[postID] = n
+	}
+	return counts, rows.Err()
+}
+
+func (r *LikeRepository) DeleteByPost(ctx context.Context, postID string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(`DELETE FROM post_likes WHERE post_id = ?`), postID)
+	return err
+}
+
+func (r *LikeRepository) DeleteByUser(ctx context.Context, username string) error {
+	_, err := r.db.ExecContext(ctx, r.db.rebind(`DELETE FROM post_likes WHERE username = ?`), username)
+	return err
+}
@@ -0,0 +1,813 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+)
+
+func openTestDB(t *testing.T) *DB {
+	db, err := Open(DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("second migration run failed: %v", err)
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if want := migrations[len(migrations)-1].version; version != want {
+		t.Errorf("schema version = %d, want %d", version, want)
+	}
+}
+
+func TestMigrationBackfillsAuthorIDs(t *testing.T) {
+	// Build the schema as it was before users had ids
+	db := openMigratedTestDB(t, 4,
+		`INSERT INTO users (username, email, password) VALUES ('alice', 'alice@example.com', 'hash')`,
+		`INSERT INTO posts (id, creater, title, created_at) VALUES ('1', 'alice', 'old post', CURRENT_TIMESTAMP)`,
+	)
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	ctx := context.Background()
+	user, err := NewUserRepository(db).FindByUsername(ctx, "alice")
+	if err != nil || user.ID == "" || user.CreatedAt.IsZero() {
+		t.Fatalf("FindByUsername after migration = %+v, %v", user, err)
+	}
+	post, err := NewPostRepository(db).FindByID(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Author != "alice" || post.AuthorID != user.ID || !post.UpdatedAt.Equal(post.CreatedAt) {
+		t.Errorf("post after migration = %+v, want author_id %q", post, user.ID)
+	}
+}
+
+// openMigratedTestDB returns a database with the first n migrations
+// applied, and the statements run on it
+func openMigratedTestDB(t *testing.T, n int, stmts ...string) *DB {
+	conn, err := sql.Open(DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+	db := &DB{DB: conn, driver: DriverSQLite}
+
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, description TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:n] {
+		if err := db.apply(m); err != nil {
+			t.Fatalf("migration %d: %v", m.version, err)
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestMigratedPostsPage(t *testing.T) {
+	oldPosts := []string{`INSERT INTO users (id, username, email, password) VALUES ('u1', 'alice', 'alice@example.com', 'hash')`}
+	backfilled := []string{`INSERT INTO users (username, email, password) VALUES ('alice', 'alice@example.com', 'hash')`}
+	for i := 1; i <= 5; i++ {
+		// The text CURRENT_TIMESTAMP used to store
+		oldPosts = append(oldPosts, fmt.Sprintf(`INSERT INTO posts (id, author_id, author, title, created_at, updated_at) VALUES ('%d', 'u1', 'alice', 'post', '2024-01-01 10:00:0%d', '2024-01-01 10:00:0%d')`, i, i, i))
+		backfilled = append(backfilled, fmt.Sprintf(`INSERT INTO posts (id, creater, title) VALUES ('%d', 'alice', 'post')`, i))
+	}
+
+	tests := []struct {
+		name   string
+		db     *DB
+		newest string
+		oldest string
+	}{
+		// Rows of databases that already ran the old backfill of migration 4
+		{"Stored by CURRENT_TIMESTAMP", openMigratedTestDB(t, 12, oldPosts...), "54321", "12345"},
+		// Rows backfilled now all have the same time and are ordered by id
+		{"Backfilled", openMigratedTestDB(t, 3, backfilled...), "54321", "12345"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.db.Migrate(); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			posts := NewPostRepository(tt.db)
+			ids := func(q repository.PostQuery) string {
+				var result string
+				// Bounded, a broken cursor used to return the same page forever
+				for i := 0; i < 10; i++ {
+					page, err := posts.Find(context.Background(), q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(page) == 0 {
+						return result
+					}
+					for _, p := range page {
+						result += p.ID
+					}
+					last := page[len(page)-1]
+					q.After = &repository.PostCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+				}
+				return result + "..."
+			}
+
+			if got := ids(repository.PostQuery{Limit: 2}); got != tt.newest {
+				t.Errorf("newest first: got %q, want %q", got, tt.newest)
+			}
+			if got := ids(repository.PostQuery{Order: repository.OldestFirst, Limit: 2}); got != tt.oldest {
+				t.Errorf("oldest first: got %q, want %q", got, tt.oldest)
+			}
+		})
+	}
+}
+
+func TestMigrationUnescapesText(t *testing.T) {
+	// Build the schema as it was while text was stored HTML escaped
+	db := openMigratedTestDB(t, 7,
+		`INSERT INTO users (id, username, email, password, created_at, updated_at) VALUES ('u1', 'alice', 'alice@example.com', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO users (id, username, email, password, created_at, updated_at) VALUES ('u2', 'o&#39;brien', 'o&#39;brien@example.com', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO users (id, username, email, password, created_at, updated_at) VALUES ('u3', 'bob', 'b&amp;b@example.com', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO posts (id, author_id, author, title, created_at, updated_at) VALUES ('3', 'u2', 'o&#39;brien', 'mine', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO comments (id, post_id, author_id, author, body, created_at, updated_at) VALUES ('c2', '1', 'u2', 'o&#39;brien', 'hi', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO post_likes (post_id, username, created_at) VALUES ('1', 'o&#39;brien', CURRENT_TIMESTAMP)`,
+		`INSERT INTO refresh_tokens (id, family, username, token_hash, expires_at, created_at) VALUES ('r1', 'f1', 'o&#39;brien', 'h1', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO posts (id, author_id, author, title, body, created_at, updated_at) VALUES ('1', 'u1', 'alice', 'Tom &amp; Jerry &lt;3 &amp;lt;', '&lt;b&gt;bold&lt;/b&gt; &amp; more', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO posts (id, author_id, author, title, created_at, updated_at) VALUES ('2', 'u1', 'alice', 'plain', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		`INSERT INTO comments (id, post_id, author_id, author, body, created_at, updated_at) VALUES ('c1', '1', 'u1', 'alice', 'it&#39;s &#34;fine&#34;', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+	)
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	ctx := context.Background()
+	for id, want := range map[string]string{"1": "Tom & Jerry <3 &lt;", "2": "plain"} {
+		post, err := NewPostRepository(db).FindByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Title != want {
+			t.Errorf("post %s title = %q, want %q", id, post.Title, want)
+		}
+		if id == "1" && post.Body != "<b>bold</b> & more" {
+			t.Errorf("post %s body = %q", id, post.Body)
+		}
+	}
+	comment, err := NewCommentRepository(db).FindByID(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment.Body != `it's "fine"` {
+		t.Errorf("comment body = %q", comment.Body)
+	}
+	user, err := NewUserRepository(db).FindByUsername(ctx, "alice")
+	if err != nil || user.PasswordScheme != models.PasswordEscaped {
+		t.Errorf("FindByUsername after migration = %+v, %v", user, err)
+	}
+
+	// Escaped usernames and emails are found by what users type
+	renamed, err := NewUserRepository(db).FindByEmail(ctx, "o'brien@example.com")
+	if err != nil || renamed.Username != "o'brien" {
+		t.Fatalf("FindByEmail after migration = %+v, %v", renamed, err)
+	}
+	if bob, err := NewUserRepository(db).FindByUsername(ctx, "bob"); err != nil || bob.Email != "b&b@example.com" {
+		t.Errorf("FindByUsername after migration = %+v, %v", bob, err)
+	}
+	if posts, err := NewPostRepository(db).FindByAuthor(ctx, "o'brien"); err != nil || len(posts) != 1 {
+		t.Errorf("posts of the renamed user = %v, %v", posts, err)
+	}
+	if c, err := NewCommentRepository(db).FindByID(ctx, "c2"); err != nil || c.Author != "o'brien" {
+		t.Errorf("comment of the renamed user = %+v, %v", c, err)
+	}
+	var likes int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM post_likes WHERE username = 'o''brien'`).Scan(&likes); err != nil || likes != 1 {
+		t.Errorf("likes of the renamed user = %d, %v", likes, err)
+	}
+}
+
+func TestUserRepository(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository(openTestDB(t))
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	user := &models.User{ID: "u1", Username: "alice", Email: "alice@example.com", Password: "hash", CreatedAt: now, UpdatedAt: now}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		user models.User
+	}{
+		{"duplicate username", models.User{ID: "u2", Username: "alice", Email: "other@example.com", Password: "hash"}},
+		{"duplicate email", models.User{ID: "u3", Username: "bob", Email: "alice@example.com", Password: "hash"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := users.Create(ctx, &tt.user); err != repository.ErrConflict {
+				t.Errorf("got %v, want ErrConflict", err)
+			}
+		})
+	}
+
+	found, err := users.FindByUsername(ctx, "alice")
+	if err != nil || found.ID != user.ID || found.Email != user.Email || found.Password != user.Password || !found.CreatedAt.Equal(now) {
+		t.Errorf("FindByUsername = %+v, %v", found, err)
+	}
+	if _, err := users.FindByUsername(ctx, "nobody"); err != repository.ErrNotFound {
+		t.Errorf("FindByUsername missing user: got %v, want ErrNotFound", err)
+	}
+
+	exists, err := users.ExistsByUsernameOrEmail(ctx, "carol", "alice@example.com")
+	if err != nil || !exists {
+		t.Errorf("ExistsByUsernameOrEmail = %v, %v, want true", exists, err)
+	}
+}
+
+func TestPostRepository(t *testing.T) {
+	ctx := context.Background()
+	posts := NewPostRepository(openTestDB(t))
+
+	for _, p := range []models.Post{
+		{ID: "1", Author: "alice", Title: "first"},
+		{ID: "2", Author: "bob", Title: "second"},
+	} {
+		p := p
+		if err := posts.Create(ctx, &p); err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+	if err := posts.Create(ctx, &models.Post{ID: "1", Author: "bob", Title: "again"}); err != repository.ErrConflict {
+		t.Errorf("duplicate id: got %v, want ErrConflict", err)
+	}
+
+	all, err := posts.Find(ctx, repository.PostQuery{})
+	if err != nil || len(all) != 2 {
+		t.Errorf("Find returned %d posts, %v", len(all), err)
+	}
+	mine, err := posts.FindByAuthor(ctx, "alice")
+	if err != nil || len(mine) != 1 || mine[0].ID != "1" {
+		t.Errorf("FindByAuthor = %+v, %v", mine, err)
+	}
+
+	edited := &models.Post{ID: "1", AuthorID: "u1", Author: "alice", Title: "edited", Body: "text", Tags: []string{"go", "sql"}, Status: models.PostDraft}
+	if err := posts.Update(ctx, edited); err != nil {
+		t.Fatalf("update post: %v", err)
+	}
+	post, err := posts.FindByID(ctx, "1")
+	if err != nil || post.Title != "edited" || post.Body != "text" || post.AuthorID != "u1" ||
+		len(post.Tags) != 2 || post.Tags[1] != "sql" || post.Status != models.PostDraft {
+		t.Errorf("FindByID after update = %+v, %v", post, err)
+	}
+	if err := posts.Update(ctx, &models.Post{ID: "9", Title: "missing"}); err != repository.ErrNotFound {
+		t.Errorf("update missing post: got %v, want ErrNotFound", err)
+	}
+
+	if err := posts.Delete(ctx, "1"); err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	if _, err := posts.FindByID(ctx, "1"); err != repository.ErrNotFound {
+		t.Errorf("FindByID after delete: got %v, want ErrNotFound", err)
+	}
+	if err := posts.Delete(ctx, "1"); err != repository.ErrNotFound {
+		t.Errorf("delete missing post: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	db := &DB{driver: DriverPostgres}
+	got := db.rebind(`SELECT 1 FROM users WHERE username = ? OR email = ?`)
+	if want := `SELECT 1 FROM users WHERE username = $1 OR email = $2`; got != want {
+		t.Errorf("rebind = %q, want %q", got, want)
+	}
+}
+
+func TestRefreshTokenRepository(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if err := NewUserRepository(db).Create(ctx, &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewRefreshTokenRepository(db)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, tok := range []models.RefreshToken{
+		{ID: "1", Family: "f1", Username: "alice", TokenHash: "h1", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		{ID: "2", Family: "f1", Username: "alice", TokenHash: "h2", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		{ID: "3", Family: "f2", Username: "alice", TokenHash: "h3", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+	} {
+		tok := tok
+		if err := tokens.Create(ctx, &tok); err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+	}
+
+	found, err := tokens.FindByHash(ctx, "h1")
+	if err != nil || found.ID != "1" || found.Revoked || !found.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("FindByHash = %+v, %v", found, err)
+	}
+
+	if err := tokens.Rotate(ctx, "1"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := tokens.Rotate(ctx, "1"); err != repository.ErrNotFound {
+		t.Errorf("second rotate: got %v, want ErrNotFound", err)
+	}
+	if tok, _ := tokens.FindByHash(ctx, "h1"); !tok.Rotated || tok.Revoked {
+		t.Errorf("rotated token = %+v", tok)
+	}
+
+	if err := tokens.RevokeFamily(ctx, "f1"); err != nil {
+		t.Fatal(err)
+	}
+	if tok, _ := tokens.FindByHash(ctx, "h2"); !tok.Revoked {
+		t.Error("RevokeFamily left a token in the family active")
+	}
+	if err := tokens.Rotate(ctx, "2"); err != repository.ErrNotFound {
+		t.Errorf("rotate revoked token: got %v, want ErrNotFound", err)
+	}
+	if tok, _ := tokens.FindByHash(ctx, "h3"); tok.Revoked {
+		t.Error("RevokeFamily revoked a token from another family")
+	}
+
+	if err := tokens.RevokeAllForUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if tok, _ := tokens.FindByHash(ctx, "h3"); !tok.Revoked {
+		t.Error("RevokeAllForUser left a session active")
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository(openTestDB(t))
+
+	if err := users.Create(ctx, &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRoles(ctx, "alice", []string{models.RoleModerator, models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRoles(ctx, "nobody", []string{models.RoleAdmin}); err != repository.ErrNotFound {
+		t.Errorf("SetRoles on missing user: got %v, want ErrNotFound", err)
+	}
+
+	all, err := users.List(ctx)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("List = %+v, %v", all, err)
+	}
+	if roles := all[0].Roles; len(roles) != 2 || roles[0] != models.RoleModerator || roles[1] != models.RoleAdmin {
+		t.Errorf("roles = %v", roles)
+	}
+}
+
+func TestUserUpdatePassword(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository(openTestDB(t))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com", Password: "old"}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	user.Password, user.PasswordScheme = "new", models.PasswordNormalized
+	if err := users.UpdatePassword(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.UpdatePassword(ctx, &models.User{Username: "nobody"}); err != repository.ErrNotFound {
+		t.Errorf("UpdatePassword on missing user: got %v, want ErrNotFound", err)
+	}
+
+	stored, err := users.FindByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password != "new" || stored.PasswordScheme != models.PasswordNormalized {
+		t.Errorf("stored password = %q scheme %d", stored.Password, stored.PasswordScheme)
+	}
+}
+
+func TestUserEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository(openTestDB(t))
+
+	if err := users.Create(ctx, &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.FindByEmail(ctx, "nobody@example.com"); err != repository.ErrNotFound {
+		t.Errorf("FindByEmail on missing email: got %v, want ErrNotFound", err)
+	}
+	if err := users.SetEmailVerified(ctx, "alice", "old@example.com"); err != repository.ErrNotFound {
+		t.Errorf("SetEmailVerified with another email: got %v, want ErrNotFound", err)
+	}
+	if user, err := users.FindByEmail(ctx, "alice@example.com"); err != nil || user.Username != "alice" || user.EmailVerified {
+		t.Errorf("FindByEmail = %+v, %v", user, err)
+	}
+
+	if err := users.SetEmailVerified(ctx, "alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := users.FindByUsername(ctx, "alice"); !user.EmailVerified {
+		t.Error("email is not verified")
+	}
+}
+
+func TestUserUpdateProfileAndDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	users := NewUserRepository(db)
+
+	for _, u := range []models.User{
+		{ID: "1", Username: "alice", Email: "alice@example.com", EmailVerified: true, Password: "hash"},
+		{ID: "2", Username: "bob", Email: "bob@example.com", Password: "hash"},
+	} {
+		u := u
+		if err := users.Create(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	update := &models.User{Username: "alice", DisplayName: "Alice", Email: "new@example.com", UpdatedAt: now}
+	if err := users.UpdateProfile(ctx, update); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := users.FindByUsername(ctx, "alice")
+	if err != nil || stored.DisplayName != "Alice" || stored.Email != "new@example.com" || stored.EmailVerified || !stored.UpdatedAt.Equal(now) {
+		t.Errorf("FindByUsername after update = %+v, %v", stored, err)
+	}
+	update.Email = "bob@example.com"
+	if err := users.UpdateProfile(ctx, update); err != repository.ErrConflict {
+		t.Errorf("UpdateProfile with a taken email: got %v, want ErrConflict", err)
+	}
+	if err := users.UpdateProfile(ctx, &models.User{Username: "nobody", Email: "nobody@example.com"}); err != repository.ErrNotFound {
+		t.Errorf("UpdateProfile on missing user: got %v, want ErrNotFound", err)
+	}
+
+	// Sessions of the user go with it
+	tokens := NewRefreshTokenRepository(db)
+	if err := tokens.Create(ctx, &models.RefreshToken{ID: "1", Family: "f", Username: "alice", TokenHash: "h", ExpiresAt: now, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.FindByUsername(ctx, "alice"); err != repository.ErrNotFound {
+		t.Errorf("FindByUsername after delete: got %v, want ErrNotFound", err)
+	}
+	if _, err := tokens.FindByHash(ctx, "h"); err != repository.ErrNotFound {
+		t.Errorf("session after deleting the user: got %v, want ErrNotFound", err)
+	}
+	if err := users.Delete(ctx, "alice"); err != repository.ErrNotFound {
+		t.Errorf("delete missing user: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestUserTokenRepository(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if err := NewUserRepository(db).Create(ctx, &models.User{Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewUserTokenRepository(db)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, tok := range []models.UserToken{
+		{ID: "1", Username: "alice", Purpose: models.TokenVerifyEmail, Email: "alice@example.com", TokenHash: "h1", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		{ID: "2", Username: "alice", Purpose: models.TokenVerifyEmail, Email: "alice@example.com", TokenHash: "h2", ExpiresAt: now.Add(-time.Second), CreatedAt: now},
+		{ID: "3", Username: "alice", Purpose: models.TokenResetPassword, Email: "alice@example.com", TokenHash: "h3", ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+	} {
+		tok := tok
+		if err := tokens.Create(ctx, &tok); err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+	}
+
+	tok, err := tokens.Consume(ctx, "h1", models.TokenVerifyEmail, now)
+	if err != nil || tok.ID != "1" || !tok.Used || tok.Email != "alice@example.com" || !tok.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Consume = %+v, %v", tok, err)
+	}
+	if _, err := tokens.Consume(ctx, "h1", models.TokenVerifyEmail, now); err != repository.ErrNotFound {
+		t.Errorf("second consume: got %v, want ErrNotFound", err)
+	}
+	if _, err := tokens.Consume(ctx, "h2", models.TokenVerifyEmail, now); err != repository.ErrNotFound {
+		t.Errorf("consume expired token: got %v, want ErrNotFound", err)
+	}
+	if _, err := tokens.Consume(ctx, "h3", models.TokenVerifyEmail, now); err != repository.ErrNotFound {
+		t.Errorf("consume token of another purpose: got %v, want ErrNotFound", err)
+	}
+
+	if err := tokens.DeleteForUser(ctx, "alice", models.TokenResetPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Consume(ctx, "h3", models.TokenResetPassword, now); err != repository.ErrNotFound {
+		t.Errorf("consume deleted token: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestIdentityRepository(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	users := NewUserRepository(db)
+	for _, u := range []models.User{
+		{ID: "1", Username: "alice", Email: "alice@example.com", Password: "hash"},
+		{ID: "2", Username: "bob", Email: "bob@example.com", Password: "hash"},
+	} {
+		u := u
+		if err := users.Create(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	identities := NewIdentityRepository(db)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := identities.Create(ctx, &models.Identity{Provider: "google", Subject: "123", Username: "alice", Email: "alice@example.com", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	// Subjects are only unique per provider
+	if err := identities.Create(ctx, &models.Identity{Provider: "github", Subject: "123", Username: "bob", Email: "bob@example.com", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := identities.Create(ctx, &models.Identity{Provider: "google", Subject: "123", Username: "bob", CreatedAt: now}); err != repository.ErrConflict {
+		t.Errorf("link a linked subject: got %v, want ErrConflict", err)
+	}
+
+	identity, err := identities.Find(ctx, "google", "123")
+	if err != nil || identity.Username != "alice" || identity.Email != "alice@example.com" || !identity.CreatedAt.Equal(now) {
+		t.Errorf("Find = %+v, %v", identity, err)
+	}
+	if _, err := identities.Find(ctx, "google", "456"); err != repository.ErrNotFound {
+		t.Errorf("find unknown subject: got %v, want ErrNotFound", err)
+	}
+
+	if err := identities.DeleteForUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := identities.Find(ctx, "google", "123"); err != repository.ErrNotFound {
+		t.Errorf("find deleted identity: got %v, want ErrNotFound", err)
+	}
+
+	// Deleting the user unlinks it as well
+	if err := users.Delete(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := identities.Find(ctx, "github", "123"); err != repository.ErrNotFound {
+		t.Errorf("find identity of a deleted user: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestAPIKeyRepository(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	users := NewUserRepository(db)
+	if err := users.Create(ctx, &models.User{ID: "1", Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	keys := NewAPIKeyRepository(db)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, k := range []models.APIKey{
+		{ID: "1", Username: "alice", Name: "old", Prefix: "wgk_aaaaaa", KeyHash: "h1", Scopes: []string{"posts:read"}, CreatedAt: now.Add(-time.Hour)},
+		{ID: "2", Username: "alice", Name: "new", Prefix: "wgk_bbbbbb", KeyHash: "h2", Scopes: []string{"posts:read", "posts:write"}, CreatedAt: now},
+	} {
+		k := k
+		if err := keys.Create(ctx, &k); err != nil {
+			t.Fatalf("create key: %v", err)
+		}
+	}
+	if err := keys.Create(ctx, &models.APIKey{ID: "3", Username: "alice", KeyHash: "h1", CreatedAt: now}); err != repository.ErrConflict {
+		t.Errorf("duplicate hash: got %v, want ErrConflict", err)
+	}
+
+	key, err := keys.FindByHash(ctx, "h2")
+	if err != nil || key.ID != "2" || key.Name != "new" || len(key.Scopes) != 2 || key.Scopes[1] != "posts:write" ||
+		key.LastUsedAt != nil || !key.CreatedAt.Equal(now) {
+		t.Errorf("FindByHash = %+v, %v", key, err)
+	}
+	if _, err := keys.FindByHash(ctx, "unknown"); err != repository.ErrNotFound {
+		t.Errorf("find unknown key: got %v, want ErrNotFound", err)
+	}
+
+	if err := keys.Touch(ctx, "2", now); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := keys.FindByHash(ctx, "h2"); err != nil || key.LastUsedAt == nil || !key.LastUsedAt.Equal(now) {
+		t.Errorf("after Touch = %+v, %v", key, err)
+	}
+
+	list, err := keys.ListForUser(ctx, "alice")
+	if err != nil || len(list) != 2 || list[0].ID != "2" {
+		t.Errorf("ListForUser = %+v, %v, want newest first", list, err)
+	}
+
+	if err := keys.Delete(ctx, "bob", "1"); err != repository.ErrNotFound {
+		t.Errorf("delete key of another user: got %v, want ErrNotFound", err)
+	}
+	if err := keys.Delete(ctx, "alice", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.FindByHash(ctx, "h1"); err != repository.ErrNotFound {
+		t.Errorf("find deleted key: got %v, want ErrNotFound", err)
+	}
+
+	// Deleting the user revokes the rest
+	if err := users.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := keys.ListForUser(ctx, "alice"); err != nil || len(list) != 0 {
+		t.Errorf("keys of a deleted user = %+v, %v", list, err)
+	}
+}
+
+func TestPostRepositoryFindPages(t *testing.T) {
+	ctx := context.Background()
+	posts := NewPostRepository(openTestDB(t))
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, p := range []models.Post{
+		{ID: "a", Author: "alice", Title: "Hello world", Tags: []string{"go", "web"}, CreatedAt: base},
+		{ID: "b", Author: "bob", Title: "snake_case in GO", Status: models.PostDraft, CreatedAt: base.Add(time.Second)},
+		// Same time as b, ordered by id
+		{ID: "c", Author: "alice", Title: "Go tips", Tags: []string{"go"}, CreatedAt: base.Add(time.Second)},
+		{ID: "d", Author: "alice", Title: "hello again", Tags: []string{"golang"}, Status: models.PostDraft, CreatedAt: base.Add(2 * time.Second)},
+	} {
+		p := p
+		if err := posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(q repository.PostQuery) string {
+		var result string
+		for {
+			page, err := posts.Find(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				return result
+			}
+			for _, p := range page {
+				result += p.ID
+			}
+			last := page[len(page)-1]
+			q.After = &repository.PostCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query repository.PostQuery
+		want  string
+	}{
+		{"Newest first", repository.PostQuery{Limit: 3}, "dcba"},
+		{"Oldest first", repository.PostQuery{Order: repository.OldestFirst, Limit: 1}, "abcd"},
+		{"By author", repository.PostQuery{PostFilter: repository.PostFilter{Author: "alice"}, Limit: 2}, "dca"},
+		{"Title search ignores case", repository.PostQuery{PostFilter: repository.PostFilter{TitleContains: "HELLO"}, Limit: 10}, "da"},
+		{"Title search escapes LIKE", repository.PostQuery{PostFilter: repository.PostFilter{TitleContains: "_"}, Limit: 10}, "b"},
+		{"By tag", repository.PostQuery{PostFilter: repository.PostFilter{Tag: "go"}, Limit: 10}, "ca"},
+		{"Drafts of others are hidden", repository.PostQuery{PostFilter: repository.PostFilter{VisibleTo: "bob"}, Limit: 1}, "cba"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(tt.query); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if n, err := posts.Count(ctx, repository.PostFilter{Author: "alice"}); err != nil || n != 3 {
+		t.Errorf("Count = %d, %v, want 3", n, err)
+	}
+}
+
+func TestCommentRepository(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	posts := NewPostRepository(db)
+	comments := NewCommentRepository(db)
+
+	if err := posts.Create(ctx, &models.Post{ID: "p1", Author: "alice", Title: "post"}); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, c := range []models.Comment{
+		{ID: "c1", PostID: "p1", Author: "bob", Body: "first"},
+		{ID: "r1", PostID: "p1", ParentID: "c1", Author: "alice", Body: "reply"},
+		{ID: "c2", PostID: "p1", Author: "carol", Body: "second"},
+	} {
+		c := c
+		c.CreatedAt = base.Add(time.Duration(i) * time.Second)
+		c.UpdatedAt = c.CreatedAt
+		if err := comments.Create(ctx, &c); err != nil {
+			t.Fatalf("create comment %s: %v", c.ID, err)
+		}
+	}
+	if err := comments.Create(ctx, &models.Comment{ID: "c3", PostID: "missing", Author: "bob", Body: "x"}); err == nil {
+		t.Error("comment on a missing post was accepted")
+	}
+
+	list, err := comments.FindByPost(ctx, "p1")
+	if err != nil || len(list) != 3 || list[0].ID != "c1" || list[1].ParentID != "c1" || list[0].ParentID != "" {
+		t.Errorf("FindByPost = %+v, %v", list, err)
+	}
+
+	if err := comments.Update(ctx, &models.Comment{ID: "c1", Body: "edited", UpdatedAt: base.Add(time.Hour)}); err != nil {
+		t.Fatalf("update comment: %v", err)
+	}
+	if c, err := comments.FindByID(ctx, "c1"); err != nil || c.Body != "edited" || c.Author != "bob" {
+		t.Errorf("FindByID after update = %+v, %v", c, err)
+	}
+
+	// Deleting a comment deletes its replies
+	if err := comments.Delete(ctx, "c1"); err != nil {
+		t.Fatalf("delete comment: %v", err)
+	}
+	if _, err := comments.FindByID(ctx, "r1"); err != repository.ErrNotFound {
+		t.Errorf("reply after deleting its parent: got %v, want ErrNotFound", err)
+	}
+	if err := comments.Delete(ctx, "c1"); err != repository.ErrNotFound {
+		t.Errorf("delete missing comment: got %v, want ErrNotFound", err)
+	}
+
+	if err := comments.AnonymizeAuthor(ctx, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := comments.FindByID(ctx, "c2"); err != nil || c.Author != "" || c.AuthorID != "" || c.Body != "second" {
+		t.Errorf("FindByID after anonymizing = %+v, %v", c, err)
+	}
+
+	// Deleting the post deletes the remaining comments
+	if err := posts.Delete(ctx, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := comments.FindByPost(ctx, "p1"); len(list) != 0 {
+		t.Errorf("%d comments left after deleting the post", len(list))
+	}
+}
+
+func TestLikeRepository(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	posts := NewPostRepository(db)
+	likes := NewLikeRepository(db)
+
+	for _, id := range []string{"p1", "p2", "p3"} {
+		if err := posts.Create(ctx, &models.Post{ID: id, Author: "alice", Title: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, like := range [][2]string{{"p1", "bob"}, {"p1", "bob"}, {"p1", "carol"}, {"p2", "bob"}} {
+		if err := likes.Like(ctx, like[0], like[1]); err != nil {
+			t.Fatalf("like %v: %v", like, err)
+		}
+	}
+	if err := likes.Unlike(ctx, "p2", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := likes.Unlike(ctx, "p2", "bob"); err != nil {
+		t.Errorf("unlike twice: %v", err)
+	}
+
+	counts, err := likes.Count(ctx, []string{"p1", "p2", "p3"})
+	if err != nil || len(counts) != 1 || counts["p1"] != 2 {
+		t.Errorf("Count = %v, %v, want p1: 2", counts, err)
+	}
+
+	if err := likes.DeleteByUser(ctx, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := likes.Count(ctx, []string{"p1"}); counts["p1"] != 1 {
+		t.Errorf("Count after deleting the likes of a user = %v, want p1: 1", counts)
+	}
+
+	if err := posts.Delete(ctx, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := likes.Count(ctx, []string{"p1"}); counts["p1"] != 0 {
+		t.Errorf("%d likes left after deleting the post", counts["p1"])
+	}
+}
@@ -1,12 +1,141 @@
+// Package errors defines the errors the API returns. Handlers return a
+// *ServiceError, or a domain error that From maps to one, and the utils
+// package writes it as an RFC 7807 problem.
 package errors
 
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"github.com/conglt10/web-golang/repository"
+)
+
+// Code identifies the kind of error. Clients should branch on the code, the
+// message is meant for people and may change.
+type Code string
+
+const (
+	CodeBadRequest         Code = "bad_request"
+	CodeValidation         Code = "validation_failed"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeForbidden          Code = "forbidden"
+	CodeNotFound           Code = "not_found"
+	CodeMethodNotAllowed   Code = "method_not_allowed"
+	CodeConflict           Code = "conflict"
+	CodePayloadTooLarge    Code = "payload_too_large"
+	CodeTooManyRequests    Code = "too_many_requests"
+	CodeInternal           Code = "internal_error"
+	CodeUnavailable        Code = "service_unavailable"
+)
+
+// statuses is the HTTP status of every code
+var statuses = map[Code]int{
+	CodeBadRequest:         http.StatusBadRequest,
+	CodeValidation:         http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeInvalidToken:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	CodeConflict:           http.StatusConflict,
+	CodePayloadTooLarge:    http.StatusRequestEntityTooLarge,
+	CodeTooManyRequests:    http.StatusTooManyRequests,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+}
+
+// ServiceError is an error that can be shown to API clients
 type ServiceError struct {
+	Code    Code   `json:"code"`
 	Message string `json:"message"`
+	// Details is extra machine readable information, such as the fields
+	// that failed validation
+	Details interface{} `json:"details,omitempty"`
+	// Err is the cause. It is logged but never sent to clients.
+	Err error `json:"-"`
 }
 
-var (
-	ErrEmptyUsername = "Username cannot be empty"
-	ErrEmptyPassword = "Password cannot be empty"
-	ErrEmptyEmail    = "Email cannot be empty"
-	ErrInvalidEmail  = "Invalid email format"
-)
+func New(code Code, message string) *ServiceError {
+	return &ServiceError{Code: code, Message: message}
+}
+
+func BadRequest(message string) *ServiceError {
+	return New(CodeBadRequest, message)
+}
+
+func Validation(message string, details interface{}) *ServiceError {
+	return &ServiceError{Code: CodeValidation, Message: message, Details: details}
+}
+
+func Unauthorized(message string) *ServiceError {
+	return New(CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *ServiceError {
+	return New(CodeForbidden, message)
+}
+
+func NotFound(message string) *ServiceError {
+	return New(CodeNotFound, message)
+}
+
+func Conflict(message string) *ServiceError {
+	return New(CodeConflict, message)
+}
+
+// Internal hides err behind a generic message
+func Internal(err error) *ServiceError {
+	return &ServiceError{Code: CodeInternal, Message: "Internal Server Error", Err: err}
+}
+
+func (e *ServiceError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status of the error
+func (e *ServiceError) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// WithDetails returns a copy of the error with the details set
+func (e *ServiceError) WithDetails(details interface{}) *ServiceError {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// From maps err to a ServiceError. Domain errors get their matching code,
+// anything unknown becomes an internal error.
+func From(err error) *ServiceError {
+	var se *ServiceError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case stderrors.As(err, &se):
+		return se
+	case stderrors.As(err, &tooLarge):
+		return &ServiceError{Code: CodePayloadTooLarge, Message: fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit), Err: err}
+	case stderrors.Is(err, repository.ErrNotFound):
+		return &ServiceError{Code: CodeNotFound, Message: "Resource not found", Err: err}
+	case stderrors.Is(err, repository.ErrConflict):
+		return &ServiceError{Code: CodeConflict, Message: "Resource already exists", Err: err}
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return &ServiceError{Code: CodeUnavailable, Message: "The request timed out", Err: err}
+	default:
+		return Internal(err)
+	}
+}
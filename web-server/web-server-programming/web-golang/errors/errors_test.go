@@ -0,0 +1,67 @@
+package errors
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/conglt10/web-golang/repository"
+)
+
+func TestFrom(t *testing.T) {
+	custom := Validation("Invalid input", map[string]string{"title": "required"})
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedCode   Code
+		expectedStatus int
+	}{
+		{name: "Service error", err: custom, expectedCode: CodeValidation, expectedStatus: http.StatusBadRequest},
+		{name: "Wrapped service error", err: fmt.Errorf("create post: %w", NotFound("Post not found")), expectedCode: CodeNotFound, expectedStatus: http.StatusNotFound},
+		{name: "Repository not found", err: fmt.Errorf("find: %w", repository.ErrNotFound), expectedCode: CodeNotFound, expectedStatus: http.StatusNotFound},
+		{name: "Repository conflict", err: repository.ErrConflict, expectedCode: CodeConflict, expectedStatus: http.StatusConflict},
+		{name: "Body too large", err: fmt.Errorf("decode: %w", &http.MaxBytesError{Limit: 1024}), expectedCode: CodePayloadTooLarge, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "Timeout", err: context.DeadlineExceeded, expectedCode: CodeUnavailable, expectedStatus: http.StatusServiceUnavailable},
+		{name: "Unknown error", err: stderrors.New("connection refused"), expectedCode: CodeInternal, expectedStatus: http.StatusInternalServerError},
+		{name: "Unknown code", err: New("teapot", "I'm a teapot"), expectedCode: "teapot", expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			se := From(tt.err)
+			if se.Code != tt.expectedCode {
+				t.Errorf("wrong code: got %q want %q", se.Code, tt.expectedCode)
+			}
+			if se.Status() != tt.expectedStatus {
+				t.Errorf("wrong status: got %d want %d", se.Status(), tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestInternalHidesCause(t *testing.T) {
+	cause := stderrors.New("pq: password authentication failed")
+	se := From(cause)
+
+	if se.Message != "Internal Server Error" {
+		t.Errorf("cause leaked into message: %q", se.Message)
+	}
+	if !stderrors.Is(se, cause) {
+		t.Error("cause should stay reachable through Unwrap")
+	}
+}
+
+func TestWithDetailsCopies(t *testing.T) {
+	base := BadRequest("Invalid request body")
+	detailed := base.WithDetails("unexpected EOF")
+
+	if base.Details != nil {
+		t.Errorf("WithDetails changed the original: %v", base.Details)
+	}
+	if detailed.Details != "unexpected EOF" {
+		t.Errorf("wrong details: %v", detailed.Details)
+	}
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuild(t *testing.T) {
+	date := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	data, err := build("noreply@example.com", Message{
+		To:      "alice@example.com",
+		Subject: "Xác nhận email",
+		Body:    "line one\nline two\n",
+	}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "From: noreply@example.com\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: =?utf-8?q?X=C3=A1c_nh=E1=BA=ADn_email?=\r\n" +
+		"Date: Fri, 01 Mar 2024 12:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"line one\r\nline two\r\n"
+	if string(data) != want {
+		t.Errorf("build =\n%q\nwant\n%q", data, want)
+	}
+}
+
+func TestBuildRejectsHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"Recipient", Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"}},
+		{"Subject", Message{To: "alice@example.com", Subject: "Hi\nBcc: eve@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := build("noreply@example.com", tt.msg, time.Now()); err != ErrInvalidHeader {
+				t.Errorf("build: got %v, want ErrInvalidHeader", err)
+			}
+		})
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if err := m.Send(context.Background(), Message{To: to, Subject: "Hi", Body: "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := strings.Split(strings.TrimSuffix(buf.String(), "\r\n.\r\n"), "\r\n.\r\n")
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2:\n%s", len(messages), buf.String())
+	}
+	if !strings.Contains(messages[1], "To: bob@example.com\r\n") || !strings.HasSuffix(messages[1], "\r\n\r\nHello") {
+		t.Errorf("second message = %q", messages[1])
+	}
+}
@@ -1,36 +1,42 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
-	db "github.com/conglt10/web-golang/database"
-	"github.com/conglt10/web-golang/middlewares"
-	"github.com/conglt10/web-golang/routes"
+	"github.com/conglt10/web-golang/app"
 	"github.com/joho/godotenv"
-	"github.com/julienschmidt/httprouter"
 )
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error getting env, %v", err)
+	// The .env file is optional, the environment may be set up already
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Warning: Failed to read .env: %v", err)
 	}
 
-	// Initialize database
-	db.InitDatabase()
-	router := httprouter.New()
+	cfg, err := app.LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatalf("Error loading config:\n%v", err)
+	}
 
-	router.POST("/auth/login", routes.Login)
-	router.POST("/auth/register", routes.Register)
+	a, err := app.New(cfg)
+	if err != nil {
+		log.Fatalf("Error starting, %v", err)
+	}
+	defer a.Close()
 
-	router.GET("/posts", middlewares.CheckJwt(routes.GetAllPosts))
-	router.GET("/me/posts", middlewares.CheckJwt(routes.GetMyPosts))
-	router.POST("/posts", middlewares.CheckJwt(routes.CreatePost))
-	router.PUT("/posts/:id", middlewares.CheckJwt(routes.EditPost))
-	router.DELETE("/posts/:id", middlewares.CheckJwt(routes.DeletePost))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Println("Listening to port 8000")
-	log.Fatal(http.ListenAndServe(":8000", router))
+	if err := a.Run(ctx); err != nil {
+		log.Printf("Error serving, %v", err)
+	}
 }
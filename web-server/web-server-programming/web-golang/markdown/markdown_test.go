@@ -0,0 +1,35 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		notWant string
+	}{
+		{"emphasis", "**bold**", "<strong>bold</strong>", ""},
+		{"tables", "| a |\n|---|\n| b |", "<table>", ""},
+		{"raw html is dropped", "<script>alert(1)</script>", "", "<script>"},
+		{"javascript links are removed", "[x](javascript:alert(1))", "", "javascript:"},
+		{"inline html is dropped", "hi <img src=x onerror=alert(1)>", "hi", "<img"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := ToHTML(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(html, tt.want) {
+				t.Errorf("ToHTML(%q) = %q, want it to contain %q", tt.source, html, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(html, tt.notWant) {
+				t.Errorf("ToHTML(%q) = %q, must not contain %q", tt.source, html, tt.notWant)
+			}
+		})
+	}
+}
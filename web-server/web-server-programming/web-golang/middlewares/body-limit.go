@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"net/http"
+
+	res "github.com/conglt10/web-golang/utils"
+)
+
+// MaxBodySize limits request bodies to n bytes. Bodies announced as larger
+// are refused with 413 right away, reading past the limit of others fails
+// with an *http.MaxBytesError, see apierr.From. Applied per route it only
+// tightens the global limit.
+func MaxBodySize(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				res.Error(w, r, &http.MaxBytesError{Limit: n})
+				return
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Middleware wraps a handler with behaviour shared by many routes
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middlewares into one. The first one is the outermost, it
+// sees the request first and the response last.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Route applies middlewares to a single httprouter route, inside the ones
+// applied to the whole router. The route parameters are passed through the
+// request context.
+func Route(next httprouter.Handle, middlewares ...Middleware) httprouter.Handle {
+	h := Chain(middlewares...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r, httprouter.ParamsFromContext(r.Context()))
+	}))
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, ps)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
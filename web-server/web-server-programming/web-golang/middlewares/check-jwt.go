@@ -3,21 +3,53 @@ package middlewares
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
 	res "github.com/conglt10/web-golang/utils"
 	"github.com/julienschmidt/httprouter"
 )
 
-func CheckJwt(next httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		err := jwt.Verify(r)
+// Verifier returns the caller of a request. *jwt.Manager only accepts access
+// tokens, *jwt.Authenticator API keys as well.
+type Verifier interface {
+	Authenticate(r *http.Request) (*jwt.Principal, error)
+}
 
-		if err != nil {
-			res.ERROR(w, 401, errors.New("Unauthorized"))
+// CheckJwt rejects requests without a valid access token and stores the
+// caller in the request context, see jwt.PrincipalFromContext. API keys are
+// only accepted when they have every one of the scopes, a route without
+// scopes is for access tokens only.
+func CheckJwt(auth Verifier, next httprouter.Handle, scopes ...string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		principal, err := auth.Authenticate(r)
+		if errors.Is(err, jwt.ErrUnauthenticated) {
+			res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Missing or invalid access token"))
 			return
+		} else if err != nil {
+			res.Error(w, r, err)
+			return
+		}
+
+		if principal.APIKeyID != "" {
+			if len(scopes) == 0 {
+				res.Error(w, r, apierr.Forbidden("API keys cannot be used here, log in instead"))
+				return
+			}
+			var missing []string
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					missing = append(missing, scope)
+				}
+			}
+			if len(missing) > 0 {
+				res.Error(w, r, apierr.Forbidden("API key is missing the scope "+strings.Join(missing, ", ")))
+				return
+			}
 		}
 
-		next(w, r, ps)
+		ctx := jwt.NewContext(r.Context(), principal)
+		next(w, r.WithContext(ctx), ps)
 	}
 }
@@ -0,0 +1,245 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(mark("outer"), mark("inner"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if strings.Join(order, ",") != "outer,inner,handler" {
+		t.Errorf("ran in order %v", order)
+	}
+}
+
+func TestRouteKeepsParams(t *testing.T) {
+	router := httprouter.New()
+	router.GET("/posts/:id", Route(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Write([]byte(ps.ByName("id")))
+	}, SecurityHeaders))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/posts/42", nil))
+	if rr.Body.String() != "42" || rr.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("got %q with headers %v", rr.Body, rr.Header())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		sent     string
+		expected string
+	}{
+		{"Generated", "", ""},
+		{"Kept", "proxy-1234.abc_d", "proxy-1234.abc_d"},
+		{"Replaced when unsafe", "id\nwith newline", ""},
+		{"Replaced when too long", strings.Repeat("a", 65), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromContext string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(res.RequestIDHeader, tt.sent)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			id := rr.Header().Get(res.RequestIDHeader)
+			if id == "" || id != fromContext {
+				t.Fatalf("header %q, context %q", id, fromContext)
+			}
+			if tt.expected != "" && id != tt.expected {
+				t.Errorf("got %q want %q", id, tt.expected)
+			}
+			if tt.expected == "" && id == tt.sent {
+				t.Errorf("kept %q", tt.sent)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var logged bytes.Buffer
+	h := Chain(RequestID, AccessLog(slog.New(slog.NewJSONHandler(&logged, nil))), Recover)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/posts", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+	var problem res.Problem
+	json.NewDecoder(rr.Body).Decode(&problem)
+	if problem.Code != "internal_error" || strings.Contains(problem.Detail, "boom") ||
+		problem.RequestID != rr.Header().Get(res.RequestIDHeader) {
+		t.Errorf("problem %+v", problem)
+	}
+
+	// The access log has the status the client got
+	var line map[string]interface{}
+	if err := json.Unmarshal(logged.Bytes(), &line); err != nil {
+		t.Fatalf("access log %q: %v", logged.String(), err)
+	}
+	if line["status"] != float64(500) || line["request_id"] != problem.RequestID || line["path"] != "/posts" {
+		t.Errorf("access log %v", line)
+	}
+}
+
+func TestRecoverAfterWrite(t *testing.T) {
+	h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+	rr := httptest.NewRecorder()
+
+	defer func() {
+		// The connection is dropped, nothing is appended to the body
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("panicked with %v, want %v", v, http.ErrAbortHandler)
+		}
+		if rr.Code != http.StatusOK || rr.Body.String() != "partial" || rr.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("got %v %q with headers %v", rr.Code, rr.Body, rr.Header())
+		}
+	}()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/posts", nil))
+}
+
+func TestCORS(t *testing.T) {
+	h := CORS(CORSConfig{AllowedOrigins: []string{"https://app.example.com"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+
+	tests := []struct {
+		name           string
+		method         string
+		origin         string
+		preflight      bool
+		expectedStatus int
+		expectedOrigin string
+	}{
+		{"Same origin", "GET", "", false, http.StatusTeapot, ""},
+		{"Allowed origin", "GET", "https://app.example.com", false, http.StatusTeapot, "https://app.example.com"},
+		{"Other origin", "GET", "https://evil.example.com", false, http.StatusTeapot, ""},
+		{"Preflight", "OPTIONS", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"Preflight from other origin", "OPTIONS", "https://evil.example.com", true, http.StatusTeapot, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/posts", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("got %v want %v", rr.Code, tt.expectedStatus)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expectedOrigin {
+				t.Errorf("allowed origin %q want %q", got, tt.expectedOrigin)
+			}
+			if tt.preflight && tt.expectedOrigin != "" && !strings.Contains(rr.Header().Get("Access-Control-Allow-Headers"), "X-API-Key") {
+				t.Errorf("allowed headers %q", rr.Header().Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest("GET", "/posts", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+
+	rr := httptest.NewRecorder()
+	CORS(CORSConfig{AllowedOrigins: []string{"*"}})(ok).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("allowed origin %q", got)
+	}
+
+	// Credentials are never allowed for any origin
+	rr = httptest.NewRecorder()
+	CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})(ok).ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("allowed origin %q", got)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Header().Get("X-Content-Type-Options") != "nosniff" || rr.Header().Get("Content-Security-Policy") == "" {
+		t.Errorf("headers %v", rr.Header())
+	}
+	if rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS sent over plain HTTP")
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	h := MaxBodySize(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			res.Error(w, r, err)
+		}
+	}))
+
+	tests := []struct {
+		name           string
+		body           string
+		chunked        bool
+		expectedStatus int
+	}{
+		{"Within the limit", "0123456789", false, http.StatusOK},
+		{"Announced too large", "0123456789a", false, http.StatusRequestEntityTooLarge},
+		{"Read too large", "0123456789a", true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body)
+			}
+		})
+	}
+}
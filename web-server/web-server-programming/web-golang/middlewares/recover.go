@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	apierr "github.com/conglt10/web-golang/errors"
+	res "github.com/conglt10/web-golang/utils"
+)
+
+// Recover turns a panicking handler into a 500 internal_error response and
+// logs the panic with its stack. When the handler had already started the
+// response, the connection is dropped instead so the client does not take
+// the partial body for a whole one. It should run inside AccessLog, so the
+// request is logged with the status the client got.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Aborting is how handlers drop the connection on purpose
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			log.Printf("panic serving %s %s (request %s): %v\n%s",
+				r.Method, r.URL.Path, RequestIDFromContext(r.Context()), v, debug.Stack())
+			if rw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			res.Error(w, r, apierr.Internal(fmt.Errorf("panic: %v", v)))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
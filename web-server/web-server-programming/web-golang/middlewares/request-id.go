@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	res "github.com/conglt10/web-golang/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxRequestIDLength bounds ids sent by clients, they end up in every log
+// line of the request
+const maxRequestIDLength = 64
+
+// RequestID gives every request an id, returned in the X-Request-ID header
+// and included in error responses and logs. An id sent by the client, such
+// as one set by a proxy in front of the server, is kept when it looks sane.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(res.RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set(res.RequestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(res.WithRequestID(r.Context(), id)))
+	})
+}
+
+// RequestIDFromContext returns the id RequestID gave the request
+func RequestIDFromContext(ctx context.Context) string {
+	return res.RequestIDFromContext(ctx)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"net/http"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// RequireRole only lets callers with one of the roles through. It must run
+// after CheckJwt.
+func RequireRole(roles []string, next httprouter.Handle) httprouter.Handle {
+	return require(func(p *jwt.Principal) bool {
+		for _, role := range roles {
+			if p.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	}, next)
+}
+
+// RequirePermission only lets callers whose roles grant perm through. It
+// must run after CheckJwt.
+func RequirePermission(perm jwt.Permission, next httprouter.Handle) httprouter.Handle {
+	return require(func(p *jwt.Principal) bool {
+		return p.Can(perm)
+	}, next)
+}
+
+func require(allowed func(*jwt.Principal) bool, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		principal, ok := jwt.PrincipalFromContext(r.Context())
+		if !ok {
+			res.Error(w, r, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		if !allowed(principal) {
+			res.Error(w, r, apierr.Forbidden("Permission denied"))
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
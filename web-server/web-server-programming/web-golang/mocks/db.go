@@ -2,71 +2,293 @@ package mock
 
 import (
 	"context"
+	"time"
 
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
 	"github.com/stretchr/testify/mock"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// MockCollection is a mock for MongoDB collection
-type MockCollection struct {
+// UserRepository is a mock for repository.UserRepository
+type UserRepository struct {
 	mock.Mock
 }
 
-func (m *MockCollection) FindOne(ctx context.Context, filter interface{}) *mongo.SingleResult {
-	args := m.Called(ctx, filter)
-	result := &mongo.SingleResult{}
-	if args.Get(0) != nil {
-		result = args.Get(0).(*mongo.SingleResult)
-	}
-	return result
+func (m *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	args := m.Called(ctx, username)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
 }
 
-func (m *MockCollection) InsertOne(ctx context.Context, document interface{}) (*mongo.InsertOneResult, error) {
-	args := m.Called(ctx, document)
-	return args.Get(0).(*mongo.InsertOneResult), args.Error(1)
+func (m *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	args := m.Called(ctx, email)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
 }
 
-func (m *MockCollection) Find(ctx context.Context, filter interface{}) (*mongo.Cursor, error) {
-	args := m.Called(ctx, filter)
-	return args.Get(0).(*mongo.Cursor), args.Error(1)
+func (m *UserRepository) ExistsByUsernameOrEmail(ctx context.Context, username, email string) (bool, error) {
+	args := m.Called(ctx, username, email)
+	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}) (*mongo.UpdateResult, error) {
-	args := m.Called(ctx, filter, update)
-	return args.Get(0).(*mongo.UpdateResult), args.Error(1)
+func (m *UserRepository) Create(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	args := m.Called(ctx)
+	users, _ := args.Get(0).([]models.User)
+	return users, args.Error(1)
+}
+
+func (m *UserRepository) SetRoles(ctx context.Context, username string, roles []string) error {
+	args := m.Called(ctx, username, roles)
+	return args.Error(0)
+}
+
+func (m *UserRepository) UpdatePassword(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *UserRepository) SetEmailVerified(ctx context.Context, username, email string) error {
+	args := m.Called(ctx, username, email)
+	return args.Error(0)
+}
+
+func (m *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *UserRepository) Delete(ctx context.Context, username string) error {
+	args := m.Called(ctx, username)
+	return args.Error(0)
+}
+
+// PostRepository is a mock for repository.PostRepository
+type PostRepository struct {
+	mock.Mock
+}
+
+func (m *PostRepository) Find(ctx context.Context, q repository.PostQuery) ([]models.Post, error) {
+	args := m.Called(ctx, q)
+	posts, _ := args.Get(0).([]models.Post)
+	return posts, args.Error(1)
+}
+
+func (m *PostRepository) Count(ctx context.Context, f repository.PostFilter) (int64, error) {
+	args := m.Called(ctx, f)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *PostRepository) FindByAuthor(ctx context.Context, username string) ([]models.Post, error) {
+	args := m.Called(ctx, username)
+	posts, _ := args.Get(0).([]models.Post)
+	return posts, args.Error(1)
+}
+
+func (m *PostRepository) FindByID(ctx context.Context, id string) (*models.Post, error) {
+	args := m.Called(ctx, id)
+	post, _ := args.Get(0).(*models.Post)
+	return post, args.Error(1)
 }
 
-func (m *MockCollection) FindOneAndDelete(ctx context.Context, filter interface{}) *mongo.SingleResult {
-	args := m.Called(ctx, filter)
-	return args.Get(0).(*mongo.SingleResult)
+func (m *PostRepository) Create(ctx context.Context, post *models.Post) error {
+	args := m.Called(ctx, post)
+	return args.Error(0)
+}
+
+func (m *PostRepository) Update(ctx context.Context, post *models.Post) error {
+	args := m.Called(ctx, post)
+	return args.Error(0)
 }
 
-// MockSingleResult is a mock for MongoDB SingleResult
-type MockSingleResult struct {
+func (m *PostRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// RefreshTokenRepository is a mock for repository.RefreshTokenRepository
+type RefreshTokenRepository struct {
 	mock.Mock
 }
 
-func (m *MockSingleResult) Decode(v interface{}) error {
-	args := m.Called(v)
+func (m *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	args := m.Called(ctx, hash)
+	token, _ := args.Get(0).(*models.RefreshToken)
+	return token, args.Error(1)
+}
+
+func (m *RefreshTokenRepository) Rotate(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *RefreshTokenRepository) RevokeFamily(ctx context.Context, family string) error {
+	args := m.Called(ctx, family)
 	return args.Error(0)
 }
 
-// MockCursor is a mock for MongoDB Cursor
-type MockCursor struct {
+func (m *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, username string) error {
+	args := m.Called(ctx, username)
+	return args.Error(0)
+}
+
+// UserTokenRepository is a mock for repository.UserTokenRepository
+type UserTokenRepository struct {
 	mock.Mock
 }
 
-func (m *MockCursor) Close(ctx context.Context) error {
-	args := m.Called(ctx)
+func (m *UserTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	args := m.Called(ctx, token)
 	return args.Error(0)
 }
 
-func (m *MockCursor) Next(ctx context.Context) bool {
-	args := m.Called(ctx)
-	return args.Bool(0)
+func (m *UserTokenRepository) Consume(ctx context.Context, hash, purpose string, now time.Time) (*models.UserToken, error) {
+	args := m.Called(ctx, hash, purpose, now)
+	token, _ := args.Get(0).(*models.UserToken)
+	return token, args.Error(1)
+}
+
+func (m *UserTokenRepository) DeleteForUser(ctx context.Context, username, purpose string) error {
+	args := m.Called(ctx, username, purpose)
+	return args.Error(0)
+}
+
+// IdentityRepository is a mock for repository.IdentityRepository
+type IdentityRepository struct {
+	mock.Mock
+}
+
+func (m *IdentityRepository) Find(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	args := m.Called(ctx, provider, subject)
+	identity, _ := args.Get(0).(*models.Identity)
+	return identity, args.Error(1)
+}
+
+func (m *IdentityRepository) Create(ctx context.Context, identity *models.Identity) error {
+	args := m.Called(ctx, identity)
+	return args.Error(0)
+}
+
+func (m *IdentityRepository) DeleteForUser(ctx context.Context, username string) error {
+	args := m.Called(ctx, username)
+	return args.Error(0)
+}
+
+// APIKeyRepository is a mock for repository.APIKeyRepository
+type APIKeyRepository struct {
+	mock.Mock
+}
+
+func (m *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	args := m.Called(ctx, hash)
+	key, _ := args.Get(0).(*models.APIKey)
+	return key, args.Error(1)
+}
+
+func (m *APIKeyRepository) ListForUser(ctx context.Context, username string) ([]models.APIKey, error) {
+	args := m.Called(ctx, username)
+	keys, _ := args.Get(0).([]models.APIKey)
+	return keys, args.Error(1)
+}
+
+func (m *APIKeyRepository) Delete(ctx context.Context, username, id string) error {
+	args := m.Called(ctx, username, id)
+	return args.Error(0)
+}
+
+func (m *APIKeyRepository) DeleteForUser(ctx context.Context, username string) error {
+	args := m.Called(ctx, username)
+	return args.Error(0)
+}
+
+func (m *APIKeyRepository) Touch(ctx context.Context, id string, at time.Time) error {
+	args := m.Called(ctx, id, at)
+	return args.Error(0)
+}
+
+// CommentRepository is a mock for repository.CommentRepository
+type CommentRepository struct {
+	mock.Mock
+}
+
+func (m *CommentRepository) FindByPost(ctx context.Context, postID string) ([]models.Comment, error) {
+	args := m.Called(ctx, postID)
+	comments, _ := args.Get(0).([]models.Comment)
+	return comments, args.Error(1)
+}
+
+func (m *CommentRepository) FindByID(ctx context.Context, id string) (*models.Comment, error) {
+	args := m.Called(ctx, id)
+	comment, _ := args.Get(0).(*models.Comment)
+	return comment, args.Error(1)
+}
+
+func (m *CommentRepository) Create(ctx context.Context, comment *models.Comment) error {
+	args := m.Called(ctx, comment)
+	return args.Error(0)
+}
+
+func (m *CommentRepository) Update(ctx context.Context, comment *models.Comment) error {
+	args := m.Called(ctx, comment)
+	return args.Error(0)
+}
+
+func (m *CommentRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *CommentRepository) DeleteByPost(ctx context.Context, postID string) error {
+	args := m.Called(ctx, postID)
+	return args.Error(0)
+}
+
+func (m *CommentRepository) AnonymizeAuthor(ctx context.Context, username string) error {
+	args := m.Called(ctx, username)
+	return args.Error(0)
+}
+
+// LikeRepository is a mock for repository.LikeRepository
+type LikeRepository struct {
+	mock.Mock
+}
+
+func (m *LikeRepository) Like(ctx context.Context, postID, username string) error {
+	args := m.Called(ctx, postID, username)
+	return args.Error(0)
+}
+
+func (m *LikeRepository) Unlike(ctx context.Context, postID, username string) error {
+	args := m.Called(ctx, postID, username)
+	return args.Error(0)
+}
+
+func (m *LikeRepository) Count(ctx context.Context, postIDs []string) (map[string]int64, error) {
+	args := m.Called(ctx, postIDs)
+	counts, _ := args.Get(0).(map[string]int64)
+	return counts, args.Error(1)
+}
+
+func (m *LikeRepository) DeleteByPost(ctx context.Context, postID string) error {
+	args := m.Called(ctx, postID)
+	return args.Error(0)
 }
 
-func (m *MockCursor) Decode(v interface{}) error {
-	args := m.Called(v)
+func (m *LikeRepository) DeleteByUser(ctx context.Context, username string) error {
+	args := m.Called(ctx, username)
 	return args.Error(0)
 }
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived credential of a user for scripts and integrations.
+// Like refresh tokens, only the SHA-256 hash of the key is stored, the key
+// itself is shown once when it is created.
+type APIKey struct {
+	ID       string `json:"id" bson:"id"`
+	Username string `json:"username" bson:"username"`
+	Name     string `json:"name" bson:"name"`
+	// Prefix is the start of the key, to tell keys apart
+	Prefix  string   `json:"prefix" bson:"prefix"`
+	KeyHash string   `json:"-" bson:"key_hash"`
+	Scopes  []string `json:"scopes" bson:"scopes"`
+	// LastUsedAt is nil until the key is used, and only updated about once
+	// a minute
+	LastUsedAt *time.Time `json:"last_used_at" bson:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Comment is a comment on a post. Comments can be answered by replies, which
+// have ParentID set and cannot be answered themselves.
+type Comment struct {
+	ID     string `json:"id" bson:"id"`
+	PostID string `json:"post_id" bson:"post_id"`
+	// ParentID is empty for top level comments
+	ParentID  string    `json:"parent_id,omitempty" bson:"parent_id"`
+	AuthorID  string    `json:"author_id" bson:"author_id"`
+	Author    string    `json:"author" bson:"author"`
+	Body      string    `json:"body" bson:"body"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// IsReply reports whether the comment answers another comment
+func (c *Comment) IsReply() bool {
+	return c.ParentID != ""
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Identity links an account at an OpenID Connect provider to a local user.
+// The subject is the provider's id for the account, it never changes even
+// when the email does.
+type Identity struct {
+	Provider  string    `json:"provider" bson:"provider"`
+	Subject   string    `json:"subject" bson:"subject"`
+	Username  string    `json:"username" bson:"username"`
+	Email     string    `json:"email" bson:"email"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
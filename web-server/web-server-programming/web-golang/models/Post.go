@@ -1,7 +1,73 @@
 package models
 
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	PostDraft     = "draft"
+	PostPublished = "published"
+)
+
+const (
+	maxTags      = 10
+	maxTagLength = 30
+)
+
+// Post is a post written by a user. Author is the username, kept next to
+// AuthorID so posts can be listed and filtered without looking users up.
+// Body is Markdown.
 type Post struct {
-	id      string
-	creater string
-	title   string
+	ID        string    `json:"id" bson:"id"`
+	AuthorID  string    `json:"author_id" bson:"author_id"`
+	Author    string    `json:"author" bson:"author"`
+	Title     string    `json:"title" bson:"title"`
+	Body      string    `json:"body" bson:"body"`
+	Tags      []string  `json:"tags" bson:"tags"`
+	Status    string    `json:"status" bson:"status"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	// LikeCount is counted from the likes when the post is returned, it is
+	// not stored with the post
+	LikeCount int64 `json:"like_count" bson:"-"`
+}
+
+// Published reports whether other users can see the post
+func (p *Post) Published() bool {
+	return p.Status != PostDraft
+}
+
+// ValidPostStatus reports whether status is draft or published
+func ValidPostStatus(status string) bool {
+	return status == PostDraft || status == PostPublished
+}
+
+// NormalizeTags trims and lowercases tags and drops duplicates. Tags may
+// only contain letters, digits and dashes.
+func NormalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(Normalize(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len([]rune(tag)) > maxTagLength {
+			return nil, fmt.Errorf("Tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' {
+				return nil, fmt.Errorf("Tag %q may only contain letters, digits and dashes", tag)
+			}
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if len(result) > maxTags {
+		return nil, fmt.Errorf("A post can have at most %d tags", maxTags)
+	}
+	return result, nil
 }
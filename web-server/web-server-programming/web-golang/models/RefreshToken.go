@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// RefreshToken is a server-side login session. Only the SHA-256 hash of the
+// token handed to the client is stored. Each refresh rotates the presented
+// token out and issues a new one in the same family, so a rotated token
+// showing up again means it was stolen.
+type RefreshToken struct {
+	ID        string    `json:"id" bson:"id"`
+	Family    string    `json:"family" bson:"family"`
+	Username  string    `json:"username" bson:"username"`
+	TokenHash string    `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// Rotated is set once the token has been exchanged for a new one
+	Rotated bool `json:"rotated" bson:"rotated"`
+	// Revoked is set when the session is ended by logout or reuse detection
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+// Expired reports whether the token can no longer be used at the given time
+func (t *RefreshToken) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
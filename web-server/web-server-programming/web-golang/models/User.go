@@ -3,26 +3,118 @@ package models
 import (
 	"html"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
 type User struct {
-	username string
-	email    string
-	password string
+	ID       string `json:"id" bson:"id"`
+	Username string `json:"username" bson:"username"`
+	// DisplayName is the name shown instead of the username, it may be
+	// empty
+	DisplayName string `json:"display_name" bson:"display_name"`
+	Email       string `json:"email" bson:"email"`
+	// EmailVerified is set once the user follows the link mailed to Email
+	EmailVerified bool   `json:"email_verified" bson:"email_verified"`
+	Password      string `json:"-" bson:"password"`
+	// PasswordScheme is how the password was prepared before hashing
+	PasswordScheme int       `json:"-" bson:"password_scheme"`
+	Roles          []string  `json:"roles" bson:"roles"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
 }
 
-func Hash(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+// GetRoles returns the user's roles. Users stored before roles existed have
+// none and are plain users.
+func (u *User) GetRoles() []string {
+	if len(u.Roles) == 0 {
+		return []string{RoleUser}
+	}
+	return u.Roles
+}
+
+const (
+	// PasswordEscaped hashes were made from the trimmed, HTML escaped
+	// password. Users still on it are moved to PasswordNormalized on login.
+	PasswordEscaped = 0
+	// PasswordNormalized hashes are made from NormalizePassword
+	PasswordNormalized = 1
+)
+
+// SetPassword hashes the password with the current scheme and the bcrypt
+// cost
+func (u *User) SetPassword(password string, cost int) error {
+	hash, err := Hash(NormalizePassword(password), cost)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	u.PasswordScheme = PasswordNormalized
+	return nil
+}
+
+// CheckPassword returns nil when password matches. outdated reports that
+// the hash uses an older scheme or another cost than cost and should be
+// replaced with SetPassword while the plain password is at hand.
+func (u *User) CheckPassword(password string, cost int) (outdated bool, err error) {
+	if u.PasswordScheme == PasswordEscaped {
+		return true, CheckPasswordHash(u.Password, html.EscapeString(strings.TrimSpace(password)))
+	}
+	hashCost, err := bcrypt.Cost([]byte(u.Password))
+	if err != nil {
+		return false, err
+	}
+	return hashCost != cost, CheckPasswordHash(u.Password, NormalizePassword(password))
+}
+
+// DefaultPasswordCost is the bcrypt cost used unless configured otherwise.
+// Each step doubles the work of hashing and of every login.
+const DefaultPasswordCost = 12
+
+var (
+	// dummyHashes holds a hash per cost for CheckUnknownPassword
+	dummyMu     sync.Mutex
+	dummyHashes = map[int][]byte{}
+)
+
+func Hash(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	return string(bytes), err
 }
 
+// CheckUnknownPassword does the work of checking a password hashed with
+// cost for a user that does not exist, so the response takes as long as for
+// a wrong password and does not tell which usernames are taken
+func CheckUnknownPassword(password string, cost int) {
+	dummyMu.Lock()
+	hash, ok := dummyHashes[cost]
+	if !ok {
+		hash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), cost)
+		dummyHashes[cost] = hash
+	}
+	dummyMu.Unlock()
+	// Only the time spent matters, the result is ignored
+	_ = bcrypt.CompareHashAndPassword(hash, []byte(NormalizePassword(password)))
+}
+
 func CheckPasswordHash(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-func Santize(data string) string {
-	data = html.EscapeString(strings.TrimSpace(data))
-	return data
+// Normalize prepares text for storage: trimmed and in Unicode NFC, so the
+// same text typed on different systems compares equal. It is stored as is
+// and only escaped when written out, JSON responses escape HTML characters
+// and Markdown is sanitized when rendered.
+func Normalize(s string) string {
+	return norm.NFC.String(strings.TrimSpace(s))
+}
+
+// NormalizePassword maps the password to NFKC, as NIST SP 800-63B advises,
+// so look-alike compositions of the same characters hash the same.
+// Whitespace is part of the password and is kept.
+func NormalizePassword(password string) string {
+	return norm.NFKC.String(password)
 }
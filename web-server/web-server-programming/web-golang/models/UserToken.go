@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Purposes of user tokens, a token only works for its own
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken is a single-use token mailed to a user to prove they own the
+// address. Like refresh tokens, only the SHA-256 hash is stored.
+type UserToken struct {
+	ID       string `json:"id" bson:"id"`
+	Username string `json:"username" bson:"username"`
+	Purpose  string `json:"purpose" bson:"purpose"`
+	// Email is the address the token was sent to. A verification is only
+	// accepted while it is still the user's address.
+	Email     string    `json:"email" bson:"email"`
+	TokenHash string    `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	Used      bool      `json:"used" bson:"used"`
+}
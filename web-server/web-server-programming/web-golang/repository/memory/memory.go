@@ -0,0 +1,758 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+)
+
+// UserRepository is an in-memory repository.UserRepository for tests and
+// local runs without a database
+type UserRepository struct {
+	mu    sync.RWMutex
+	users []models.User
+}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{}
+}
+
+func (r *UserRepository) FindByUsername(_ context.Context, username string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Username == username {
+			user := u
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *UserRepository) FindByEmail(_ context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			user := u
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *UserRepository) ExistsByUsernameOrEmail(_ context.Context, username, email string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.exists(username, email), nil
+}
+
+func (r *UserRepository) Create(_ context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.exists(user.Username, user.Email) {
+		return repository.ErrConflict
+	}
+	r.users = append(r.users, *user)
+	return nil
+}
+
+func (r *UserRepository) List(_ context.Context) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]models.User, len(r.users))
+	copy(result, r.users)
+	return result, nil
+}
+
+func (r *UserRepository) SetRoles(_ context.Context, username string, roles []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].Username == username {
+			r.users[i].Roles = append([]string(nil), roles...)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *UserRepository) UpdatePassword(_ context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].Username == user.Username {
+			r.users[i].Password = user.Password
+			r.users[i].PasswordScheme = user.PasswordScheme
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *UserRepository) SetEmailVerified(_ context.Context, username, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].Username == username && r.users[i].Email == email {
+			r.users[i].EmailVerified = true
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *UserRepository) UpdateProfile(_ context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(user.Username)
+	if i < 0 {
+		return repository.ErrNotFound
+	}
+	for _, u := range r.users {
+		if u.Email == user.Email && u.Username != user.Username {
+			return repository.ErrConflict
+		}
+	}
+	r.users[i].DisplayName = user.DisplayName
+	r.users[i].Email = user.Email
+	r.users[i].EmailVerified = user.EmailVerified
+	r.users[i].UpdatedAt = user.UpdatedAt
+	return nil
+}
+
+func (r *UserRepository) Delete(_ context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(username)
+	if i < 0 {
+		return repository.ErrNotFound
+	}
+	r.users = append(r.users[:i], r.users[i+1:]...)
+	return nil
+}
+
+// indexOf returns the position of the user with the username, or -1. The
+// caller holds the lock.
+func (r *UserRepository) indexOf(username string) int {
+	for i, u := range r.users {
+		if u.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *UserRepository) exists(username, email string) bool {
+	for _, u := range r.users {
+		if u.Username == username || u.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// PostRepository is an in-memory repository.PostRepository
+type PostRepository struct {
+	mu    sync.RWMutex
+	posts []models.Post
+}
+
+func NewPostRepository() *PostRepository {
+	return &PostRepository{}
+}
+
+func (r *PostRepository) Find(_ context.Context, q repository.PostQuery) ([]models.Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []models.Post
+	for _, p := range r.posts {
+		if matches(p, q.PostFilter) && afterCursor(p, q) {
+			result = append(result, p)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if q.Order == repository.OldestFirst {
+			a, b = b, a
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+func (r *PostRepository) Count(_ context.Context, f repository.PostFilter) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var n int64
+	for _, p := range r.posts {
+		if matches(p, f) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func matches(p models.Post, f repository.PostFilter) bool {
+	if f.Author != "" && p.Author != f.Author {
+		return false
+	}
+	if f.Tag != "" && !hasTag(p, f.Tag) {
+		return false
+	}
+	if f.VisibleTo != "" && !p.Published() && p.Author != f.VisibleTo {
+		return false
+	}
+	return strings.Contains(strings.ToLower(p.Title), strings.ToLower(f.TitleContains))
+}
+
+func hasTag(p models.Post, tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// afterCursor reports whether the post sorts after the cursor of the query
+func afterCursor(p models.Post, q repository.PostQuery) bool {
+	c := q.After
+	if c == nil {
+		return true
+	}
+	if q.Order == repository.OldestFirst {
+		return p.CreatedAt.After(c.CreatedAt) || (p.CreatedAt.Equal(c.CreatedAt) && p.ID > c.ID)
+	}
+	return p.CreatedAt.Before(c.CreatedAt) || (p.CreatedAt.Equal(c.CreatedAt) && p.ID < c.ID)
+}
+
+func (r *PostRepository) FindByAuthor(_ context.Context, username string) ([]models.Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []models.Post
+	for _, p := range r.posts {
+		if p.Author == username {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (r *PostRepository) FindByID(_ context.Context, id string) (*models.Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.indexOf(id)
+	if i < 0 {
+		return nil, repository.ErrNotFound
+	}
+	post := r.posts[i]
+	return &post, nil
+}
+
+func (r *PostRepository) Create(_ context.Context, post *models.Post) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.indexOf(post.ID) >= 0 {
+		return repository.ErrConflict
+	}
+	r.posts = append(r.posts, *post)
+	return nil
+}
+
+func (r *PostRepository) Update(_ context.Context, post *models.Post) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(post.ID)
+	if i < 0 {
+		return repository.ErrNotFound
+	}
+	r.posts[i] = *post
+	return nil
+}
+
+func (r *PostRepository) Delete(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(id)
+	if i < 0 {
+		return repository.ErrNotFound
+	}
+	r.posts = append(r.posts[:i], r.posts[i+1:]...)
+	return nil
+}
+
+func (r *PostRepository) indexOf(id string) int {
+	for i, p := range r.posts {
+		if p.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// RefreshTokenRepository is an in-memory repository.RefreshTokenRepository
+type RefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens []models.RefreshToken
+}
+
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+	return &RefreshTokenRepository{}
+}
+
+func (r *RefreshTokenRepository) Create(_ context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tokens {
+		if t.ID == token.ID || t.TokenHash == token.TokenHash {
+			return repository.ErrConflict
+		}
+	}
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *RefreshTokenRepository) FindByHash(_ context.Context, hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tokens {
+		if t.TokenHash == hash {
+			token := t
+			return &token, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *RefreshTokenRepository) Rotate(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.tokens {
+		if r.tokens[i].ID == id && !r.tokens[i].Rotated && !r.tokens[i].Revoked {
+			r.tokens[i].Rotated = true
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(_ context.Context, family string) error {
+	r.revokeWhere(func(t models.RefreshToken) bool { return t.Family == family })
+	return nil
+}
+
+func (r *RefreshTokenRepository) RevokeAllForUser(_ context.Context, username string) error {
+	r.revokeWhere(func(t models.RefreshToken) bool { return t.Username == username })
+	return nil
+}
+
+func (r *RefreshTokenRepository) revokeWhere(match func(models.RefreshToken) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.tokens {
+		if match(r.tokens[i]) {
+			r.tokens[i].Revoked = true
+		}
+	}
+}
+
+// UserTokenRepository is an in-memory repository.UserTokenRepository
+type UserTokenRepository struct {
+	mu     sync.Mutex
+	tokens []models.UserToken
+}
+
+func NewUserTokenRepository() *UserTokenRepository {
+	return &UserTokenRepository{}
+}
+
+func (r *UserTokenRepository) Create(_ context.Context, token *models.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tokens {
+		if t.ID == token.ID || t.TokenHash == token.TokenHash {
+			return repository.ErrConflict
+		}
+	}
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *UserTokenRepository) Consume(_ context.Context, hash, purpose string, now time.Time) (*models.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.tokens {
+		t := &r.tokens[i]
+		if t.TokenHash == hash && t.Purpose == purpose && !t.Used && now.Before(t.ExpiresAt) {
+			t.Used = true
+			token := *t
+			return &token, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *UserTokenRepository) DeleteForUser(_ context.Context, username, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.tokens[:0]
+	for _, t := range r.tokens {
+		if t.Username != username || t.Purpose != purpose {
+			kept = append(kept, t)
+		}
+	}
+	r.tokens = kept
+	return nil
+}
+
+// IdentityRepository is an in-memory repository.IdentityRepository
+type IdentityRepository struct {
+	mu         sync.RWMutex
+	identities []models.Identity
+}
+
+func NewIdentityRepository() *IdentityRepository {
+	return &IdentityRepository{}
+}
+
+func (r *IdentityRepository) Find(_ context.Context, provider, subject string) (*models.Identity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *IdentityRepository) Create(_ context.Context, identity *models.Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.identities {
+		if i.Provider == identity.Provider && i.Subject == identity.Subject {
+			return repository.ErrConflict
+		}
+	}
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *IdentityRepository) DeleteForUser(_ context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.identities[:0]
+	for _, i := range r.identities {
+		if i.Username != username {
+			kept = append(kept, i)
+		}
+	}
+	r.identities = kept
+	return nil
+}
+
+// APIKeyRepository is an in-memory repository.APIKeyRepository
+type APIKeyRepository struct {
+	mu   sync.RWMutex
+	keys []models.APIKey
+}
+
+func NewAPIKeyRepository() *APIKeyRepository {
+	return &APIKeyRepository{}
+}
+
+func (r *APIKeyRepository) Create(_ context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.keys {
+		if k.ID == key.ID || k.KeyHash == key.KeyHash {
+			return repository.ErrConflict
+		}
+	}
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *APIKeyRepository) FindByHash(_ context.Context, hash string) (*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.keys {
+		if k.KeyHash == hash {
+			return &k, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *APIKeyRepository) ListForUser(_ context.Context, username string) ([]models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []models.APIKey
+	for _, k := range r.keys {
+		if k.Username == username {
+			result = append(result, k)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (r *APIKeyRepository) Delete(_ context.Context, username, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, k := range r.keys {
+		if k.ID == id && k.Username == username {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *APIKeyRepository) DeleteForUser(_ context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.keys[:0]
+	for _, k := range r.keys {
+		if k.Username != username {
+			kept = append(kept, k)
+		}
+	}
+	r.keys = kept
+	return nil
+}
+
+func (r *APIKeyRepository) Touch(_ context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.keys {
+		if r.keys[i].ID == id {
+			r.keys[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+// CommentRepository is an in-memory repository.CommentRepository
+type CommentRepository struct {
+	mu       sync.RWMutex
+	comments []models.Comment
+}
+
+func NewCommentRepository() *CommentRepository {
+	return &CommentRepository{}
+}
+
+func (r *CommentRepository) FindByPost(_ context.Context, postID string) ([]models.Comment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []models.Comment
+	for _, c := range r.comments {
+		if c.PostID == postID {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return result, nil
+}
+
+func (r *CommentRepository) FindByID(_ context.Context, id string) (*models.Comment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.comments {
+		if c.ID == id {
+			comment := c
+			return &comment, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *CommentRepository) Create(_ context.Context, comment *models.Comment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.comments {
+		if c.ID == comment.ID {
+			return repository.ErrConflict
+		}
+	}
+	r.comments = append(r.comments, *comment)
+	return nil
+}
+
+func (r *CommentRepository) Update(_ context.Context, comment *models.Comment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.comments {
+		if r.comments[i].ID == comment.ID {
+			r.comments[i] = *comment
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *CommentRepository) Delete(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n := r.deleteWhere(func(c models.Comment) bool { return c.ID == id }); n == 0 {
+		return repository.ErrNotFound
+	}
+	r.deleteWhere(func(c models.Comment) bool { return c.ParentID == id })
+	return nil
+}
+
+func (r *CommentRepository) DeleteByPost(_ context.Context, postID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteWhere(func(c models.Comment) bool { return c.PostID == postID })
+	return nil
+}
+
+func (r *CommentRepository) AnonymizeAuthor(_ context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.comments {
+		if r.comments[i].Author == username {
+			r.comments[i].AuthorID = ""
+			r.comments[i].Author = ""
+		}
+	}
+	return nil
+}
+
+// deleteWhere removes the matching comments and returns how many it removed.
+// The caller holds the lock.
+func (r *CommentRepository) deleteWhere(match func(models.Comment) bool) int {
+	kept := r.comments[:0]
+	for _, c := range r.comments {
+		if !match(c) {
+			kept = append(kept, c)
+		}
+	}
+	n := len(r.comments) - len(kept)
+	r.comments = kept
+	return n
+}
+
+// LikeRepository is an in-memory repository.LikeRepository
+type LikeRepository struct {
+	mu sync.RWMutex
+	// likes maps post ids to the users who like them
+	likes map[string]map[string]bool
+}
+
+func NewLikeRepository() *LikeRepository {
+	return &LikeRepository{likes: make(map[string]map[string]bool)}
+}
+
+func (r *LikeRepository) Like(_ context.Context, postID, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.likes[postID] == nil {
+		r.likes[postID] = make(map[string]bool)
+	}
+	r.likes[postID][username] = true
+	return nil
+}
+
+func (r *LikeRepository) Unlike(_ context.Context, postID, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.likes[postID], username)
+	return nil
+}
+
+func (r *LikeRepository) Count(_ context.Context, postIDs []string) (map[string]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, id := range postIDs {
+		if n := len(r.likes[id]); n > 0 {
+			counts[id] = int64(n)
+		}
+	}
+	return counts, nil
+}
+
+func (r *LikeRepository) DeleteByPost(_ context.Context, postID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.likes, postID)
+	return nil
+}
+
+func (r *LikeRepository) DeleteByUser(_ context.Context, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, users := range r.likes {
+		delete(users, username)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import "time"
+
+// PostFilter narrows the posts returned by PostRepository.Find
+type PostFilter struct {
+	Author string
+	// TitleContains matches titles containing the text, ignoring case
+	TitleContains string
+	Tag           string
+	// VisibleTo hides drafts except the ones written by this user. Drafts
+	// are not hidden when it is empty.
+	VisibleTo string
+}
+
+// SortOrder orders posts by creation time. Posts created at the same time
+// are ordered by id in the same direction, so the order is total.
+type SortOrder int
+
+const (
+	NewestFirst SortOrder = iota
+	OldestFirst
+)
+
+// PostCursor is the sort key of the last post of a page. The next page starts
+// right after it, so posts created in between pages are neither skipped nor
+// repeated.
+type PostCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// PostQuery selects a page of posts
+type PostQuery struct {
+	PostFilter
+	Order SortOrder
+	// After is nil for the first page
+	After *PostCursor
+	Limit int
+}
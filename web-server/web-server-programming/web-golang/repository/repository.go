@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/conglt10/web-golang/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a record violates a unique constraint
+	ErrConflict = errors.New("record already exists")
+)
+
+// UserRepository stores registered users
+type UserRepository interface {
+	// FindByUsername returns ErrNotFound when no user has the username
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	// FindByEmail returns ErrNotFound when no user has the email
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// ExistsByUsernameOrEmail reports whether the username or email is taken
+	ExistsByUsernameOrEmail(ctx context.Context, username, email string) (bool, error)
+	// Create returns ErrConflict when the username or email is taken
+	Create(ctx context.Context, user *models.User) error
+	List(ctx context.Context) ([]models.User, error)
+	// SetRoles replaces the user's roles, or returns ErrNotFound
+	SetRoles(ctx context.Context, username string, roles []string) error
+	// UpdatePassword stores the password hash and scheme of the user, or
+	// returns ErrNotFound
+	UpdatePassword(ctx context.Context, user *models.User) error
+	// SetEmailVerified marks the email of the user as verified. It returns
+	// ErrNotFound unless email is still the user's address.
+	SetEmailVerified(ctx context.Context, username, email string) error
+	// UpdateProfile stores the display name, email and email verification
+	// of the user. It returns ErrNotFound, or ErrConflict when the email is
+	// taken.
+	UpdateProfile(ctx context.Context, user *models.User) error
+	// Delete returns ErrNotFound when no user has the username
+	Delete(ctx context.Context, username string) error
+}
+
+// PostRepository stores posts
+type PostRepository interface {
+	// Find returns up to q.Limit posts matching the query in q.Order
+	Find(ctx context.Context, q PostQuery) ([]models.Post, error)
+	// Count returns the number of posts matching the filter. It may be an
+	// estimate when the filter is empty.
+	Count(ctx context.Context, f PostFilter) (int64, error)
+	FindByAuthor(ctx context.Context, username string) ([]models.Post, error)
+	// FindByID returns ErrNotFound when no post has the id
+	FindByID(ctx context.Context, id string) (*models.Post, error)
+	Create(ctx context.Context, post *models.Post) error
+	// Update replaces the stored post with the same id, or returns ErrNotFound
+	Update(ctx context.Context, post *models.Post) error
+	// Delete returns ErrNotFound when no post has the id
+	Delete(ctx context.Context, id string) error
+}
+
+// CommentRepository stores comments on posts
+type CommentRepository interface {
+	// FindByPost returns the comments and replies on a post, oldest first
+	FindByPost(ctx context.Context, postID string) ([]models.Comment, error)
+	// FindByID returns ErrNotFound when no comment has the id
+	FindByID(ctx context.Context, id string) (*models.Comment, error)
+	Create(ctx context.Context, comment *models.Comment) error
+	// Update replaces the stored comment with the same id, or returns
+	// ErrNotFound
+	Update(ctx context.Context, comment *models.Comment) error
+	// Delete removes the comment and its replies, or returns ErrNotFound
+	Delete(ctx context.Context, id string) error
+	// DeleteByPost removes every comment on the post
+	DeleteByPost(ctx context.Context, postID string) error
+	// AnonymizeAuthor clears the author of every comment of the user, the
+	// comments and their replies are kept
+	AnonymizeAuthor(ctx context.Context, username string) error
+}
+
+// LikeRepository stores which users like which posts. Liking and unliking
+// are idempotent.
+type LikeRepository interface {
+	Like(ctx context.Context, postID, username string) error
+	Unlike(ctx context.Context, postID, username string) error
+	// Count returns the number of likes of each post. Posts without likes
+	// are left out of the map.
+	Count(ctx context.Context, postIDs []string) (map[string]int64, error)
+	// DeleteByPost removes every like of the post
+	DeleteByPost(ctx context.Context, postID string) error
+	// DeleteByUser removes every like of the user
+	DeleteByUser(ctx context.Context, username string) error
+}
+
+// RefreshTokenRepository stores refresh token sessions
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	// FindByHash returns ErrNotFound when no token has the hash
+	FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// Rotate marks an active token as exchanged. It returns ErrNotFound when
+	// the token is unknown, rotated or revoked, so two concurrent refreshes
+	// with the same token cannot both succeed.
+	Rotate(ctx context.Context, id string) error
+	// RevokeFamily revokes every token issued from the same login
+	RevokeFamily(ctx context.Context, family string) error
+	// RevokeAllForUser revokes every session of the user
+	RevokeAllForUser(ctx context.Context, username string) error
+}
+
+// UserTokenRepository stores the single-use tokens mailed to users
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *models.UserToken) error
+	// Consume marks the token with the hash and purpose as used and returns
+	// it. It returns ErrNotFound when the token is unknown, used or expired
+	// at now, so a token works once even under concurrent requests.
+	Consume(ctx context.Context, hash, purpose string, now time.Time) (*models.UserToken, error)
+	// DeleteForUser removes the user's tokens for the purpose, so only the
+	// newest one sent works
+	DeleteForUser(ctx context.Context, username, purpose string) error
+}
+
+// IdentityRepository stores the external identities linked to users
+type IdentityRepository interface {
+	// Find returns ErrNotFound when no user is linked to the subject at the
+	// provider
+	Find(ctx context.Context, provider, subject string) (*models.Identity, error)
+	// Create returns ErrConflict when the subject is already linked
+	Create(ctx context.Context, identity *models.Identity) error
+	// DeleteForUser unlinks every identity of the user
+	DeleteForUser(ctx context.Context, username string) error
+}
+
+// APIKeyRepository stores the API keys of users
+type APIKeyRepository interface {
+	// Create returns ErrConflict when the id or hash is taken
+	Create(ctx context.Context, key *models.APIKey) error
+	// FindByHash returns ErrNotFound when no key has the hash
+	FindByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	// ListForUser returns the keys of the user, newest first
+	ListForUser(ctx context.Context, username string) ([]models.APIKey, error)
+	// Delete removes the key of the user. It returns ErrNotFound when the
+	// user has no key with the id.
+	Delete(ctx context.Context, username, id string) error
+	// DeleteForUser removes every key of the user
+	DeleteForUser(ctx context.Context, username string) error
+	// Touch records that the key was used at the time
+	Touch(ctx context.Context, id string, at time.Time) error
+}
@@ -0,0 +1,97 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
+	"github.com/julienschmidt/httprouter"
+)
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles" validate:"required"`
+}
+
+// ListUsers returns every user with their roles. Mount it behind
+// RequirePermission(jwt.PermManageUsers).
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	users, err := h.Users.List(ctx)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	for i := range users {
+		users[i].Roles = users[i].GetRoles()
+	}
+
+	res.JSON(w, http.StatusOK, users)
+}
+
+// SetUserRoles replaces the roles of a user. Tokens already issued keep
+// their roles until the next refresh. Mount it behind
+// RequirePermission(jwt.PermManageUsers).
+func (h *Handler) SetUserRoles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetRolesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	errs := validation.Struct(&req)
+	for _, role := range req.Roles {
+		if !models.ValidRole(role) {
+			errs.Add("roles", "unknown role "+role)
+		}
+	}
+	if err := errs.Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	username := ps.ByName("username")
+	// Keeps the last admin from locking everyone out by accident
+	if username == principal.Username && !contains(req.Roles, models.RoleAdmin) {
+		res.Error(w, r, apierr.BadRequest("Admins cannot remove their own admin role"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := h.Users.SetRoles(ctx, username, req.Roles)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.NotFound("User not found"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	h.audit(r, principal, "user.roles.update", username, map[string]interface{}{"roles": req.Roles})
+	res.JSON(w, http.StatusOK, "Roles updated successfully")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
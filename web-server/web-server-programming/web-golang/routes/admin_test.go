@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/conglt10/web-golang/audit"
+	jwt "github.com/conglt10/web-golang/auth"
+	"github.com/conglt10/web-golang/middlewares"
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// auditRecorder keeps audit events in memory
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *auditRecorder) Record(_ context.Context, e audit.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func TestSetUserRoles(t *testing.T) {
+	h := newTestHandler()
+	recorder := &auditRecorder{}
+	h.Audit = recorder
+	ctx := context.Background()
+	for _, username := range []string{"admin", "testuser"} {
+		if err := h.Users.Create(ctx, &models.User{Username: username, Email: username + "@example.com", Password: "hashed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	adminToken, _ := h.Tokens.Create("admin", []string{models.RoleAdmin})
+	moderatorToken, _ := h.Tokens.Create("moderator", []string{models.RoleModerator})
+
+	tests := []struct {
+		name           string
+		username       string
+		roles          []string
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "Admin grants moderator",
+			username:       "testuser",
+			roles:          []string{models.RoleModerator},
+			token:          adminToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Moderator cannot manage users",
+			username:       "testuser",
+			roles:          []string{models.RoleAdmin},
+			token:          moderatorToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Unknown role",
+			username:       "testuser",
+			roles:          []string{"superuser"},
+			token:          adminToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown user",
+			username:       "nobody",
+			roles:          []string{models.RoleUser},
+			token:          adminToken,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Admin cannot demote themselves",
+			username:       "admin",
+			roles:          []string{models.RoleUser},
+			token:          adminToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(SetRolesRequest{Roles: tt.roles})
+			req := httptest.NewRequest("PUT", "/admin/users/"+tt.username+"/roles", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.PUT("/admin/users/:username/roles", middlewares.CheckJwt(h.Tokens,
+				middlewares.RequirePermission(jwt.PermManageUsers, h.SetUserRoles)))
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+		})
+	}
+
+	user, _ := h.Users.FindByUsername(ctx, "testuser")
+	if roles := user.GetRoles(); len(roles) != 1 || roles[0] != models.RoleModerator {
+		t.Errorf("testuser has roles %v, want [moderator]", roles)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].Action != "user.roles.update" || recorder.events[0].Actor != "admin" {
+		t.Errorf("unexpected audit events %+v", recorder.events)
+	}
+}
+
+func TestListUsersRequiresAdmin(t *testing.T) {
+	h := newTestHandler()
+	if err := h.Users.Create(context.Background(), &models.User{Username: "testuser", Email: "test@example.com", Password: "hashed"}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken, _ := h.Tokens.Create("admin", []string{models.RoleAdmin})
+	userToken, _ := h.Tokens.Create("testuser", []string{models.RoleUser})
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"Admin", adminToken, http.StatusOK},
+		{"User", userToken, http.StatusForbidden},
+		{"No token", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/users", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/admin/users", middlewares.CheckJwt(h.Tokens,
+				middlewares.RequireRole([]string{models.RoleAdmin}, h.ListUsers)))
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxAPIKeys is how many API keys a user can have
+const MaxAPIKeys = 20
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=50"`
+	Scopes []string `json:"scopes" validate:"required"`
+}
+
+// CreatedAPIKey is the response to creating a key, the only time the key
+// itself is returned
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// ListAPIKeys returns the API keys of the caller, without the keys
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys, err := h.APIKeys.ListForUser(ctx, principal.Username)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	res.JSON(w, http.StatusOK, keys)
+}
+
+// CreateAPIKey creates an API key with the scopes for the caller
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = models.Normalize(req.Name)
+	errs := validation.Struct(&req)
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !jwt.ValidScope(scope) {
+			errs.Add("scopes", "must be one of "+strings.Join(jwt.Scopes, ", "))
+			break
+		}
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if err := errs.Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	existing, err := h.APIKeys.ListForUser(ctx, principal.Username)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if len(existing) >= MaxAPIKeys {
+		res.Error(w, r, apierr.Conflict("Too many API keys, revoke one first"))
+		return
+	}
+
+	key, hash, prefix, err := jwt.NewAPIKey()
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	apiKey := models.APIKey{
+		ID:        uuid.NewV4().String(),
+		Username:  principal.Username,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := h.APIKeys.Create(ctx, &apiKey); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusCreated, CreatedAPIKey{APIKey: apiKey, Key: key})
+}
+
+// RevokeAPIKey deletes an API key of the caller, it stops working at once
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Keys of other users are not found either, so ids reveal nothing
+	err := h.APIKeys.Delete(ctx, principal.Username, ps.ByName("id"))
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.NotFound("API key not found"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, "API key revoked")
+}
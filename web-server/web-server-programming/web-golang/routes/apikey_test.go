@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	"github.com/conglt10/web-golang/middlewares"
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// createAPIKey creates a key for the user and returns it
+func createAPIKey(t *testing.T, h *Handler, username string, scopes ...string) CreatedAPIKey {
+	rr := serveAs("POST", "/me/api-keys", "/me/api-keys", h.CreateAPIKey, username,
+		CreateAPIKeyRequest{Name: "script", Scopes: scopes})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create key returned %v: %s", rr.Code, rr.Body)
+	}
+	var created CreatedAPIKey
+	json.NewDecoder(rr.Body).Decode(&created)
+	return created
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        CreateAPIKeyRequest
+		expectedStatus int
+	}{
+		{"Valid", CreateAPIKeyRequest{Name: " deploy bot ", Scopes: []string{"posts:read", "posts:write", "posts:read"}}, http.StatusCreated},
+		{"Missing name", CreateAPIKeyRequest{Scopes: []string{"posts:read"}}, http.StatusBadRequest},
+		{"Missing scopes", CreateAPIKeyRequest{Name: "bot"}, http.StatusBadRequest},
+		{"Unknown scope", CreateAPIKeyRequest{Name: "bot", Scopes: []string{"users:manage"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			rr := serveAs("POST", "/me/api-keys", "/me/api-keys", h.CreateAPIKey, "alice", tt.request)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned %v: %s", rr.Code, rr.Body)
+			}
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+
+			var created CreatedAPIKey
+			json.NewDecoder(rr.Body).Decode(&created)
+			if !strings.HasPrefix(created.Key, created.Prefix) || created.Name != "deploy bot" ||
+				len(created.Scopes) != 2 || created.LastUsedAt != nil {
+				t.Errorf("created %+v", created)
+			}
+
+			// Only the hash is kept
+			stored, err := h.APIKeys.FindByHash(context.Background(), jwt.HashAPIKey(created.Key))
+			if err != nil || stored.ID != created.ID || stored.Username != "alice" {
+				t.Errorf("stored key = %+v, %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyLimit(t *testing.T) {
+	h := newTestHandler()
+	for i := 0; i < MaxAPIKeys; i++ {
+		createAPIKey(t, h, "alice", jwt.ScopePostsRead)
+	}
+	rr := serveAs("POST", "/me/api-keys", "/me/api-keys", h.CreateAPIKey, "alice",
+		CreateAPIKeyRequest{Name: "one too many", Scopes: []string{jwt.ScopePostsRead}})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestListAndRevokeAPIKeys(t *testing.T) {
+	h := newTestHandler()
+	key := createAPIKey(t, h, "alice", jwt.ScopePostsRead)
+	createAPIKey(t, h, "bob", jwt.ScopePostsRead)
+
+	rr := serveAs("GET", "/me/api-keys", "/me/api-keys", h.ListAPIKeys, "alice", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list returned %v: %s", rr.Code, rr.Body)
+	}
+	if strings.Contains(rr.Body.String(), key.Key) || strings.Contains(rr.Body.String(), "key_hash") {
+		t.Error("list returned the key")
+	}
+	var keys []models.APIKey
+	json.NewDecoder(rr.Body).Decode(&keys)
+	if len(keys) != 1 || keys[0].ID != key.ID {
+		t.Errorf("listed %+v", keys)
+	}
+
+	// Keys of other users cannot be revoked
+	if rr := serveAs("DELETE", "/me/api-keys/:id", "/me/api-keys/"+key.ID, h.RevokeAPIKey, "bob", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("revoke as bob: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := serveAs("DELETE", "/me/api-keys/:id", "/me/api-keys/"+key.ID, h.RevokeAPIKey, "alice", nil); rr.Code != http.StatusOK {
+		t.Fatalf("revoke returned %v: %s", rr.Code, rr.Body)
+	}
+	rr = serveAs("GET", "/me/api-keys", "/me/api-keys", h.ListAPIKeys, "alice", nil)
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("keys after revoking: %s", rr.Body)
+	}
+}
+
+func TestCheckJwtWithAPIKey(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	h.Posts.Create(ctx, &models.Post{ID: "1", Author: "alice", Title: "Hello", Status: models.PostPublished})
+	readKey := createAPIKey(t, h, "alice", jwt.ScopePostsRead)
+	revoked := createAPIKey(t, h, "alice", jwt.ScopePostsRead)
+	h.APIKeys.Delete(ctx, "alice", revoked.ID)
+
+	authn := &jwt.Authenticator{Tokens: h.Tokens, APIKeys: h.APIKeys}
+	router := httprouter.New()
+	router.GET("/posts", middlewares.CheckJwt(authn, h.GetAllPosts, jwt.ScopePostsRead))
+	router.POST("/posts", middlewares.CheckJwt(authn, h.CreatePost, jwt.ScopePostsWrite))
+	router.GET("/me", middlewares.CheckJwt(authn, h.GetMe))
+
+	tests := []struct {
+		name           string
+		method, path   string
+		key            string
+		expectedStatus int
+	}{
+		{"Key with the scope", "GET", "/posts", readKey.Key, http.StatusOK},
+		{"Key without the scope", "POST", "/posts", readKey.Key, http.StatusForbidden},
+		{"Route for logins only", "GET", "/me", readKey.Key, http.StatusForbidden},
+		{"Revoked key", "GET", "/posts", revoked.Key, http.StatusUnauthorized},
+		{"Unknown key", "GET", "/posts", "wgk_unknown", http.StatusUnauthorized},
+		{"Not a key", "GET", "/posts", "Bearer token", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(`{"title": "From a script", "body": "text"}`))
+			req.Header.Set(jwt.APIKeyHeader, tt.key)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body)
+			}
+		})
+	}
+
+	// The use was recorded
+	stored, _ := h.APIKeys.FindByHash(ctx, jwt.HashAPIKey(readKey.Key))
+	if stored.LastUsedAt == nil {
+		t.Error("last use of the key was not recorded")
+	}
+}
+
+func TestAPIKeyActsAsPlainUser(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	h.Posts.Create(ctx, &models.Post{ID: "1", Author: "bob", Title: "Bob's post", Status: models.PostPublished})
+	key := createAPIKey(t, h, "admin", jwt.ScopePostsWrite)
+
+	authn := &jwt.Authenticator{Tokens: h.Tokens, APIKeys: h.APIKeys}
+	router := httprouter.New()
+	router.DELETE("/posts/:id", middlewares.CheckJwt(authn, h.DeletePost, jwt.ScopePostsWrite))
+
+	// Moderating needs a login, even for admins
+	req := httptest.NewRequest("DELETE", "/posts/1", nil)
+	req.Header.Set(jwt.APIKeyHeader, key.Key)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestDeleteMeRevokesAPIKeys(t *testing.T) {
+	h := newTestHandler()
+	testUserWithPassword(t, h, "alice", "password123")
+	key := createAPIKey(t, h, "alice", jwt.ScopePostsRead)
+
+	if rr := serveAs("DELETE", "/me", "/me", h.DeleteMe, "alice", DeleteMeRequest{Password: "password123"}); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned %v: %s", rr.Code, rr.Body)
+	}
+	if _, err := h.APIKeys.FindByHash(context.Background(), jwt.HashAPIKey(key.Key)); err == nil {
+		t.Error("API key of the deleted user still works")
+	}
+}
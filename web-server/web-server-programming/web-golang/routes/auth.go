@@ -2,177 +2,177 @@ package routes
 
 import (
 	"context"
-	"encoding/json"
+	"log"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/asaskevich/govalidator"
-	jwt "github.com/conglt10/web-golang/auth"
-	db "github.com/conglt10/web-golang/database"
-	"github.com/conglt10/web-golang/errors"
+	apierr "github.com/conglt10/web-golang/errors"
 	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
 	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
 	"github.com/julienschmidt/httprouter"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	uuid "github.com/satori/go.uuid"
 )
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
-func Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var req LoginRequest
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-
-	if err := decoder.Decode(&req); err != nil {
-		res.JSON(w, http.StatusBadRequest, map[string]string{
-			"error":   "Invalid request body",
-			"details": err.Error(),
-		})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
 
-	// Validate input
-	if strings.TrimSpace(req.Username) == "" {
-		res.JSON(w, http.StatusBadRequest, errors.ErrEmptyUsername)
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
 		return
 	}
-	if strings.TrimSpace(req.Password) == "" {
-		res.JSON(w, http.StatusBadRequest, errors.ErrEmptyPassword)
+
+	username := models.Normalize(req.Username)
+	ip := clientIP(r)
+
+	// Refused before any bcrypt work, so guessing costs the server nothing.
+	// The attempt counts as failed until the password is found right.
+	if wait := h.Logins.Attempt(username, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		res.Error(w, r, apierr.New(apierr.CodeTooManyRequests, "Too many failed login attempts, try again later"))
 		return
 	}
 
-	// Sanitize input
-	username := models.Santize(req.Username)
-	password := models.Santize(req.Password)
-
-	// Connect to database
-	collection := db.ConnectUsers()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var result bson.M
-	err := collection.FindOne(ctx, bson.M{"username": username}).Decode(&result)
-	if err == mongo.ErrNoDocuments {
-		res.JSON(w, http.StatusUnauthorized, "Username or Password incorrect")
+	user, err := h.Users.FindByUsername(ctx, username)
+	if err == repository.ErrNotFound {
+		// Takes as long as a wrong password
+		models.CheckUnknownPassword(req.Password, h.passwordCost())
+		res.Error(w, r, apierr.New(apierr.CodeInvalidCredentials, "Username or Password incorrect"))
 		return
 	} else if err != nil {
-		res.JSON(w, http.StatusInternalServerError, "Internal Server Error")
+		h.Logins.Release(username, ip)
+		res.Error(w, r, err)
 		return
 	}
 
 	// Verify password
-	hashedPassword, ok := result["password"].(string)
-	if !ok {
-		res.JSON(w, http.StatusInternalServerError, "Internal Server Error")
+	outdated, err := user.CheckPassword(req.Password, h.passwordCost())
+	if err != nil {
+		res.Error(w, r, apierr.New(apierr.CodeInvalidCredentials, "Username or Password incorrect"))
 		return
 	}
-
-	if err = models.CheckPasswordHash(hashedPassword, password); err != nil {
-		res.JSON(w, http.StatusUnauthorized, "Username or Password incorrect")
-		return
+	h.Logins.Succeeded(username, ip)
+	if outdated {
+		h.upgradePassword(ctx, user, req.Password)
 	}
 
-	// Generate JWT token
-	token, err := jwt.Create(username)
+	// Generate access and refresh tokens
+	tokens, err := h.issueTokens(ctx, user, "")
 	if err != nil {
-		res.JSON(w, http.StatusInternalServerError, "Failed to create token")
+		res.Error(w, r, err)
 		return
 	}
 
-	res.JSON(w, http.StatusOK, map[string]string{"token": token})
+	res.JSON(w, http.StatusOK, tokens)
 }
 
 type RegisterRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email    string `json:"email"`
+	Username string `json:"username" validate:"required,min=3,max=30,username"`
+	// bcrypt ignores everything after 72 bytes
+	Password string `json:"password" validate:"required,min=8,max=72,password"`
+	Email    string `json:"email" validate:"required,max=254,email"`
 }
 
-func Register(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var req RegisterRequest
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-
-	if err := decoder.Decode(&req); err != nil {
-		res.JSON(w, http.StatusBadRequest, map[string]string{
-			"error":   "Invalid request body",
-			"details": err.Error(),
-		})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
 
-	// Validate input
-	if strings.TrimSpace(req.Username) == "" {
-		res.JSON(w, http.StatusBadRequest, errors.ErrEmptyUsername)
-		return
-	}
-	if strings.TrimSpace(req.Password) == "" {
-		res.JSON(w, http.StatusBadRequest, errors.ErrEmptyPassword)
-		return
-	}
-	if strings.TrimSpace(req.Email) == "" {
-		res.JSON(w, http.StatusBadRequest, errors.ErrEmptyEmail)
-		return
-	}
-	if !govalidator.IsEmail(req.Email) {
-		res.JSON(w, http.StatusBadRequest, "Invalid email format")
+	// Checked as they will be stored, a decomposed accent is not a letter
+	req.Username = models.Normalize(req.Username)
+	req.Email = models.Normalize(req.Email)
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
 		return
 	}
+	username, email := req.Username, req.Email
 
-	// Sanitize input
-	username := models.Santize(req.Username)
-	email := models.Santize(req.Email)
-	password := models.Santize(req.Password)
-
-	// Connect to database
-	collection := db.ConnectUsers()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	// Check if user or email exists
-	var result bson.M
-	err := collection.FindOne(ctx, bson.M{"$or": []bson.M{
-		{"username": username},
-		{"email": email},
-	}}).Decode(&result)
-	if err == nil {
-		res.JSON(w, http.StatusConflict, "Username or email already exists")
-		return
-	} else if err != mongo.ErrNoDocuments {
-		res.JSON(w, http.StatusInternalServerError, "Internal Server Error")
+	exists, err := h.Users.ExistsByUsernameOrEmail(ctx, username, email)
+	if err != nil {
+		res.Error(w, r, err)
 		return
 	}
-
-	// Hash password
-	hashedPassword, err := models.Hash(password)
-	if err != nil {
-		res.JSON(w, http.StatusInternalServerError, "Failed to hash password")
+	if exists {
+		res.Error(w, r, apierr.Conflict("Username or email already exists"))
 		return
 	}
 
 	// Create new user
-	newUser := bson.M{
-		"username": username,
-		"email":    email,
-		"password": hashedPassword,
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	newUser := &models.User{
+		ID:        uuid.NewV4().String(),
+		Username:  username,
+		Email:     email,
+		Roles:     []string{models.RoleUser},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := newUser.SetPassword(req.Password, h.passwordCost()); err != nil {
+		res.Error(w, r, err)
+		return
 	}
 
-	_, err = collection.InsertOne(ctx, newUser)
-	if err != nil {
-		res.JSON(w, http.StatusInternalServerError, "Failed to register user")
+	err = h.Users.Create(ctx, newUser)
+	if err == repository.ErrConflict {
+		res.Error(w, r, apierr.Conflict("Username or email already exists"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
 		return
 	}
 
+	// The account works without it, the link can be sent again
+	h.background(func() {
+		// The request is answered by now, the mail gets its own deadline
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := h.sendVerification(ctx, newUser); err != nil {
+			log.Printf("Warning: Failed to send verification email to %s: %v", newUser.Username, err)
+		}
+	})
+
 	res.JSON(w, http.StatusCreated, "Registration successful")
 }
+
+// JWKS publishes the public keys access tokens can be verified with
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	res.JSON(w, http.StatusOK, h.Tokens.JWKS())
+}
+
+// upgradePassword rehashes the password of a user on an older scheme or cost.
+// The login already succeeded, a failure only means trying again next time.
+func (h *Handler) upgradePassword(ctx context.Context, user *models.User, password string) {
+	if err := user.SetPassword(password, h.passwordCost()); err != nil {
+		log.Printf("Warning: Failed to rehash password of %s: %v", user.Username, err)
+		return
+	}
+	if err := h.Users.UpdatePassword(ctx, user); err != nil {
+		log.Printf("Warning: Failed to store new password hash of %s: %v", user.Username, err)
+	}
+}
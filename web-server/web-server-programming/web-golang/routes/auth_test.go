@@ -4,36 +4,66 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"html"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
-	db "github.com/conglt10/web-golang/database"
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/mail"
+	mock "github.com/conglt10/web-golang/mocks"
 	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository/memory"
+	"github.com/conglt10/web-golang/validation"
 	"github.com/julienschmidt/httprouter"
-	"go.mongodb.org/mongo-driver/bson"
+	testifymock "github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// newTestHandler returns a Handler backed by empty in-memory repositories
+func newTestHandler() *Handler {
+	tokens, err := jwt.NewManager(jwt.Config{
+		Issuer:       "web-golang",
+		Audience:     "web-golang",
+		SigningKeyID: "test",
+		Keys:         []jwt.Key{jwt.NewHMACKey("test", []byte("test-secret"))},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &Handler{
+		Tokens:        tokens,
+		Users:         memory.NewUserRepository(),
+		Posts:         memory.NewPostRepository(),
+		Comments:      memory.NewCommentRepository(),
+		Likes:         memory.NewLikeRepository(),
+		RefreshTokens: memory.NewRefreshTokenRepository(),
+		UserTokens:    memory.NewUserTokenRepository(),
+		Identities:    memory.NewIdentityRepository(),
+		APIKeys:       memory.NewAPIKeyRepository(),
+		Mailer:        mail.NewLogMailer(io.Discard),
+		// Tests hash many passwords, the cost is checked by the rehash test
+		PasswordCost: bcrypt.MinCost,
+	}
+}
+
 func TestLogin(t *testing.T) {
 	// Thiết lập dữ liệu test
 	testUser := "testuser"
 	testPass := "testpass123"
-	hashedPassword, _ := models.Hash(testPass)
-
-	// Kết nối database và tạo user test
-	collection := db.ConnectUsers()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Xóa user test nếu tồn tại
-	collection.DeleteOne(ctx, bson.M{"username": testUser})
+	hashedPassword, _ := models.Hash(testPass, bcrypt.MinCost)
 
 	// Tạo user test mới
-	_, err := collection.InsertOne(ctx, bson.M{
-		"username": testUser,
-		"password": hashedPassword,
-		"email":    "test@example.com",
+	h := newTestHandler()
+	err := h.Users.Create(context.Background(), &models.User{
+		Username: testUser,
+		Password: hashedPassword,
+		Email:    "test@example.com",
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -93,7 +123,7 @@ func TestLogin(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			router := httprouter.New()
-			router.POST("/login", Login)
+			router.POST("/login", h.Login)
 			router.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
@@ -102,16 +132,207 @@ func TestLogin(t *testing.T) {
 			}
 		})
 	}
+}
+
+func TestLoginUpgradesEscapedPassword(t *testing.T) {
+	// Passwords used to be trimmed and HTML escaped before hashing
+	password := "p&ss<word>1"
+	legacyHash, err := models.Hash(html.EscapeString(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler()
+	ctx := context.Background()
+	if err := h.Users.Create(ctx, &models.User{Username: "legacy", Email: "legacy@example.com", Password: legacyHash}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password string) int {
+		jsonBody, _ := json.Marshal(LoginRequest{Username: "legacy", Password: password})
+		req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+		rr := httptest.NewRecorder()
+
+		router := httprouter.New()
+		router.POST("/login", h.Login)
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := login(password); status != http.StatusOK {
+		t.Fatalf("login with the old hash: got %v want %v", status, http.StatusOK)
+	}
+	user, err := h.Users.FindByUsername(ctx, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordScheme != models.PasswordNormalized || user.Password == legacyHash {
+		t.Fatalf("password was not rehashed: scheme %d", user.PasswordScheme)
+	}
+	if err := models.CheckPasswordHash(user.Password, password); err != nil {
+		t.Errorf("new hash is not of the raw password: %v", err)
+	}
 
-	// Cleanup
-	collection.DeleteOne(ctx, bson.M{"username": testUser})
+	if status := login(password); status != http.StatusOK {
+		t.Errorf("login after the upgrade: got %v want %v", status, http.StatusOK)
+	}
+	if status := login(html.EscapeString(password)); status != http.StatusUnauthorized {
+		t.Errorf("login with the escaped password: got %v want %v", status, http.StatusUnauthorized)
+	}
 }
 
-func TestRegister(t *testing.T) {
-	collection := db.ConnectUsers()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func TestRegisterStoresRawInput(t *testing.T) {
+	h := newTestHandler()
+	// "é" as e and a combining accent, stored composed
+	password := " <p&ss word>1 "
+	jsonBody, _ := json.Marshal(RegisterRequest{Username: "  cafe\u0301 ", Password: password, Email: "o'brien@example.com"})
+	req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.POST("/register", h.Register)
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	user, err := h.Users.FindByUsername(context.Background(), "caf\u00e9")
+	if err != nil {
+		t.Fatalf("user not stored under the NFC username: %v", err)
+	}
+	if user.Email != "o'brien@example.com" {
+		t.Errorf("email was altered: %q", user.Email)
+	}
+	if _, err := user.CheckPassword(password, bcrypt.MinCost); err != nil {
+		t.Errorf("password with spaces and HTML characters does not match: %v", err)
+	}
+	if _, err := user.CheckPassword(strings.TrimSpace(password), bcrypt.MinCost); err == nil {
+		t.Error("surrounding spaces are part of the password")
+	}
+}
+
+// postLogin sends a login from the address to the handler
+func postLogin(h *Handler, username, password, remoteAddr string) *httptest.ResponseRecorder {
+	jsonBody, _ := json.Marshal(LoginRequest{Username: username, Password: password})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.POST("/login", h.Login)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestLoginLockout(t *testing.T) {
+	h := newTestHandler()
+	h.Logins = jwt.NewLoginThrottle(
+		jwt.ThrottleConfig{LockoutAfter: 3, LockoutFor: time.Hour, ResetAfter: time.Hour},
+		jwt.DefaultIPThrottle,
+	)
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	if err := user.SetPassword("correct123", bcrypt.MinCost); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := postLogin(h, "alice", "wrong123", "10.0.0.1:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got %v want %v", i+1, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Locked from every address, even with the right password
+	rr := postLogin(h, "alice", "correct123", "10.0.0.2:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked login: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if retry := rr.Header().Get("Retry-After"); retry != "3600" {
+		t.Errorf("Retry-After = %q, want 3600", retry)
+	}
+
+	// Unknown usernames are counted the same way
+	for i := 0; i < 3; i++ {
+		postLogin(h, "nobody", "wrong123", "10.0.0.3:1234")
+	}
+	if rr := postLogin(h, "nobody", "wrong123", "10.0.0.3:1234"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("unknown user: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+
+	if rr := postLogin(h, "bob", "wrong123", "10.0.0.1:1234"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other users must not be locked: got %v", rr.Code)
+	}
+}
+
+func TestLoginSuccessResetsFailures(t *testing.T) {
+	h := newTestHandler()
+	h.Logins = jwt.NewLoginThrottle(
+		jwt.ThrottleConfig{LockoutAfter: 3, LockoutFor: time.Hour, ResetAfter: time.Hour},
+		jwt.DefaultIPThrottle,
+	)
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	if err := user.SetPassword("correct123", bcrypt.MinCost); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"wrong123", "wrong123", "correct123", "wrong123", "wrong123", "correct123"} {
+		rr := postLogin(h, "alice", password, "10.0.0.1:1234")
+		if rr.Code == http.StatusTooManyRequests {
+			t.Fatalf("locked although a success came in between")
+		}
+	}
+}
+
+func TestLoginRehashesOnCostChange(t *testing.T) {
+	h := newTestHandler()
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	if err := user.SetPassword("correct123", bcrypt.MinCost); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	newCost := bcrypt.MinCost + 1
+	h.PasswordCost = newCost
+
+	if rr := postLogin(h, "alice", "correct123", "10.0.0.1:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("login: got %v want %v", rr.Code, http.StatusOK)
+	}
+	stored, err := h.Users.FindByUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(stored.Password)); cost != newCost {
+		t.Errorf("cost after login = %d, want %d", cost, newCost)
+	}
+}
+
+func TestLoginRepositoryError(t *testing.T) {
+	users := new(mock.UserRepository)
+	users.On("FindByUsername", testifymock.Anything, "testuser").Return(nil, errors.New("connection refused"))
+	h := &Handler{Users: users}
+
+	jsonBody, _ := json.Marshal(LoginRequest{Username: "testuser", Password: "testpass123"})
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	rr := httptest.NewRecorder()
 
+	router := httprouter.New()
+	router.POST("/login", h.Login)
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusInternalServerError)
+	}
+	users.AssertExpectations(t)
+}
+
+func TestRegister(t *testing.T) {
 	tests := []struct {
 		name           string
 		requestBody    RegisterRequest
@@ -130,7 +351,7 @@ func TestRegister(t *testing.T) {
 			name: "Empty username",
 			requestBody: RegisterRequest{
 				Username: "",
-				Password: "pass123",
+				Password: "pass12345",
 				Email:    "test@example.com",
 			},
 			expectedStatus: http.StatusBadRequest,
@@ -148,7 +369,7 @@ func TestRegister(t *testing.T) {
 			name: "Empty email",
 			requestBody: RegisterRequest{
 				Username: "testuser",
-				Password: "pass123",
+				Password: "pass12345",
 				Email:    "",
 			},
 			expectedStatus: http.StatusBadRequest,
@@ -157,33 +378,149 @@ func TestRegister(t *testing.T) {
 			name: "Invalid email format",
 			requestBody: RegisterRequest{
 				Username: "testuser",
-				Password: "pass123",
+				Password: "pass12345",
 				Email:    "invalid-email",
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name: "Username too short",
+			requestBody: RegisterRequest{
+				Username: "ab",
+				Password: "pass12345",
+				Email:    "test@example.com",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Username with spaces",
+			requestBody: RegisterRequest{
+				Username: "test user",
+				Password: "pass12345",
+				Email:    "test@example.com",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Password too short",
+			requestBody: RegisterRequest{
+				Username: "testuser",
+				Password: "pass123",
+				Email:    "test@example.com",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Password without digits",
+			requestBody: RegisterRequest{
+				Username: "testuser",
+				Password: "password",
+				Email:    "test@example.com",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Xóa user test nếu tồn tại
-			collection.DeleteOne(ctx, bson.M{"username": tt.requestBody.Username})
+			h := newTestHandler()
 
 			jsonBody, _ := json.Marshal(tt.requestBody)
 			req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
 			rr := httptest.NewRecorder()
 
 			router := httprouter.New()
-			router.POST("/register", Register)
+			router.POST("/register", h.Register)
 			router.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
 				t.Errorf("handler returned wrong status code: got %v want %v",
 					status, tt.expectedStatus)
 			}
+		})
+	}
+}
+
+func TestRegisterReportsEveryFieldError(t *testing.T) {
+	h := newTestHandler()
 
-			// Cleanup sau mỗi test
-			collection.DeleteOne(ctx, bson.M{"username": tt.requestBody.Username})
+	jsonBody, _ := json.Marshal(RegisterRequest{Username: "a b", Password: "short", Email: "invalid-email"})
+	req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.POST("/register", h.Register)
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	var problem struct {
+		Code    apierr.Code             `json:"code"`
+		Details []validation.FieldError `json:"details"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Code != apierr.CodeValidation {
+		t.Errorf("wrong code: got %q want %q", problem.Code, apierr.CodeValidation)
+	}
+	var fields []string
+	for _, fe := range problem.Details {
+		fields = append(fields, fe.Field)
+	}
+	if got := strings.Join(fields, ","); got != "username,password,email" {
+		t.Errorf("wrong fields: got %s want username,password,email (%+v)", got, problem.Details)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	h := newTestHandler()
+	err := h.Users.Create(context.Background(), &models.User{
+		Username: "existing",
+		Password: "hashed",
+		Email:    "existing@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		requestBody RegisterRequest
+	}{
+		{
+			name: "Duplicate username",
+			requestBody: RegisterRequest{
+				Username: "existing",
+				Password: "pass12345",
+				Email:    "other@example.com",
+			},
+		},
+		{
+			name: "Duplicate email",
+			requestBody: RegisterRequest{
+				Username: "other",
+				Password: "pass12345",
+				Email:    "existing@example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(tt.requestBody)
+			req := httptest.NewRequest("POST", "/register", bytes.NewBuffer(jsonBody))
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.POST("/register", h.Register)
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusConflict {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusConflict)
+			}
 		})
 	}
 }
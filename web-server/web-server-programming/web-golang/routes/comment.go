@@ -0,0 +1,235 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+type CreateCommentRequest struct {
+	Body string `json:"body" validate:"required,max=5000"`
+	// ParentID answers a top level comment, replies cannot be answered
+	ParentID string `json:"parent_id"`
+}
+
+type EditCommentRequest struct {
+	Body string `json:"body" validate:"required,max=5000"`
+}
+
+// CommentThread is a top level comment with its replies, oldest first
+type CommentThread struct {
+	models.Comment
+	Replies []models.Comment `json:"replies"`
+}
+
+// ListComments returns the comments on a post as threads
+func (h *Handler) ListComments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	post, err := h.findVisiblePost(ctx, principal, ps.ByName("id"))
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	comments, err := h.Comments.FindByPost(ctx, post.ID)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, threads(comments))
+}
+
+// threads groups replies under their parent. Comments must be sorted oldest
+// first so every parent comes before its replies.
+func threads(comments []models.Comment) []CommentThread {
+	result := []CommentThread{}
+	index := make(map[string]int)
+	for _, c := range comments {
+		if !c.IsReply() {
+			index[c.ID] = len(result)
+			result = append(result, CommentThread{Comment: c, Replies: []models.Comment{}})
+			continue
+		}
+		if i, ok := index[c.ParentID]; ok {
+			result[i].Replies = append(result[i].Replies, c)
+		}
+	}
+	return result
+}
+
+func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateCommentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	body := models.Normalize(req.Body)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	post, err := h.findVisiblePost(ctx, principal, ps.ByName("id"))
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	if req.ParentID != "" {
+		parent, err := h.Comments.FindByID(ctx, req.ParentID)
+		if err == repository.ErrNotFound || (err == nil && parent.PostID != post.ID) {
+			res.Error(w, r, apierr.BadRequest("Parent comment not found"))
+			return
+		} else if err != nil {
+			res.Error(w, r, err)
+			return
+		}
+		if parent.IsReply() {
+			res.Error(w, r, apierr.BadRequest("Replies cannot be answered"))
+			return
+		}
+	}
+
+	author, err := h.Users.FindByUsername(ctx, principal.Username)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.Unauthorized("User no longer exists"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	comment := &models.Comment{
+		ID:        uuid.NewV4().String(),
+		PostID:    post.ID,
+		ParentID:  req.ParentID,
+		AuthorID:  author.ID,
+		Author:    author.Username,
+		Body:      body,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := h.Comments.Create(ctx, comment); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusCreated, comment)
+}
+
+// EditComment changes the body of a comment. Only its author may edit it.
+func (h *Handler) EditComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req EditCommentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	body := models.Normalize(req.Body)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	comment, err := h.findComment(ctx, principal, ps)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if comment.Author != principal.Username {
+		res.Error(w, r, apierr.Forbidden("Permission denied"))
+		return
+	}
+
+	comment.Body = body
+	comment.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if err := h.Comments.Update(ctx, comment); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, comment)
+}
+
+// DeleteComment deletes a comment and its replies. Moderators may delete
+// any comment.
+func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	comment, err := h.findComment(ctx, principal, ps)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	owner := comment.Author == principal.Username
+	if !owner && !principal.Can(jwt.PermDeleteAnyComment) {
+		res.Error(w, r, apierr.Forbidden("Permission denied"))
+		return
+	}
+
+	if err := h.Comments.Delete(ctx, comment.ID); err != nil && err != repository.ErrNotFound {
+		res.Error(w, r, err)
+		return
+	}
+	if !owner {
+		h.audit(r, principal, "comment.delete", comment.ID, map[string]interface{}{
+			"author":  comment.Author,
+			"post_id": comment.PostID,
+		})
+	}
+
+	res.JSON(w, http.StatusOK, "Delete Successfully")
+}
+
+// findComment looks up the comment of the :comment_id parameter on a post
+// the caller may read
+func (h *Handler) findComment(ctx context.Context, principal *jwt.Principal, ps httprouter.Params) (*models.Comment, error) {
+	post, err := h.findVisiblePost(ctx, principal, ps.ByName("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := h.Comments.FindByID(ctx, ps.ByName("comment_id"))
+	if err == repository.ErrNotFound || (err == nil && comment.PostID != post.ID) {
+		return nil, apierr.NotFound("Comment not found")
+	}
+	return comment, err
+}
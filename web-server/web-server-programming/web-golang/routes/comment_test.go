@@ -0,0 +1,264 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/conglt10/web-golang/middlewares"
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// newCommentTestHandler tạo handler với một bài đăng, một bản nháp của
+// otheruser, một bình luận và một trả lời
+func newCommentTestHandler(t *testing.T) *Handler {
+	h := newTestHandler()
+	ctx := context.Background()
+	for _, username := range []string{"testuser", "otheruser"} {
+		testUserNamed(t, h, username)
+	}
+	for _, p := range []models.Post{
+		{ID: "post-1", Author: "testuser", Title: "Bài đăng", Status: models.PostPublished},
+		{ID: "post-2", Author: "testuser", Title: "Bài đăng khác", Status: models.PostPublished},
+		{ID: "draft", Author: "otheruser", Title: "Bản nháp", Status: models.PostDraft},
+	} {
+		p := p
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, c := range []models.Comment{
+		{ID: "comment-1", PostID: "post-1", Author: "otheruser", Body: "Bình luận"},
+		{ID: "reply-1", PostID: "post-1", ParentID: "comment-1", Author: "testuser", Body: "Trả lời"},
+	} {
+		c := c
+		c.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := h.Comments.Create(ctx, &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+func TestCreateComment(t *testing.T) {
+	h := newCommentTestHandler(t)
+	validToken, _ := h.Tokens.Create("testuser", []string{models.RoleUser})
+
+	tests := []struct {
+		name           string
+		postID         string
+		requestBody    map[string]interface{}
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "Bình luận thành công",
+			postID:         "post-1",
+			requestBody:    map[string]interface{}{"body": "Hay quá"},
+			token:          validToken,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Trả lời bình luận",
+			postID:         "post-1",
+			requestBody:    map[string]interface{}{"body": "Cảm ơn", "parent_id": "comment-1"},
+			token:          validToken,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Không được trả lời một trả lời",
+			postID:         "post-1",
+			requestBody:    map[string]interface{}{"body": "Sâu quá", "parent_id": "reply-1"},
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bình luận cha thuộc bài đăng khác",
+			postID:         "post-2",
+			requestBody:    map[string]interface{}{"body": "Nhầm bài", "parent_id": "comment-1"},
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bình luận rỗng",
+			postID:         "post-1",
+			requestBody:    map[string]interface{}{"body": "   "},
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Bản nháp của người khác",
+			postID:         "draft",
+			requestBody:    map[string]interface{}{"body": "Xin chào"},
+			token:          validToken,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Không có token",
+			postID:         "post-1",
+			requestBody:    map[string]interface{}{"body": "Xin chào"},
+			token:          "",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(tt.requestBody)
+			req := httptest.NewRequest("POST", "/posts/"+tt.postID+"/comments", bytes.NewBuffer(jsonBody))
+
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.POST("/posts/:id/comments", middlewares.CheckJwt(h.Tokens, h.CreateComment))
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler trả về status code không đúng cho test case '%s': nhận được %v muốn %v",
+					tt.name, status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestListComments(t *testing.T) {
+	h := newCommentTestHandler(t)
+
+	req := asUser(httptest.NewRequest("GET", "/posts/post-1/comments", nil), "testuser")
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.GET("/posts/:id/comments", h.ListComments)
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v", rr.Code, http.StatusOK)
+	}
+	var threads []CommentThread
+	if err := json.NewDecoder(rr.Body).Decode(&threads); err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 || threads[0].ID != "comment-1" || len(threads[0].Replies) != 1 || threads[0].Replies[0].ID != "reply-1" {
+		t.Errorf("bình luận không đúng: %+v", threads)
+	}
+}
+
+func TestEditComment(t *testing.T) {
+	h := newCommentTestHandler(t)
+	ownerToken, _ := h.Tokens.Create("otheruser", []string{models.RoleUser})
+	postAuthorToken, _ := h.Tokens.Create("testuser", []string{models.RoleUser})
+	moderatorToken, _ := h.Tokens.Create("moderator", []string{models.RoleModerator})
+
+	tests := []struct {
+		name           string
+		target         string
+		token          string
+		expectedStatus int
+	}{
+		{name: "Tác giả bài đăng không được sửa", target: "/posts/post-1/comments/comment-1", token: postAuthorToken, expectedStatus: http.StatusForbidden},
+		{name: "Moderator không được sửa", target: "/posts/post-1/comments/comment-1", token: moderatorToken, expectedStatus: http.StatusForbidden},
+		{name: "Sửa bình luận thành công", target: "/posts/post-1/comments/comment-1", token: ownerToken, expectedStatus: http.StatusOK},
+		{name: "Bình luận thuộc bài đăng khác", target: "/posts/post-2/comments/comment-1", token: ownerToken, expectedStatus: http.StatusNotFound},
+		{name: "Bình luận không tồn tại", target: "/posts/post-1/comments/non-existent-id", token: ownerToken, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(EditCommentRequest{Body: "Đã sửa"})
+			req := httptest.NewRequest("PUT", tt.target, bytes.NewBuffer(jsonBody))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.PUT("/posts/:id/comments/:comment_id", middlewares.CheckJwt(h.Tokens, h.EditComment))
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler trả về status code không đúng: nhận được %v muốn %v", status, tt.expectedStatus)
+			}
+		})
+	}
+
+	comment, err := h.Comments.FindByID(context.Background(), "comment-1")
+	if err != nil || comment.Body != "Đã sửa" {
+		t.Errorf("bình luận sau khi sửa = %+v, %v", comment, err)
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	h := newCommentTestHandler(t)
+	recorder := &auditRecorder{}
+	h.Audit = recorder
+	userToken, _ := h.Tokens.Create("testuser", []string{models.RoleUser})
+	moderatorToken, _ := h.Tokens.Create("moderator", []string{models.RoleModerator})
+
+	tests := []struct {
+		name           string
+		target         string
+		token          string
+		expectedStatus int
+	}{
+		{name: "Người khác không được xóa", target: "/posts/post-1/comments/comment-1", token: userToken, expectedStatus: http.StatusForbidden},
+		{name: "Moderator xóa bình luận", target: "/posts/post-1/comments/comment-1", token: moderatorToken, expectedStatus: http.StatusOK},
+		{name: "Bình luận đã bị xóa", target: "/posts/post-1/comments/comment-1", token: moderatorToken, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("DELETE", tt.target, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.DELETE("/posts/:id/comments/:comment_id", middlewares.CheckJwt(h.Tokens, h.DeleteComment))
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler trả về status code không đúng: nhận được %v muốn %v", status, tt.expectedStatus)
+			}
+		})
+	}
+
+	// Trả lời bị xóa cùng bình luận cha
+	comments, err := h.Comments.FindByPost(context.Background(), "post-1")
+	if err != nil || len(comments) != 0 {
+		t.Errorf("còn lại %d bình luận, %v", len(comments), err)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].Action != "comment.delete" {
+		t.Errorf("audit log không đúng: %+v", recorder.events)
+	}
+}
+
+func TestDeletePostDeletesCommentsAndLikes(t *testing.T) {
+	h := newCommentTestHandler(t)
+	ctx := context.Background()
+	if err := h.Likes.Like(ctx, "post-1", "otheruser"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := asUser(httptest.NewRequest("DELETE", "/posts/post-1", nil), "testuser")
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.DELETE("/posts/:id", h.DeletePost)
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v", rr.Code, http.StatusOK)
+	}
+	comments, _ := h.Comments.FindByPost(ctx, "post-1")
+	counts, _ := h.Likes.Count(ctx, []string{"post-1"})
+	if len(comments) != 0 || counts["post-1"] != 0 {
+		t.Errorf("còn lại %d bình luận và %d lượt thích", len(comments), counts["post-1"])
+	}
+}
@@ -0,0 +1,276 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/mail"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	verifyEmailText = `Hi %s,
+
+Confirm your email address by opening this link within 24 hours:
+
+%s
+
+If you did not create an account, you can ignore this email.
+`
+	resetPasswordText = `Hi %s,
+
+Choose a new password by opening this link within an hour:
+
+%s
+
+If you did not ask for a password reset, you can ignore this email, your
+password has not been changed.
+`
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72,password"`
+}
+
+// VerifyEmail confirms the address with the token mailed on registration
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req VerifyEmailRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := h.consumeUserToken(ctx, req.Token, models.TokenVerifyEmail)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	// The token is for the address it was sent to, not one set since
+	err = h.Users.SetEmailVerified(ctx, token.Username, token.Email)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.BadRequest("Link is invalid or has expired"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, "Email verified")
+}
+
+// ResendVerification mails a new verification link to the caller, the
+// links sent before stop working
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByUsername(ctx, principal.Username)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.Unauthorized("User no longer exists"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if user.EmailVerified {
+		res.Error(w, r, apierr.Conflict("Email is already verified"))
+		return
+	}
+
+	if err := h.sendVerification(ctx, user); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusAccepted, "Verification email sent")
+}
+
+// ForgotPassword mails a password reset link. The response is the same
+// whether or not the email is registered, and the mail is sent in the
+// background so the response time does not tell either.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ForgotPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Email = models.Normalize(req.Email)
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByEmail(ctx, req.Email)
+	switch {
+	case err == repository.ErrNotFound:
+	case err != nil:
+		res.Error(w, r, err)
+		return
+	default:
+		h.background(func() {
+			// The request is answered by now, the mail gets its own deadline
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			err := h.mailUserToken(ctx, user, models.TokenResetPassword, jwt.ResetPasswordTTL,
+				"/reset-password", "Reset your password", resetPasswordText)
+			if err != nil {
+				log.Printf("Warning: Failed to send password reset to %s: %v", user.Username, err)
+			}
+		})
+	}
+
+	res.JSON(w, http.StatusAccepted, "If the email is registered, a reset link has been sent")
+}
+
+// ResetPassword sets a new password with the token of a reset link. Every
+// session and API key of the user is revoked, whoever had the old password
+// may have made them.
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ResetPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := h.consumeUserToken(ctx, req.Token, models.TokenResetPassword)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	user, err := h.Users.FindByUsername(ctx, token.Username)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.BadRequest("Link is invalid or has expired"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	// Like in VerifyEmail, the link only works for the address it was sent to
+	if token.Email != user.Email {
+		res.Error(w, r, apierr.BadRequest("Link is invalid or has expired"))
+		return
+	}
+
+	if err := user.SetPassword(req.Password, h.passwordCost()); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.Users.UpdatePassword(ctx, user); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, user.Username); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.APIKeys.DeleteForUser(ctx, user.Username); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	h.Logins.Reset(user.Username)
+	// Following the link proved the address is theirs
+	if err := h.Users.SetEmailVerified(ctx, user.Username, token.Email); err != nil && err != repository.ErrNotFound {
+		log.Printf("Warning: Failed to verify email of %s: %v", user.Username, err)
+	}
+
+	res.JSON(w, http.StatusOK, "Password has been reset")
+}
+
+// sendVerification mails a link that verifies the user's email
+func (h *Handler) sendVerification(ctx context.Context, user *models.User) error {
+	return h.mailUserToken(ctx, user, models.TokenVerifyEmail, jwt.VerifyEmailTTL,
+		"/verify-email", "Confirm your email address", verifyEmailText)
+}
+
+// mailUserToken replaces the user's tokens for the purpose with a new one
+// and mails the link to path carrying it. text is formatted with the
+// username and the link.
+func (h *Handler) mailUserToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration, path, subject, text string) error {
+	token, hash, err := jwt.NewUserToken()
+	if err != nil {
+		return err
+	}
+	if err := h.UserTokens.DeleteForUser(ctx, user.Username, purpose); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err = h.UserTokens.Create(ctx, &models.UserToken{
+		ID:        uuid.NewV4().String(),
+		Username:  user.Username,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: hash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(h.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(text, user.Username, link),
+	})
+}
+
+// consumeUserToken uses up the token, or returns a 400 when it is unknown,
+// used or expired
+func (h *Handler) consumeUserToken(ctx context.Context, token, purpose string) (*models.UserToken, error) {
+	t, err := h.UserTokens.Consume(ctx, jwt.HashUserToken(token), purpose, time.Now().UTC())
+	if err == repository.ErrNotFound {
+		return nil, apierr.BadRequest("Link is invalid or has expired")
+	}
+	return t, err
+}
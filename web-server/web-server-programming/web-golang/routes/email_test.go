@@ -0,0 +1,293 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	"github.com/conglt10/web-golang/mail"
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+var tokenLink = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastMailedToken returns the token in the last link written to the mailbox
+func lastMailedToken(t *testing.T, mailbox *bytes.Buffer) string {
+	matches := tokenLink.FindAllStringSubmatch(mailbox.String(), -1)
+	if len(matches) == 0 {
+		t.Fatalf("no link was mailed:\n%s", mailbox.String())
+	}
+	token, err := url.QueryUnescape(matches[len(matches)-1][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// postJSON sends body to the handler mounted at path
+func postJSON(path string, handle httprouter.Handle, body interface{}) *httptest.ResponseRecorder {
+	jsonBody, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.POST(path, handle)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestVerifyEmail(t *testing.T) {
+	h := newTestHandler()
+	var mailbox bytes.Buffer
+	h.Mailer = mail.NewLogMailer(&mailbox)
+	h.AppURL = "https://example.com/"
+
+	rr := postJSON("/register", h.Register, RegisterRequest{
+		Username: "alice",
+		Password: "password123",
+		Email:    "alice@example.com",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("register returned %v: %s", rr.Code, rr.Body)
+	}
+	h.Wait()
+	if !bytes.Contains(mailbox.Bytes(), []byte("To: alice@example.com\r\n")) ||
+		!bytes.Contains(mailbox.Bytes(), []byte("https://example.com/verify-email?token=")) {
+		t.Fatalf("verification email not sent:\n%s", mailbox.String())
+	}
+	token := lastMailedToken(t, &mailbox)
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+		verified       bool
+	}{
+		{"Unknown token", "not-a-token", http.StatusBadRequest, false},
+		{"Valid token", token, http.StatusOK, true},
+		{"Token is single use", token, http.StatusBadRequest, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postJSON("/auth/verify-email", h.VerifyEmail, VerifyEmailRequest{Token: tt.token})
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+			user, _ := h.Users.FindByUsername(context.Background(), "alice")
+			if user.EmailVerified != tt.verified {
+				t.Errorf("email verified = %v, want %v", user.EmailVerified, tt.verified)
+			}
+		})
+	}
+}
+
+// storeUserToken saves a token for the user and returns it
+func storeUserToken(t *testing.T, h *Handler, purpose, email string, expiresAt time.Time) string {
+	token, hash, err := jwt.NewUserToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.UserTokens.Create(context.Background(), &models.UserToken{
+		ID:        uuid.NewV4().String(),
+		Username:  "alice",
+		Purpose:   purpose,
+		Email:     email,
+		TokenHash: hash,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyEmailRejectedTokens(t *testing.T) {
+	h := newTestHandler()
+	user := testUserNamed(t, h, "alice")
+	later := time.Now().UTC().Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Expired token", storeUserToken(t, h, models.TokenVerifyEmail, user.Email, time.Now().UTC().Add(-time.Minute))},
+		// The token proves the address it was sent to, not the one stored now
+		{"Token for an old email", storeUserToken(t, h, models.TokenVerifyEmail, "old@example.com", later)},
+		{"Reset token", storeUserToken(t, h, models.TokenResetPassword, user.Email, later)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postJSON("/auth/verify-email", h.VerifyEmail, VerifyEmailRequest{Token: tt.token})
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusBadRequest)
+			}
+		})
+	}
+
+	if user, _ := h.Users.FindByUsername(context.Background(), "alice"); user.EmailVerified {
+		t.Error("email was verified")
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	h := newTestHandler()
+	var mailbox bytes.Buffer
+	h.Mailer = mail.NewLogMailer(&mailbox)
+	testUserNamed(t, h, "alice")
+
+	resend := func() *httptest.ResponseRecorder {
+		req := asUser(httptest.NewRequest("POST", "/auth/verify-email/resend", nil), "alice")
+		rr := httptest.NewRecorder()
+		h.ResendVerification(rr, req, nil)
+		return rr
+	}
+
+	if rr := resend(); rr.Code != http.StatusAccepted {
+		t.Fatalf("resend returned %v: %s", rr.Code, rr.Body)
+	}
+	first := lastMailedToken(t, &mailbox)
+	if rr := resend(); rr.Code != http.StatusAccepted {
+		t.Fatalf("second resend returned %v: %s", rr.Code, rr.Body)
+	}
+	second := lastMailedToken(t, &mailbox)
+
+	if rr := postJSON("/auth/verify-email", h.VerifyEmail, VerifyEmailRequest{Token: first}); rr.Code != http.StatusBadRequest {
+		t.Errorf("replaced token returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := postJSON("/auth/verify-email", h.VerifyEmail, VerifyEmailRequest{Token: second}); rr.Code != http.StatusOK {
+		t.Errorf("latest token returned %v, want %v", rr.Code, http.StatusOK)
+	}
+	if rr := resend(); rr.Code != http.StatusConflict {
+		t.Errorf("resend when verified returned %v, want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	h := newTestHandler()
+	var mailbox bytes.Buffer
+	h.Mailer = mail.NewLogMailer(&mailbox)
+	testUserNamed(t, h, "alice")
+
+	known := postJSON("/auth/forgot-password", h.ForgotPassword, ForgotPasswordRequest{Email: "alice@example.com"})
+	h.Wait()
+	sent := mailbox.Len()
+	unknown := postJSON("/auth/forgot-password", h.ForgotPassword, ForgotPasswordRequest{Email: "nobody@example.com"})
+	h.Wait()
+
+	if known.Code != http.StatusAccepted || unknown.Code != http.StatusAccepted {
+		t.Errorf("got %v and %v, want %v for both", known.Code, unknown.Code, http.StatusAccepted)
+	}
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("responses differ: %s and %s", known.Body, unknown.Body)
+	}
+	if sent == 0 || mailbox.Len() != sent {
+		t.Errorf("mailbox = %q, want one reset email", mailbox.String())
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	h := newTestHandler()
+	var mailbox bytes.Buffer
+	h.Mailer = mail.NewLogMailer(&mailbox)
+	ctx := context.Background()
+
+	hash, _ := models.Hash("oldpassword1", h.passwordCost())
+	if err := h.Users.Create(ctx, &models.User{Username: "alice", Email: "alice@example.com", Password: hash}); err != nil {
+		t.Fatal(err)
+	}
+	session, err := h.issueTokens(ctx, testUserNamed(t, h, "alice"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.APIKeys.Create(ctx, &models.APIKey{ID: "key", Username: "alice", Name: "script", KeyHash: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := postJSON("/auth/forgot-password", h.ForgotPassword, ForgotPasswordRequest{Email: " alice@example.com "}); rr.Code != http.StatusAccepted {
+		t.Fatalf("forgot password returned %v: %s", rr.Code, rr.Body)
+	}
+	h.Wait()
+	token := lastMailedToken(t, &mailbox)
+
+	tests := []struct {
+		name           string
+		request        ResetPasswordRequest
+		expectedStatus int
+	}{
+		{"Weak password", ResetPasswordRequest{Token: token, Password: "short"}, http.StatusBadRequest},
+		{"Unknown token", ResetPasswordRequest{Token: "not-a-token", Password: "newpassword1"}, http.StatusBadRequest},
+		{"Valid token", ResetPasswordRequest{Token: token, Password: "newpassword1"}, http.StatusOK},
+		{"Token is single use", ResetPasswordRequest{Token: token, Password: "otherpassword1"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postJSON("/auth/reset-password", h.ResetPassword, tt.request)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+		})
+	}
+
+	if rr := postLogin(h, "alice", "oldpassword1", "192.0.2.1:1234"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("old password returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := postLogin(h, "alice", "newpassword1", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("new password returned %v, want %v", rr.Code, http.StatusOK)
+	}
+	if rr := postRefreshToken("/refresh", h.Refresh, session.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("session from before the reset returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if keys, err := h.APIKeys.ListForUser(ctx, "alice"); err != nil || len(keys) != 0 {
+		t.Errorf("API keys after the reset = %v, %v", keys, err)
+	}
+	if user, _ := h.Users.FindByUsername(ctx, "alice"); !user.EmailVerified {
+		t.Error("reset link did not verify the email")
+	}
+}
+
+func TestResetPasswordAfterEmailChange(t *testing.T) {
+	h := newTestHandler()
+	var mailbox bytes.Buffer
+	h.Mailer = mail.NewLogMailer(&mailbox)
+	ctx := context.Background()
+	alice := testUserWithPassword(t, h, "alice", "oldpassword1")
+
+	if rr := postJSON("/auth/forgot-password", h.ForgotPassword, ForgotPasswordRequest{Email: "alice@example.com"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("forgot password returned %v: %s", rr.Code, rr.Body)
+	}
+	h.Wait()
+	token := lastMailedToken(t, &mailbox)
+
+	// Changed without UpdateMe, which would also delete the token
+	alice.Email = "alice@example.org"
+	if err := h.Users.UpdateProfile(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postJSON("/auth/reset-password", h.ResetPassword, ResetPasswordRequest{Token: token, Password: "newpassword1"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("reset with a link to the old address returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := postLogin(h, "alice", "oldpassword1", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("old password returned %v, want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/conglt10/web-golang/audit"
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/mail"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+)
+
+// Handler serves the HTTP routes using the injected token manager and
+// repositories
+type Handler struct {
+	Tokens *jwt.Manager
+
+	Users repository.UserRepository
+	Posts repository.PostRepository
+	// Comments and Likes must be set together with Posts
+	Comments repository.CommentRepository
+	Likes    repository.LikeRepository
+	// RefreshTokens stores login sessions for /auth/refresh and /auth/logout
+	RefreshTokens repository.RefreshTokenRepository
+	// UserTokens stores the tokens of email verification and password reset
+	// links, sent with Mailer
+	UserTokens repository.UserTokenRepository
+	Mailer     mail.Mailer
+	// AppURL is where the links in emails point to, the token is appended
+	// as a query parameter
+	AppURL string
+	// Identities links the accounts of the OIDC login providers to users
+	Identities repository.IdentityRepository
+	// OIDC holds the login providers by name, it may be empty
+	OIDC map[string]*jwt.OIDCProvider
+	// APIKeys stores the API keys users create for scripts
+	APIKeys repository.APIKeyRepository
+	// Audit records privileged actions, it may be nil
+	Audit audit.Logger
+	// Logins slows down password guessing, nil turns it off
+	Logins *jwt.LoginThrottle
+	// PasswordCost is the bcrypt cost of new password hashes, zero means
+	// models.DefaultPasswordCost. Hashes of another cost are replaced on
+	// login.
+	PasswordCost int
+
+	// tasks tracks work that outlives its request, such as sending mail
+	tasks sync.WaitGroup
+}
+
+// Wait blocks until the work started in the background by requests is done.
+// Call it after the server stopped and before the repositories are closed.
+func (h *Handler) Wait() {
+	h.tasks.Wait()
+}
+
+// background runs f in its own goroutine, tracked by Wait
+func (h *Handler) background(f func()) {
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		f()
+	}()
+}
+
+// passwordCost returns the bcrypt cost of new password hashes
+func (h *Handler) passwordCost() int {
+	if h.PasswordCost == 0 {
+		return models.DefaultPasswordCost
+	}
+	return h.PasswordCost
+}
+
+// currentUser returns the caller stored by middlewares.CheckJwt. It writes a
+// 401 and returns false when the handler was reached without it.
+func currentUser(w http.ResponseWriter, r *http.Request) (*jwt.Principal, bool) {
+	principal, ok := jwt.PrincipalFromContext(r.Context())
+	if !ok {
+		res.Error(w, r, apierr.Unauthorized("Unauthorized"))
+	}
+	return principal, ok
+}
+
+// audit records a privileged action taken by the caller
+func (h *Handler) audit(r *http.Request, principal *jwt.Principal, action, target string, details map[string]interface{}) {
+	if h.Audit == nil {
+		return
+	}
+	h.Audit.Record(r.Context(), audit.Event{
+		Actor:   principal.Username,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	})
+}
+
+// decodeJSON decodes the request body into v, rejecting unknown fields. It
+// writes a 400 and returns false when the body is invalid.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		// Bodies over the limit of middlewares.MaxBodySize
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			res.Error(w, r, err)
+			return false
+		}
+		res.Error(w, r, apierr.BadRequest("Invalid request body").WithDetails(err.Error()))
+		return false
+	}
+	return true
+}
+
+// clientIP is the address the request came from. Proxy headers are not
+// trusted, they are set by the client unless a proxy overwrites them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/julienschmidt/httprouter"
+)
+
+// LikeResponse is returned by PUT and DELETE /posts/:id/like
+type LikeResponse struct {
+	Liked     bool  `json:"liked"`
+	LikeCount int64 `json:"like_count"`
+}
+
+// LikePost likes a post for the caller. Liking it again changes nothing.
+func (h *Handler) LikePost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.setLike(w, r, ps, true)
+}
+
+// UnlikePost removes the caller's like. Unliking a post that is not liked
+// changes nothing.
+func (h *Handler) UnlikePost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.setLike(w, r, ps, false)
+}
+
+func (h *Handler) setLike(w http.ResponseWriter, r *http.Request, ps httprouter.Params, liked bool) {
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	post, err := h.findVisiblePost(ctx, principal, ps.ByName("id"))
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	if liked {
+		err = h.Likes.Like(ctx, post.ID, principal.Username)
+	} else {
+		err = h.Likes.Unlike(ctx, post.ID, principal.Username)
+	}
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	count, err := h.likeCount(ctx, post.ID)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, LikeResponse{Liked: liked, LikeCount: count})
+}
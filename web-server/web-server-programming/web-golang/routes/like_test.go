@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestLikePost(t *testing.T) {
+	h := newCommentTestHandler(t)
+
+	router := httprouter.New()
+	router.PUT("/posts/:id/like", h.LikePost)
+	router.DELETE("/posts/:id/like", h.UnlikePost)
+	router.GET("/posts/:id", h.GetPost)
+
+	// Thích hai lần vẫn chỉ tính một lượt
+	tests := []struct {
+		name           string
+		method         string
+		postID         string
+		user           string
+		expectedStatus int
+		expected       LikeResponse
+	}{
+		{name: "Thích bài đăng", method: "PUT", postID: "post-1", user: "testuser", expectedStatus: http.StatusOK, expected: LikeResponse{Liked: true, LikeCount: 1}},
+		{name: "Thích lại không đổi", method: "PUT", postID: "post-1", user: "testuser", expectedStatus: http.StatusOK, expected: LikeResponse{Liked: true, LikeCount: 1}},
+		{name: "Người khác thích", method: "PUT", postID: "post-1", user: "otheruser", expectedStatus: http.StatusOK, expected: LikeResponse{Liked: true, LikeCount: 2}},
+		{name: "Bỏ thích", method: "DELETE", postID: "post-1", user: "testuser", expectedStatus: http.StatusOK, expected: LikeResponse{Liked: false, LikeCount: 1}},
+		{name: "Bỏ thích lại không đổi", method: "DELETE", postID: "post-1", user: "testuser", expectedStatus: http.StatusOK, expected: LikeResponse{Liked: false, LikeCount: 1}},
+		{name: "Bản nháp của người khác", method: "PUT", postID: "draft", user: "testuser", expectedStatus: http.StatusNotFound},
+		{name: "Bài đăng không tồn tại", method: "PUT", postID: "non-existent-id", user: "testuser", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(httptest.NewRequest(tt.method, "/posts/"+tt.postID+"/like", nil), tt.user)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v", rr.Code, tt.expectedStatus)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var response LikeResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response != tt.expected {
+				t.Errorf("nhận được %+v muốn %+v", response, tt.expected)
+			}
+		})
+	}
+
+	// Số lượt thích được trả về cùng bài đăng
+	req := asUser(httptest.NewRequest("GET", "/posts/post-1", nil), "testuser")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var view PostView
+	if err := json.NewDecoder(rr.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if view.LikeCount != 1 {
+		t.Errorf("like_count = %d, muốn 1", view.LikeCount)
+	}
+}
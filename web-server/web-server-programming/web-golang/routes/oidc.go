@@ -0,0 +1,297 @@
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+// oidcCookie keeps the state, nonce and code verifier of a login at a
+// provider until its callback. It is only sent to the provider's routes.
+const oidcCookie = "oidc_login"
+
+// OIDCLogin sends the user to sign in at the provider
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	provider, ok := h.oidcProvider(w, r, ps)
+	if !ok {
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := jwt.NewOIDCSecret()
+		if err != nil {
+			res.Error(w, r, err)
+			return
+		}
+		secrets[i] = secret
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		log.Printf("Warning: OIDC provider %s: %v", provider.Name(), err)
+		res.Error(w, r, apierr.New(apierr.CodeUnavailable, "Login provider is unavailable"))
+		return
+	}
+
+	h.setOIDCCookie(w, r, provider.Name(), strings.Join(secrets[:], "."), int(jwt.OIDCLoginTTL/time.Second))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes a login at the provider. The external identity is
+// linked to a local user, created on the first login, and the caller gets
+// the same tokens as a password login.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	provider, ok := h.oidcProvider(w, r, ps)
+	if !ok {
+		return
+	}
+
+	// The login can only be finished once, by the browser that started it
+	var state, nonce, verifier string
+	if cookie, err := r.Cookie(oidcCookie); err == nil {
+		if parts := strings.Split(cookie.Value, "."); len(parts) == 3 {
+			state, nonce, verifier = parts[0], parts[1], parts[2]
+		}
+	}
+	h.setOIDCCookie(w, r, provider.Name(), "", -1)
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		res.Error(w, r, apierr.Unauthorized("Login was cancelled at the provider"))
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		res.Error(w, r, apierr.BadRequest("Login has expired or was started elsewhere, try again"))
+		return
+	}
+	if query.Get("code") == "" {
+		res.Error(w, r, apierr.BadRequest("Missing authorization code"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	identity, err := provider.Exchange(ctx, query.Get("code"), verifier, nonce)
+	if errors.Is(err, jwt.ErrOIDCRejected) || errors.Is(err, jwt.ErrInvalidIDToken) {
+		log.Printf("Warning: OIDC provider %s: %v", provider.Name(), err)
+		res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Login at the provider failed"))
+		return
+	} else if err != nil {
+		log.Printf("Warning: OIDC provider %s: %v", provider.Name(), err)
+		res.Error(w, r, apierr.New(apierr.CodeUnavailable, "Login provider is unavailable"))
+		return
+	}
+
+	user, ok := h.linkIdentity(ctx, w, r, provider.Name(), identity)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, user, "")
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, tokens)
+}
+
+// oidcProvider returns the provider named in the URL. It writes a 404 and
+// returns false when there is none.
+func (h *Handler) oidcProvider(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (*jwt.OIDCProvider, bool) {
+	provider, ok := h.OIDC[ps.ByName("provider")]
+	if !ok {
+		res.Error(w, r, apierr.NotFound("Login provider not found"))
+	}
+	return provider, ok
+}
+
+// setOIDCCookie sets the login cookie of the provider, a negative maxAge
+// deletes it
+func (h *Handler) setOIDCCookie(w http.ResponseWriter, r *http.Request, provider, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/auth/oidc/" + provider + "/",
+		MaxAge:   maxAge,
+		Secure:   r.TLS != nil || strings.HasPrefix(h.AppURL, "https://"),
+		HttpOnly: true,
+		// Lax, the provider redirects back with a top level GET
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// linkIdentity returns the user the identity is linked to. On the first
+// login the identity is linked to the user with the same verified email, or
+// to a new user. It writes the error and returns false when neither works.
+func (h *Handler) linkIdentity(ctx context.Context, w http.ResponseWriter, r *http.Request, provider string, identity *jwt.OIDCIdentity) (*models.User, bool) {
+	linked, err := h.Identities.Find(ctx, provider, identity.Subject)
+	if err == nil {
+		user, err := h.Users.FindByUsername(ctx, linked.Username)
+		if err == repository.ErrNotFound {
+			res.Error(w, r, apierr.Unauthorized("User no longer exists"))
+			return nil, false
+		} else if err != nil {
+			res.Error(w, r, err)
+			return nil, false
+		}
+		return user, true
+	} else if err != repository.ErrNotFound {
+		res.Error(w, r, err)
+		return nil, false
+	}
+
+	email := models.Normalize(identity.Email)
+	if email == "" {
+		res.Error(w, r, apierr.Forbidden("The login provider did not share an email address"))
+		return nil, false
+	}
+
+	user, err := h.Users.FindByEmail(ctx, email)
+	switch {
+	case err == nil:
+		// Both sides have to vouch for the address, or whoever controls one
+		// of them could take over the other account
+		if !identity.EmailVerified || !user.EmailVerified {
+			res.Error(w, r, apierr.Conflict("Email already exists, log in with your password"))
+			return nil, false
+		}
+	case err == repository.ErrNotFound:
+		user, err = h.createOIDCUser(ctx, identity, email)
+		if err == repository.ErrConflict {
+			res.Error(w, r, apierr.Conflict("Email already exists, log in with your password"))
+			return nil, false
+		} else if err != nil {
+			res.Error(w, r, err)
+			return nil, false
+		}
+	default:
+		res.Error(w, r, err)
+		return nil, false
+	}
+
+	err = h.Identities.Create(ctx, &models.Identity{
+		Provider:  provider,
+		Subject:   identity.Subject,
+		Username:  user.Username,
+		Email:     email,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	})
+	if err == repository.ErrConflict {
+		// A concurrent callback linked it first
+		res.Error(w, r, apierr.Conflict("Identity is already linked, log in again"))
+		return nil, false
+	} else if err != nil {
+		res.Error(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// createOIDCUser creates a user without a password for an identity. The
+// username is taken from the identity and made unique.
+func (h *Handler) createOIDCUser(ctx context.Context, identity *jwt.OIDCIdentity, email string) (*models.User, error) {
+	base := oidcUsername(identity.PreferredUsername)
+	if base == "" {
+		local := email
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			local = email[:at]
+		}
+		base = oidcUsername(local)
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	displayName := []rune(models.Normalize(identity.Name))
+	if len(displayName) > 50 {
+		displayName = displayName[:50]
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	user := &models.User{
+		ID:            uuid.NewV4().String(),
+		DisplayName:   string(displayName),
+		Email:         email,
+		EmailVerified: identity.EmailVerified,
+		// There is no password, but nothing should try to upgrade it
+		PasswordScheme: models.PasswordNormalized,
+		Roles:          []string{models.RoleUser},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	// A few tries with a random suffix when the name is taken
+	for i := 0; i < 5; i++ {
+		user.Username = base
+		if i > 0 {
+			suffix := strconv.Itoa(1000 + rand.Intn(9000))
+			user.Username = truncateRunes(base, 30-len(suffix)-1) + "-" + suffix
+		}
+		_, err := h.Users.FindByUsername(ctx, user.Username)
+		if err == nil {
+			continue
+		} else if err != repository.ErrNotFound {
+			return nil, err
+		}
+
+		err = h.Users.Create(ctx, user)
+		if err == repository.ErrConflict {
+			// The email was taken, or the username since the lookup
+			if _, err := h.Users.FindByEmail(ctx, email); err == nil {
+				return nil, repository.ErrConflict
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, errors.New("no free username for " + base)
+}
+
+// oidcUsername keeps the characters of name that usernames allow. It
+// returns "" when too few are left.
+func oidcUsername(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-", r) {
+			return r
+		}
+		return -1
+	}, models.Normalize(name))
+	name = truncateRunes(name, 30)
+	if len([]rune(name)) < 3 {
+		return ""
+	}
+	return name
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
@@ -0,0 +1,283 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	"github.com/conglt10/web-golang/auth/oidctest"
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// newTestOIDCHandler returns a handler with the mock provider "test"
+func newTestOIDCHandler(t *testing.T) (*Handler, *oidctest.Provider, http.Handler) {
+	mock := oidctest.NewProvider("client", "secret")
+	t.Cleanup(mock.Close)
+
+	h := newTestHandler()
+	h.OIDC = map[string]*jwt.OIDCProvider{
+		"test": jwt.NewOIDCProvider(jwt.OIDCConfig{
+			Name:         "test",
+			Issuer:       mock.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost/auth/oidc/test/callback",
+		}, nil),
+	}
+
+	router := httprouter.New()
+	router.GET("/auth/oidc/:provider/login", h.OIDCLogin)
+	router.GET("/auth/oidc/:provider/callback", h.OIDCCallback)
+	return h, mock, router
+}
+
+// oidcLogin signs in at the mock provider like a browser would and returns
+// the response of the callback
+func oidcLogin(t *testing.T, mock *oidctest.Provider, router http.Handler) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/test/login", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("login returned %v: %s", rr.Code, rr.Body)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/auth/oidc/test/" {
+		t.Fatalf("login set cookies %v", cookies)
+	}
+
+	callback, err := mock.Authorize(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// loggedInAs returns the user the access token of a callback response is for
+func loggedInAs(t *testing.T, h *Handler, rr *httptest.ResponseRecorder) string {
+	if rr.Code != http.StatusOK {
+		t.Fatalf("callback returned %v: %s", rr.Code, rr.Body)
+	}
+	var tokens TokenResponse
+	json.NewDecoder(rr.Body).Decode(&tokens)
+	if tokens.RefreshToken == "" {
+		t.Error("no refresh token was issued")
+	}
+	claims, err := h.Tokens.Parse(tokens.Token)
+	if err != nil {
+		t.Fatalf("invalid access token: %v", err)
+	}
+	return claims.Subject
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	h, mock, router := newTestOIDCHandler(t)
+	ctx := context.Background()
+	mock.SignIn(oidctest.User{Subject: "123", Email: "carol.smith@example.com", EmailVerified: true, Name: "Carol Smith"})
+
+	if got := loggedInAs(t, h, oidcLogin(t, mock, router)); got != "carol.smith" {
+		t.Fatalf("logged in as %q, want carol.smith", got)
+	}
+	user, err := h.Users.FindByUsername(ctx, "carol.smith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "carol.smith@example.com" || !user.EmailVerified || user.DisplayName != "Carol Smith" ||
+		user.Password != "" || user.PasswordScheme != models.PasswordNormalized {
+		t.Errorf("created user %+v", user)
+	}
+	if identity, err := h.Identities.Find(ctx, "test", "123"); err != nil || identity.Username != "carol.smith" {
+		t.Errorf("identity = %+v, %v", identity, err)
+	}
+
+	// The identity is found by subject, even when the email changed
+	mock.SignIn(oidctest.User{Subject: "123", Email: "carol@example.org", EmailVerified: true})
+	if got := loggedInAs(t, h, oidcLogin(t, mock, router)); got != "carol.smith" {
+		t.Errorf("second login as %q, want carol.smith", got)
+	}
+	if users, _ := h.Users.List(ctx); len(users) != 1 {
+		t.Errorf("%d users after the second login, want 1", len(users))
+	}
+}
+
+func TestOIDCLoginPicksFreeUsername(t *testing.T) {
+	h, mock, router := newTestOIDCHandler(t)
+	testUserWithPassword(t, h, "alice", "password123")
+	mock.SignIn(oidctest.User{Subject: "123", Email: "someone@example.com", PreferredUsername: "alice"})
+
+	got := loggedInAs(t, h, oidcLogin(t, mock, router))
+	if !strings.HasPrefix(got, "alice-") || got == "alice-" {
+		t.Errorf("logged in as %q, want alice with a suffix", got)
+	}
+}
+
+func TestOIDCLoginLinksExistingUser(t *testing.T) {
+	tests := []struct {
+		name             string
+		localVerified    bool
+		providerVerified bool
+		expectedStatus   int
+	}{
+		{"Both verified", true, true, http.StatusOK},
+		{"Local email unverified", false, true, http.StatusConflict},
+		{"Provider email unverified", true, false, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock, router := newTestOIDCHandler(t)
+			testUserWithPassword(t, h, "alice", "password123")
+			if tt.localVerified {
+				h.Users.SetEmailVerified(context.Background(), "alice", "alice@example.com")
+			}
+			mock.SignIn(oidctest.User{Subject: "123", Email: "alice@example.com", EmailVerified: tt.providerVerified})
+
+			rr := oidcLogin(t, mock, router)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("callback returned %v: %s", rr.Code, rr.Body)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				if _, err := h.Identities.Find(context.Background(), "test", "123"); err == nil {
+					t.Error("identity was linked")
+				}
+				return
+			}
+			if got := loggedInAs(t, h, rr); got != "alice" {
+				t.Errorf("logged in as %q, want alice", got)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	h, mock, router := newTestOIDCHandler(t)
+	mock.SignIn(oidctest.User{Subject: "123", Email: "alice@example.com", EmailVerified: true})
+
+	// start begins a login and returns its cookie and callback URL
+	start := func() (*http.Cookie, string) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/test/login", nil))
+		callback, err := mock.Authorize(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Result().Cookies()[0], callback.RequestURI()
+	}
+	callback := func(path string, cookie *http.Cookie) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("Unknown provider", func(t *testing.T) {
+		if got := callback("/auth/oidc/other/login", nil); got != http.StatusNotFound {
+			t.Errorf("got %v want %v", got, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Without the cookie", func(t *testing.T) {
+		_, path := start()
+		if got := callback(path, nil); got != http.StatusBadRequest {
+			t.Errorf("got %v want %v", got, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Cookie of another login", func(t *testing.T) {
+		cookie, _ := start()
+		_, path := start()
+		if got := callback(path, cookie); got != http.StatusBadRequest {
+			t.Errorf("got %v want %v", got, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Cancelled at the provider", func(t *testing.T) {
+		cookie, _ := start()
+		if got := callback("/auth/oidc/test/callback?error=access_denied", cookie); got != http.StatusUnauthorized {
+			t.Errorf("got %v want %v", got, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Code used twice", func(t *testing.T) {
+		cookie, path := start()
+		if got := callback(path, cookie); got != http.StatusOK {
+			t.Fatalf("first callback: got %v want %v", got, http.StatusOK)
+		}
+		// Replayed with the cookie the browser was told to delete
+		if got := callback(path, cookie); got != http.StatusUnauthorized {
+			t.Errorf("got %v want %v", got, http.StatusUnauthorized)
+		}
+	})
+
+	if users, _ := h.Users.List(context.Background()); len(users) != 1 {
+		t.Errorf("%d users, want only the one of the successful login", len(users))
+	}
+}
+
+func TestOIDCUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"alice", "alice"},
+		{"Alice Smith!", "AliceSmith"},
+		{"a b", ""},
+		{strings.Repeat("x", 40), strings.Repeat("x", 30)},
+	}
+	for _, tt := range tests {
+		if got := oidcUsername(tt.name); got != tt.want {
+			t.Errorf("oidcUsername(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteMeUnlinksIdentities(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	testUserWithPassword(t, h, "alice", "password123")
+	h.Identities.Create(ctx, &models.Identity{Provider: "test", Subject: "123", Username: "alice", Email: "alice@example.com"})
+
+	if rr := serveAs("DELETE", "/me", "/me", h.DeleteMe, "alice", DeleteMeRequest{Password: "password123"}); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned %v: %s", rr.Code, rr.Body)
+	}
+	if _, err := h.Identities.Find(ctx, "test", "123"); err == nil {
+		t.Error("identity of the deleted user was kept")
+	}
+}
+
+func TestOIDCUserNeedsNoPassword(t *testing.T) {
+	h, mock, router := newTestOIDCHandler(t)
+	ctx := context.Background()
+	mock.SignIn(oidctest.User{Subject: "123", Email: "carol@example.com", EmailVerified: true})
+	username := loggedInAs(t, h, oidcLogin(t, mock, router))
+
+	email := "carol@example.org"
+	if rr := serveAs("PATCH", "/me", "/me", h.UpdateMe, username, UpdateMeRequest{Email: &email}); rr.Code != http.StatusOK {
+		t.Fatalf("changing the email returned %v: %s", rr.Code, rr.Body)
+	}
+	if user, err := h.Users.FindByUsername(ctx, username); err != nil || user.Email != email {
+		t.Errorf("user = %+v, %v, want the new email", user, err)
+	}
+
+	// A first password comes from a reset link, not from guessing nothing
+	if rr := serveAs("PUT", "/me/password", "/me/password", h.ChangePassword, username, ChangePasswordRequest{CurrentPassword: "anything", NewPassword: "password123"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("changing the password returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := serveAs("DELETE", "/me", "/me", h.DeleteMe, username, DeleteMeRequest{}); rr.Code != http.StatusOK {
+		t.Fatalf("deleting the account returned %v: %s", rr.Code, rr.Body)
+	}
+	if _, err := h.Users.FindByUsername(ctx, username); err == nil {
+		t.Error("user was not deleted")
+	}
+}
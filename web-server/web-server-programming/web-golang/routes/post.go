@@ -2,234 +2,482 @@ package routes
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/asaskevich/govalidator"
 	jwt "github.com/conglt10/web-golang/auth"
-	db "github.com/conglt10/web-golang/database"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/markdown"
 	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
 	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
 	"github.com/julienschmidt/httprouter"
 	uuid "github.com/satori/go.uuid"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type CreatePostRequest struct {
-	Title string `json:"title"`
+	Title string `json:"title" validate:"required,max=200"`
+	Body  string `json:"body" validate:"max=100000"`
+	// Tags are checked by models.NormalizeTags
+	Tags []string `json:"tags"`
+	// Status is draft or published, the default
+	Status string `json:"status" validate:"oneof=draft published"`
 }
 
+// EditPostRequest changes the title of a post. The other fields are left
+// unchanged when omitted.
 type EditPostRequest struct {
-	Title string `json:"title"`
+	Title string    `json:"title" validate:"required,max=200"`
+	Body  *string   `json:"body" validate:"max=100000"`
+	Tags  *[]string `json:"tags"`
+	// Status is checked by models.ValidPostStatus, it cannot be emptied
+	Status *string `json:"status"`
 }
 
-func GetAllPosts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	collection := db.ConnectPosts()
+// PostView is a post as returned by GET /posts/:id
+type PostView struct {
+	models.Post
+	// BodyHTML is the body rendered to HTML, only set with ?render=html
+	BodyHTML string `json:"body_html,omitempty"`
+}
+
+// PostPage is one page of GET /posts
+type PostPage struct {
+	Data []models.Post `json:"data"`
+	// NextCursor is passed as ?cursor= to get the next page. It is empty on
+	// the last page.
+	NextCursor    string `json:"next_cursor,omitempty"`
+	TotalEstimate int64  `json:"total_estimate"`
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
 
-	var result []bson.M
-	data, err := collection.Find(context.Background(), bson.M{})
+// GetAllPosts returns a page of posts. Query parameters:
+//
+//	limit    page size, 1 to 100, default 20
+//	cursor   next_cursor from the previous page
+//	sort     -created_at (newest first, default) or created_at
+//	author   only posts by this user
+//	q        only posts whose title contains this text
+//	tag      only posts with this tag
+//
+// Drafts are only listed for their author.
+func (h *Handler) GetAllPosts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
 
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	query, err := parsePostQuery(r.URL.Query())
 	if err != nil {
-		res.JSON(w, 500, "Internal Server Error")
+		res.Error(w, r, apierr.BadRequest(err.Error()))
 		return
 	}
+	query.VisibleTo = principal.Username
 
-	defer data.Close(context.Background())
-	for data.Next(context.Background()) {
-		var elem bson.M
-		err := data.Decode(&elem)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		if err != nil {
-			res.JSON(w, 500, "Internal Server Error")
-			return
+	// Fetch one extra post to know whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	result, err := h.Posts.Find(ctx, query)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	total, err := h.Posts.Count(ctx, query.PostFilter)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	page := PostPage{Data: result, TotalEstimate: total}
+	if len(result) > limit {
+		page.Data = result[:limit]
+		last := page.Data[limit-1]
+		page.NextCursor = encodeCursor(repository.PostCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	if page.Data == nil {
+		page.Data = []models.Post{}
+	}
+	if err := h.countLikes(ctx, page.Data); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, 200, page)
+}
+
+func parsePostQuery(values url.Values) (repository.PostQuery, error) {
+	q := repository.PostQuery{
+		PostFilter: repository.PostFilter{
+			Author:        values.Get("author"),
+			TitleContains: models.Normalize(values.Get("q")),
+			Tag:           strings.ToLower(models.Normalize(values.Get("tag"))),
+		},
+		Limit: defaultPageSize,
+	}
+
+	if s := values.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
 		}
+		q.Limit = limit
+	}
 
-		result = append(result, elem)
+	switch values.Get("sort") {
+	case "", "-created_at":
+		q.Order = repository.NewestFirst
+	case "created_at":
+		q.Order = repository.OldestFirst
+	default:
+		return q, errors.New("sort must be created_at or -created_at")
 	}
 
-	res.JSON(w, 200, result)
+	if s := values.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, errors.New("Invalid cursor")
+		}
+		q.After = &c
+	}
+	return q, nil
+}
+
+// cursor is the JSON form of repository.PostCursor. Clients only see it
+// base64 encoded and should treat it as opaque.
+type cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
 }
 
-func GetMyPosts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	username, err := jwt.ExtractUsernameFromToken(r)
+func encodeCursor(c repository.PostCursor) string {
+	b, _ := json.Marshal(cursor{CreatedAt: c.CreatedAt, ID: c.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
 
+func decodeCursor(s string) (repository.PostCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		res.JSON(w, 500, "Internal Server Error")
+		return repository.PostCursor{}, err
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return repository.PostCursor{}, err
+	}
+	if c.ID == "" {
+		return repository.PostCursor{}, errors.New("cursor without id")
+	}
+	return repository.PostCursor{CreatedAt: c.CreatedAt, ID: c.ID}, nil
+}
+
+// GetPost returns a post. Drafts are only visible to their author and to
+// users who can edit any post. With ?render=html the body is also returned
+// as sanitized HTML.
+func (h *Handler) GetPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
 
-	collection := db.ConnectPosts()
+	render := r.URL.Query().Get("render")
+	if render != "" && render != "html" {
+		res.Error(w, r, apierr.BadRequest("render must be html"))
+		return
+	}
 
-	var result []bson.M
-	data, err := collection.Find(context.Background(), bson.M{"creater": username})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	defer data.Close(context.Background())
-	for data.Next(context.Background()) {
-		var elem bson.M
-		err := data.Decode(&elem)
+	post, err := h.findVisiblePost(ctx, principal, ps.ByName("id"))
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if post.LikeCount, err = h.likeCount(ctx, post.ID); err != nil {
+		res.Error(w, r, err)
+		return
+	}
 
+	view := PostView{Post: *post}
+	if render == "html" {
+		view.BodyHTML, err = markdown.ToHTML(post.Body)
 		if err != nil {
-			res.JSON(w, 500, "Internal Server Error")
+			res.Error(w, r, err)
 			return
 		}
+	}
 
-		result = append(result, elem)
+	res.JSON(w, http.StatusOK, view)
+}
+
+// findVisiblePost looks up a post the caller may read. Posts that do not
+// exist and drafts of other users are both not found.
+func (h *Handler) findVisiblePost(ctx context.Context, principal *jwt.Principal, id string) (*models.Post, error) {
+	post, err := h.Posts.FindByID(ctx, id)
+	if err == repository.ErrNotFound {
+		return nil, apierr.NotFound("Post not found")
+	} else if err != nil {
+		return nil, err
 	}
 
-	res.JSON(w, 200, result)
+	// Other users get the same answer as for a missing post, so drafts
+	// cannot be discovered by id
+	if !post.Published() && principal.Username != post.Author && !principal.Can(jwt.PermEditAnyPost) {
+		return nil, apierr.NotFound("Post not found")
+	}
+	return post, nil
 }
 
-func CreatePost(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	w.Header().Set("Content-Type", "application/json")
+// countLikes fills in the like count of the posts with a single query
+func (h *Handler) countLikes(ctx context.Context, posts []models.Post) error {
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	counts, err := h.Likes.Count(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].LikeCount = counts[posts[i].ID]
+	}
+	return nil
+}
 
-	// Kiểm tra Authorization header trước
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		res.JSON(w, http.StatusUnauthorized, "Token không được cung cấp")
+func (h *Handler) likeCount(ctx context.Context, postID string) (int64, error) {
+	counts, err := h.Likes.Count(ctx, []string{postID})
+	return counts[postID], err
+}
+
+func (h *Handler) GetMyPosts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	principal, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
 
-	creater, err := jwt.ExtractUsernameFromToken(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := h.Posts.FindByAuthor(ctx, principal.Username)
 	if err != nil {
-		res.JSON(w, http.StatusUnauthorized, "Invalid or missing token")
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.countLikes(ctx, result); err != nil {
+		res.Error(w, r, err)
 		return
 	}
 
-	var req CreatePostRequest
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
+	res.JSON(w, 200, result)
+}
+
+func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
 
-	if err = decoder.Decode(&req); err != nil {
-		res.JSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body", "details": err.Error()})
+	var req CreatePostRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
+	req.Status = strings.TrimSpace(req.Status)
 
-	if govalidator.IsNull(req.Title) {
-		res.JSON(w, http.StatusBadRequest, "Title cannot be empty")
+	errs := validation.Struct(&req)
+	tags, err := models.NormalizeTags(req.Tags)
+	if err != nil {
+		errs.Add("tags", err.Error())
+	}
+	if err := errs.Err(); err != nil {
+		res.Error(w, r, err)
 		return
 	}
+	status := req.Status
+	if status == "" {
+		status = models.PostPublished
+	}
 
-	title := models.Santize(req.Title)
+	title := models.Normalize(req.Title)
+	// The body is Markdown, it is sanitized when rendered
+	body := models.Normalize(req.Body)
 	uid := uuid.NewV4()
 	id := fmt.Sprintf("%x-%x-%x-%x-%x", uid[0:4], uid[4:6], uid[6:8], uid[8:10], uid[10:])
 
-	collection := db.ConnectPosts()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	newPost := bson.M{"id": id, "creater": creater, "title": title}
-	_, err = collection.InsertOne(ctx, newPost)
+	author, err := h.Users.FindByUsername(ctx, principal.Username)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.Unauthorized("User no longer exists"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	// MongoDB stores milliseconds, keep the same precision everywhere so
+	// cursors compare equal to the stored value
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	newPost := &models.Post{
+		ID:        id,
+		AuthorID:  author.ID,
+		Author:    author.Username,
+		Title:     title,
+		Body:      body,
+		Tags:      tags,
+		Status:    status,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = h.Posts.Create(ctx, newPost)
 	if err != nil {
-		res.JSON(w, http.StatusInternalServerError, "Failed to create post")
+		res.Error(w, r, err)
 		return
 	}
 
-	res.JSON(w, http.StatusCreated, "Post created successfully")
+	res.JSON(w, http.StatusCreated, newPost)
 }
 
-func EditPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (h *Handler) EditPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Kiểm tra Authorization header trước
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		res.JSON(w, http.StatusUnauthorized, "Token không được cung cấp")
+	principal, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
 
 	id := ps.ByName("id")
-	username, err := jwt.ExtractUsernameFromToken(r)
-	if err != nil {
-		res.JSON(w, http.StatusUnauthorized, "Invalid or missing token")
-		return
-	}
 
 	var req EditPostRequest
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-
-	if err = decoder.Decode(&req); err != nil {
-		res.JSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body", "details": err.Error()})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	defer r.Body.Close()
 
-	if govalidator.IsNull(req.Title) {
-		res.JSON(w, http.StatusBadRequest, "Title cannot be empty")
+	errs := validation.Struct(&req)
+	if req.Status != nil {
+		*req.Status = strings.TrimSpace(*req.Status)
+		if !models.ValidPostStatus(*req.Status) {
+			errs.Add("status", "must be one of draft, published")
+		}
+	}
+	var tags []string
+	if req.Tags != nil {
+		var err error
+		tags, err = models.NormalizeTags(*req.Tags)
+		if err != nil {
+			errs.Add("tags", err.Error())
+		}
+	}
+	if err := errs.Err(); err != nil {
+		res.Error(w, r, err)
 		return
 	}
 
-	title := models.Santize(req.Title)
-	collection := db.ConnectPosts()
+	title := models.Normalize(req.Title)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var result bson.M
-	err = collection.FindOne(ctx, bson.M{"id": id}).Decode(&result)
+	// Drafts of other users are not found, like in GetPost
+	post, err := h.findVisiblePost(ctx, principal, id)
 	if err != nil {
-		res.JSON(w, http.StatusNotFound, "Post not found")
+		res.Error(w, r, err)
 		return
 	}
 
-	creater, ok := result["creater"].(string)
-	if !ok || username != creater {
-		res.JSON(w, http.StatusForbidden, "Permission denied")
+	owner := principal.Username == post.Author
+	if !owner && !principal.Can(jwt.PermEditAnyPost) {
+		res.Error(w, r, apierr.Forbidden("Permission denied"))
 		return
 	}
 
-	filter := bson.M{"id": id}
-	update := bson.M{"$set": bson.M{"title": title}}
-
-	_, err = collection.UpdateOne(ctx, filter, update)
+	post.Title = title
+	if req.Body != nil {
+		post.Body = models.Normalize(*req.Body)
+	}
+	if req.Tags != nil {
+		post.Tags = tags
+	}
+	if req.Status != nil {
+		post.Status = *req.Status
+	}
+	post.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	err = h.Posts.Update(ctx, post)
 	if err != nil {
-		res.JSON(w, http.StatusInternalServerError, "Failed to edit post")
+		res.Error(w, r, err)
+		return
+	}
+	if post.LikeCount, err = h.likeCount(ctx, post.ID); err != nil {
+		res.Error(w, r, err)
 		return
 	}
+	if !owner {
+		h.audit(r, principal, "post.edit", post.ID, map[string]interface{}{"author": post.Author})
+	}
 
-	res.JSON(w, http.StatusOK, "Post updated successfully")
+	res.JSON(w, http.StatusOK, post)
 }
 
-func DeletePost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Kiểm tra Authorization header trước
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		res.JSON(w, http.StatusUnauthorized, "Token không được cung cấp")
+func (h *Handler) DeletePost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	principal, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
 
 	id := ps.ByName("id")
-	username, err := jwt.ExtractUsernameFromToken(r)
-	collection := db.ConnectPosts()
-
-	if err != nil {
-		res.JSON(w, 500, "Internal Server Error")
-		return
-	}
 
-	var result bson.M
-	errFind := collection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&result)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	// Drafts of other users are not found, like in GetPost
+	post, errFind := h.findVisiblePost(ctx, principal, id)
 	if errFind != nil {
-		res.JSON(w, 404, "Post Not Found")
+		res.Error(w, r, errFind)
 		return
 	}
 
-	creater := fmt.Sprintf("%v", result["creater"])
-
-	if username != creater {
-		res.JSON(w, 403, "Permission Denied")
+	owner := principal.Username == post.Author
+	if !owner && !principal.Can(jwt.PermDeleteAnyPost) {
+		res.Error(w, r, apierr.Forbidden("Permission denied"))
 		return
 	}
 
-	errDelete := collection.FindOneAndDelete(context.TODO(), bson.M{"id": id}).Decode(&result)
+	errDelete := h.Posts.Delete(ctx, id)
 
 	if errDelete != nil {
-		res.JSON(w, 500, "Delete has failed")
+		res.Error(w, r, errDelete)
 		return
 	}
+	// The post is gone either way, leftovers are only logged
+	if err := h.Comments.DeleteByPost(ctx, id); err != nil {
+		log.Printf("Failed to delete comments of post %s: %v", id, err)
+	}
+	if err := h.Likes.DeleteByPost(ctx, id); err != nil {
+		log.Printf("Failed to delete likes of post %s: %v", id, err)
+	}
+	if !owner {
+		h.audit(r, principal, "post.delete", post.ID, map[string]interface{}{"author": post.Author})
+	}
 
 	res.JSON(w, 200, "Delete Successfully")
 }
@@ -4,48 +4,59 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	jwt "github.com/conglt10/web-golang/auth"
-	db "github.com/conglt10/web-golang/database"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/middlewares"
+	mock "github.com/conglt10/web-golang/mocks"
+	"github.com/conglt10/web-golang/models"
+	res "github.com/conglt10/web-golang/utils"
 	"github.com/julienschmidt/httprouter"
-	"go.mongodb.org/mongo-driver/bson"
+	testifymock "github.com/stretchr/testify/mock"
 )
 
+// asUser đặt người dùng vào context như middleware CheckJwt
+func asUser(req *http.Request, username string) *http.Request {
+	return req.WithContext(jwt.NewContext(req.Context(), &jwt.Principal{Username: username}))
+}
+
 func TestGetAllPosts(t *testing.T) {
 	// Thiết lập dữ liệu test
-	collection := db.ConnectPosts()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	h := newTestHandler()
+	ctx := context.Background()
 
 	// Tạo dữ liệu test
-	testPosts := []interface{}{
-		bson.M{
-			"id":      "test-id-1",
-			"creater": "testuser1",
-			"title":   "Test post 1",
+	testPosts := []models.Post{
+		{
+			ID:     "test-id-1",
+			Author: "testuser1",
+			Title:  "Test post 1",
 		},
-		bson.M{
-			"id":      "test-id-2",
-			"creater": "testuser2",
-			"title":   "Test post 2",
+		{
+			ID:     "test-id-2",
+			Author: "testuser2",
+			Title:  "Test post 2",
 		},
 	}
 
-	_, err := collection.InsertMany(ctx, testPosts)
-	if err != nil {
-		t.Fatal(err)
+	for i := range testPosts {
+		if err := h.Posts.Create(ctx, &testPosts[i]); err != nil {
+			t.Fatal(err)
+		}
 	}
 
 	// Thực hiện test
-	req := httptest.NewRequest("GET", "/posts", nil)
+	req := asUser(httptest.NewRequest("GET", "/posts", nil), "testuser")
 	rr := httptest.NewRecorder()
 
 	router := httprouter.New()
-	router.GET("/posts", GetAllPosts)
+	router.GET("/posts", h.GetAllPosts)
 	router.ServeHTTP(rr, req)
 
 	// Kiểm tra status code
@@ -55,29 +66,259 @@ func TestGetAllPosts(t *testing.T) {
 	}
 
 	// Kiểm tra response body
-	var response []bson.M
-	if err = json.NewDecoder(rr.Body).Decode(&response); err != nil {
+	var response PostPage
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
 		t.Errorf("không thể decode response body: %v", err)
 	}
 
-	if len(response) != 8 {
-		t.Errorf("số lượng bài đăng không đúng: nhận được %v muốn %v",
-			len(response), 8)
+	if len(response.Data) != 2 || response.TotalEstimate != 2 || response.NextCursor != "" {
+		t.Errorf("trang bài đăng không đúng: nhận được %+v muốn 2 bài đăng", response)
 	}
+}
 
-	// Dọn dẹp dữ liệu test
-	_, err = collection.DeleteMany(ctx, bson.M{"id": bson.M{"$in": []string{"test-id-1", "test-id-2"}}})
-	if err != nil {
-		t.Errorf("không thể xóa dữ liệu test: %v", err)
+func TestGetAllPostsPagination(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, p := range []models.Post{
+		{ID: "1", Author: "alice", Title: "Học Go"},
+		{ID: "2", Author: "bob", Title: "Go nâng cao"},
+		{ID: "3", Author: "alice", Title: "Viết test"},
+		{ID: "4", Author: "alice", Title: "Go và MongoDB"},
+	} {
+		p := p
+		p.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// getIDs đi qua tất cả các trang theo next_cursor
+	getIDs := func(t *testing.T, query string) string {
+		var ids string
+		cursor := ""
+		for page := 0; page < 10; page++ {
+			target := "/posts?" + query
+			if cursor != "" {
+				target += "&cursor=" + cursor
+			}
+			req := asUser(httptest.NewRequest("GET", target, nil), "alice")
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/posts", h.GetAllPosts)
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("nhận được %v: %s", rr.Code, rr.Body)
+			}
+			var response PostPage
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range response.Data {
+				ids += p.ID
+			}
+			if response.NextCursor == "" {
+				return ids
+			}
+			cursor = response.NextCursor
+		}
+		t.Fatal("phân trang không kết thúc")
+		return ""
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "Mới nhất trước", query: "limit=1", want: "4321"},
+		{name: "Cũ nhất trước", query: "limit=3&sort=created_at", want: "1234"},
+		{name: "Lọc theo người tạo", query: "limit=2&author=alice", want: "431"},
+		{name: "Tìm theo tiêu đề", query: "limit=2&q=go", want: "421"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getIDs(t, tt.query); got != tt.want {
+				t.Errorf("nhận được %q muốn %q", got, tt.want)
+			}
+		})
 	}
 }
 
+func TestGetAllPostsHidesDrafts(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, p := range []models.Post{
+		{ID: "1", Author: "alice", Title: "Đã đăng", Status: models.PostPublished, Tags: []string{"go"}},
+		{ID: "2", Author: "alice", Title: "Bản nháp", Status: models.PostDraft, Tags: []string{"go"}},
+		{ID: "3", Author: "bob", Title: "Bản nháp của bob", Status: models.PostDraft},
+		{ID: "4", Author: "bob", Title: "Bài của bob", Status: models.PostPublished, Tags: []string{"mongodb"}},
+	} {
+		p := p
+		p.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		user  string
+		query string
+		want  string
+	}{
+		{name: "Tác giả thấy bản nháp của mình", user: "alice", want: "421"},
+		{name: "Người khác chỉ thấy bài đã đăng", user: "carol", want: "41"},
+		{name: "Lọc theo tag", user: "alice", query: "tag=Go", want: "21"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(httptest.NewRequest("GET", "/posts?"+tt.query, nil), tt.user)
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/posts", h.GetAllPosts)
+			router.ServeHTTP(rr, req)
+
+			var response PostPage
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			var ids string
+			for _, p := range response.Data {
+				ids += p.ID
+			}
+			if ids != tt.want || response.TotalEstimate != int64(len(tt.want)) {
+				t.Errorf("nhận được %q (tổng %d) muốn %q", ids, response.TotalEstimate, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	for _, p := range []models.Post{
+		{ID: "published", Author: "alice", Title: "Đã đăng", Body: "**đậm** <script>alert(1)</script>", Status: models.PostPublished},
+		{ID: "draft", Author: "alice", Title: "Bản nháp", Status: models.PostDraft},
+	} {
+		p := p
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aliceToken, _ := h.Tokens.Create("alice", []string{models.RoleUser})
+	bobToken, _ := h.Tokens.Create("bob", []string{models.RoleUser})
+	moderatorToken, _ := h.Tokens.Create("moderator", []string{models.RoleModerator})
+
+	tests := []struct {
+		name           string
+		target         string
+		token          string
+		expectedStatus int
+		expectedHTML   string
+	}{
+		{name: "Xem bài đã đăng", target: "/posts/published", token: bobToken, expectedStatus: http.StatusOK},
+		{name: "Hiển thị Markdown", target: "/posts/published?render=html", token: bobToken, expectedStatus: http.StatusOK,
+			expectedHTML: "<p><strong>đậm</strong> alert(1)</p>\n"},
+		{name: "render không hợp lệ", target: "/posts/published?render=pdf", token: bobToken, expectedStatus: http.StatusBadRequest},
+		{name: "Tác giả xem bản nháp", target: "/posts/draft", token: aliceToken, expectedStatus: http.StatusOK},
+		{name: "Moderator xem bản nháp", target: "/posts/draft", token: moderatorToken, expectedStatus: http.StatusOK},
+		{name: "Người khác không thấy bản nháp", target: "/posts/draft", token: bobToken, expectedStatus: http.StatusNotFound},
+		{name: "Bài đăng không tồn tại", target: "/posts/non-existent-id", token: aliceToken, expectedStatus: http.StatusNotFound},
+		{name: "Không có token", target: "/posts/published", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/posts/:id", middlewares.CheckJwt(h.Tokens, h.GetPost))
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v: %s", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type không đúng: %q", ct)
+			}
+			var view PostView
+			if err := json.NewDecoder(rr.Body).Decode(&view); err != nil {
+				t.Fatal(err)
+			}
+			if view.BodyHTML != tt.expectedHTML {
+				t.Errorf("body_html không đúng: nhận được %q muốn %q", view.BodyHTML, tt.expectedHTML)
+			}
+		})
+	}
+}
+
+func TestGetAllPostsInvalidQuery(t *testing.T) {
+	h := newTestHandler()
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=abc", "sort=title", "cursor=khong-hop-le"} {
+		t.Run(query, func(t *testing.T) {
+			req := asUser(httptest.NewRequest("GET", "/posts?"+query, nil), "testuser")
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/posts", h.GetAllPosts)
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler trả về status code không đúng: nhận được %v muốn %v",
+					status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestGetAllPostsRepositoryError(t *testing.T) {
+	posts := new(mock.PostRepository)
+	posts.On("Find", testifymock.Anything, testifymock.Anything).Return(nil, errors.New("connection refused"))
+	h := &Handler{Posts: posts}
+
+	req := asUser(httptest.NewRequest("GET", "/posts", nil), "testuser")
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.GET("/posts", h.GetAllPosts)
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler trả về status code không đúng: nhận được %v muốn %v",
+			status, http.StatusInternalServerError)
+	}
+	posts.AssertExpectations(t)
+}
+
 func TestCreatePost(t *testing.T) {
+	h := newTestHandler()
 	testUser := "testuser"
-	validToken, err := jwt.Create(testUser)
+	err := h.Users.Create(context.Background(), &models.User{ID: "user-1", Username: testUser, Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("không thể tạo người dùng: %v", err)
+	}
+	validToken, err := h.Tokens.Create(testUser, nil)
 	if err != nil {
 		t.Fatalf("không thể tạo token: %v", err)
 	}
+	ghostToken, _ := h.Tokens.Create("ghost", nil)
 
 	tests := []struct {
 		name           string
@@ -89,10 +330,20 @@ func TestCreatePost(t *testing.T) {
 			name: "Tạo bài đăng thành công",
 			requestBody: map[string]interface{}{
 				"title": "Test post",
+				"body":  "Nội dung",
+				"tags":  []string{" Go ", "go", "web-dev"},
 			},
 			token:          validToken,
 			expectedStatus: http.StatusCreated,
 		},
+		{
+			name: "Người dùng không còn tồn tại",
+			requestBody: map[string]interface{}{
+				"title": "Test post",
+			},
+			token:          ghostToken,
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name: "Không có token",
 			requestBody: map[string]interface{}{
@@ -109,6 +360,24 @@ func TestCreatePost(t *testing.T) {
 			token:          validToken,
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name: "Trạng thái không hợp lệ",
+			requestBody: map[string]interface{}{
+				"title":  "Test post",
+				"status": "archived",
+			},
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Tag không hợp lệ",
+			requestBody: map[string]interface{}{
+				"title": "Test post",
+				"tags":  []string{"c++"},
+			},
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -123,13 +392,29 @@ func TestCreatePost(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			router := httprouter.New()
-			router.POST("/posts", CreatePost)
+			router.POST("/posts", middlewares.CheckJwt(h.Tokens, h.CreatePost))
 			router.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
 				t.Errorf("handler trả về status code không đúng cho test case '%s': nhận được %v muốn %v",
 					tt.name, status, tt.expectedStatus)
 			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+			var post models.Post
+			if err := json.Unmarshal(rr.Body.Bytes(), &post); err != nil {
+				t.Fatalf("không thể đọc bài đăng: %v", err)
+			}
+			if post.ID == "" || post.AuthorID != "user-1" || post.Author != testUser || post.Body != "Nội dung" {
+				t.Errorf("bài đăng trả về không đúng: %+v", post)
+			}
+			if post.Status != models.PostPublished || len(post.Tags) != 2 || post.Tags[0] != "go" || post.Tags[1] != "web-dev" {
+				t.Errorf("trạng thái hoặc tag không đúng: %q %q", post.Status, post.Tags)
+			}
+			if post.CreatedAt.IsZero() || !post.UpdatedAt.Equal(post.CreatedAt) {
+				t.Errorf("thời gian tạo/cập nhật không đúng: %v %v", post.CreatedAt, post.UpdatedAt)
+			}
 		})
 	}
 }
@@ -138,23 +423,24 @@ func TestEditPost(t *testing.T) {
 	// Thiết lập dữ liệu test
 	testUser := "testuser"
 	testPostID := "test-post-id"
-	validToken, err := jwt.Create(testUser)
+	h := newTestHandler()
+	validToken, err := h.Tokens.Create(testUser, nil)
 	if err != nil {
 		t.Fatalf("không thể tạo token: %v", err)
 	}
+	otherToken, _ := h.Tokens.Create("otheruser", []string{models.RoleUser})
+	moderatorToken, _ := h.Tokens.Create("moderator", []string{models.RoleModerator})
+	updatedBody := "Updated body"
 
 	// Tạo bài đăng test
-	collection := db.ConnectPosts()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	testPost := bson.M{
-		"id":      testPostID,
-		"creater": testUser,
-		"title":   "Original title",
+	testPost := &models.Post{
+		ID:     testPostID,
+		Author: testUser,
+		Title:  "Original title",
+		Body:   "Original body",
 	}
 
-	_, err = collection.InsertOne(ctx, testPost)
+	err = h.Posts.Create(context.Background(), testPost)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -166,6 +452,34 @@ func TestEditPost(t *testing.T) {
 		token          string
 		expectedStatus int
 	}{
+		{
+			name:   "Người dùng khác không được sửa",
+			postID: testPostID,
+			requestBody: EditPostRequest{
+				Title: "Updated title",
+			},
+			token:          otherToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Sửa nội dung bài đăng",
+			postID: testPostID,
+			requestBody: EditPostRequest{
+				Title: "Updated title",
+				Body:  &updatedBody,
+			},
+			token:          validToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Moderator sửa bài đăng của người khác",
+			postID: testPostID,
+			requestBody: EditPostRequest{
+				Title: "Moderated title",
+			},
+			token:          moderatorToken,
+			expectedStatus: http.StatusOK,
+		},
 		{
 			name:   "Sửa bài đăng thành công",
 			postID: testPostID,
@@ -207,7 +521,7 @@ func TestEditPost(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			router := httprouter.New()
-			router.PUT("/posts/:id", EditPost)
+			router.PUT("/posts/:id", middlewares.CheckJwt(h.Tokens, h.EditPost))
 			router.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
@@ -217,10 +531,58 @@ func TestEditPost(t *testing.T) {
 		})
 	}
 
-	// Dọn dẹp dữ liệu test
-	_, err = collection.DeleteOne(ctx, bson.M{"id": testPostID})
+	// Các lần sửa sau không gửi body nên nội dung phải được giữ nguyên
+	post, err := h.Posts.FindByID(context.Background(), testPostID)
 	if err != nil {
-		t.Errorf("không thể xóa dữ liệu test: %v", err)
+		t.Fatal(err)
+	}
+	if post.Body != updatedBody {
+		t.Errorf("nội dung bài đăng không đúng: nhận được %q muốn %q", post.Body, updatedBody)
+	}
+	if post.UpdatedAt.IsZero() {
+		t.Error("updated_at chưa được cập nhật")
+	}
+}
+
+func TestPostStatusIsTrimmed(t *testing.T) {
+	h := newTestHandler()
+	testUserWithPassword(t, h, "alice", "password123")
+
+	rr := serveAs("POST", "/posts", "/posts", h.CreatePost, "alice", CreatePostRequest{Title: "Bản nháp", Status: " draft "})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("tạo bài đăng trả về %v: %s", rr.Code, rr.Body)
+	}
+	var post models.Post
+	json.Unmarshal(rr.Body.Bytes(), &post)
+	if post.Status != models.PostDraft {
+		t.Errorf("trạng thái được lưu là %q, muốn %q", post.Status, models.PostDraft)
+	}
+
+	tests := []struct {
+		name           string
+		status         string
+		expectedStatus int
+		expectedPost   string
+	}{
+		{"Trạng thái có khoảng trắng", " published\n", http.StatusOK, models.PostPublished},
+		{"Trạng thái rỗng", "", http.StatusBadRequest, models.PostPublished},
+		{"Chỉ có khoảng trắng", "  ", http.StatusBadRequest, models.PostPublished},
+		{"Trạng thái không hợp lệ", "archived", http.StatusBadRequest, models.PostPublished},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs("PUT", "/posts/:id", "/posts/"+post.ID, h.EditPost, "alice", EditPostRequest{Title: "Bài đăng", Status: strPtr(tt.status)})
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v: %s", rr.Code, tt.expectedStatus, rr.Body)
+			}
+			stored, err := h.Posts.FindByID(context.Background(), post.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.expectedPost {
+				t.Errorf("trạng thái được lưu là %q, muốn %q", stored.Status, tt.expectedPost)
+			}
+		})
 	}
 }
 
@@ -228,23 +590,20 @@ func TestDeletePost(t *testing.T) {
 	// Thiết lập dữ liệu test
 	testUser := "testuser"
 	testPostID := "test-post-id"
-	validToken, err := jwt.Create(testUser)
+	h := newTestHandler()
+	validToken, err := h.Tokens.Create(testUser, nil)
 	if err != nil {
 		t.Fatalf("không thể tạo token: %v", err)
 	}
 
 	// Tạo bài đăng test
-	collection := db.ConnectPosts()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	testPost := bson.M{
-		"id":      testPostID,
-		"creater": testUser,
-		"title":   "Test post",
+	testPost := &models.Post{
+		ID:     testPostID,
+		Author: testUser,
+		Title:  "Test post",
 	}
 
-	_, err = collection.InsertOne(ctx, testPost)
+	err = h.Posts.Create(context.Background(), testPost)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -286,7 +645,7 @@ func TestDeletePost(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			router := httprouter.New()
-			router.DELETE("/posts/:id", DeletePost)
+			router.DELETE("/posts/:id", middlewares.CheckJwt(h.Tokens, h.DeletePost))
 			router.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tt.expectedStatus {
@@ -296,3 +655,247 @@ func TestDeletePost(t *testing.T) {
 		})
 	}
 }
+
+func TestEditAndDeleteHideDrafts(t *testing.T) {
+	h := newTestHandler()
+	draft := &models.Post{ID: "draft", Author: "alice", Title: "Bản nháp", Status: models.PostDraft}
+	if err := h.Posts.Create(context.Background(), draft); err != nil {
+		t.Fatal(err)
+	}
+
+	// Người khác nhận 404 như với GetPost, không phải 403
+	rr := serveAs("PUT", "/posts/:id", "/posts/draft", h.EditPost, "bob", EditPostRequest{Title: "Sửa"})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("sửa bản nháp của người khác: nhận được %v muốn %v", rr.Code, http.StatusNotFound)
+	}
+	rr = serveAs("DELETE", "/posts/:id", "/posts/draft", h.DeletePost, "bob", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("xóa bản nháp của người khác: nhận được %v muốn %v", rr.Code, http.StatusNotFound)
+	}
+	if _, err := h.Posts.FindByID(context.Background(), "draft"); err != nil {
+		t.Fatalf("bản nháp bị xóa: %v", err)
+	}
+
+	// Tác giả vẫn sửa và xóa được bản nháp của mình
+	rr = serveAs("PUT", "/posts/:id", "/posts/draft", h.EditPost, "alice", EditPostRequest{Title: "Sửa"})
+	if rr.Code != http.StatusOK {
+		t.Errorf("tác giả sửa bản nháp: nhận được %v: %s", rr.Code, rr.Body)
+	}
+	rr = serveAs("DELETE", "/posts/:id", "/posts/draft", h.DeletePost, "alice", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("tác giả xóa bản nháp: nhận được %v: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestCheckJwtMalformedHeader(t *testing.T) {
+	h := newTestHandler()
+	validToken, err := h.Tokens.Create("testuser", nil)
+	if err != nil {
+		t.Fatalf("không thể tạo token: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "Thiếu dấu cách", header: "Bearer"},
+		{name: "Token không có scheme", header: validToken},
+		{name: "Sai scheme", header: "Basic " + validToken},
+		{name: "Token rỗng", header: "Bearer   "},
+		{name: "Token không hợp lệ", header: "Bearer not-a-token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/me/posts", nil)
+			req.Header.Set("Authorization", tt.header)
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/me/posts", middlewares.CheckJwt(h.Tokens, h.GetMyPosts))
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusUnauthorized {
+				t.Errorf("handler trả về status code không đúng cho test case '%s': nhận được %v muốn %v",
+					tt.name, status, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestGetMyPostsUsesPrincipal(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	for _, p := range []models.Post{
+		{ID: "1", Author: "testuser", Title: "Của tôi"},
+		{ID: "2", Author: "otheruser", Title: "Của người khác"},
+	} {
+		p := p
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Handler đọc người dùng từ context, không cần parse lại token
+	req := asUser(httptest.NewRequest("GET", "/me/posts", nil), "testuser")
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.GET("/me/posts", h.GetMyPosts)
+	router.ServeHTTP(rr, req)
+
+	var posts []models.Post
+	if err := json.NewDecoder(rr.Body).Decode(&posts); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(posts) != 1 || posts[0].ID != "1" {
+		t.Errorf("nhận được %v %+v, muốn 1 bài đăng của testuser", rr.Code, posts)
+	}
+}
+
+func TestDeletePostByModeratorIsAudited(t *testing.T) {
+	h := newTestHandler()
+	recorder := &auditRecorder{}
+	h.Audit = recorder
+	ctx := context.Background()
+
+	for _, p := range []models.Post{
+		{ID: "own-post", Author: "moderator", Title: "Bài của moderator"},
+		{ID: "other-post", Author: "testuser", Title: "Bài của testuser"},
+	} {
+		p := p
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	moderatorToken, _ := h.Tokens.Create("moderator", []string{models.RoleModerator})
+
+	for _, id := range []string{"own-post", "other-post"} {
+		req := httptest.NewRequest("DELETE", "/posts/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+moderatorToken)
+		rr := httptest.NewRecorder()
+
+		router := httprouter.New()
+		router.DELETE("/posts/:id", middlewares.CheckJwt(h.Tokens, h.DeletePost))
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("xoá %s: nhận được %v muốn %v", id, rr.Code, http.StatusOK)
+		}
+	}
+
+	// Chỉ thao tác trên bài của người khác mới được ghi audit
+	if len(recorder.events) != 1 {
+		t.Fatalf("nhận được %d sự kiện audit, muốn 1: %+v", len(recorder.events), recorder.events)
+	}
+	e := recorder.events[0]
+	if e.Actor != "moderator" || e.Action != "post.delete" || e.Target != "other-post" {
+		t.Errorf("sự kiện audit không đúng: %+v", e)
+	}
+}
+
+func TestErrorsAreProblemJSON(t *testing.T) {
+	posts := new(mock.PostRepository)
+	posts.On("Find", testifymock.Anything, testifymock.Anything).Return(nil, errors.New("connection refused"))
+	h := newTestHandler()
+
+	tests := []struct {
+		name           string
+		handler        *Handler
+		target         string
+		expectedStatus int
+		expectedCode   apierr.Code
+	}{
+		{name: "Không tìm thấy", handler: h, target: "/posts/khong-ton-tai", expectedStatus: http.StatusNotFound, expectedCode: apierr.CodeNotFound},
+		{name: "Query không hợp lệ", handler: h, target: "/posts?limit=abc", expectedStatus: http.StatusBadRequest, expectedCode: apierr.CodeBadRequest},
+		{name: "Lỗi repository", handler: &Handler{Posts: posts}, target: "/posts", expectedStatus: http.StatusInternalServerError, expectedCode: apierr.CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := asUser(httptest.NewRequest("GET", tt.target, nil), "testuser")
+			req = req.WithContext(res.WithRequestID(req.Context(), "req-123"))
+			rr := httptest.NewRecorder()
+
+			router := httprouter.New()
+			router.GET("/posts", tt.handler.GetAllPosts)
+			router.GET("/posts/:id", tt.handler.GetPost)
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v", rr.Code, tt.expectedStatus)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type không đúng: %q", ct)
+			}
+			var problem res.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Code != tt.expectedCode || problem.Status != tt.expectedStatus {
+				t.Errorf("problem không đúng: %+v", problem)
+			}
+			if problem.RequestID != "req-123" {
+				t.Errorf("request_id không đúng: %q", problem.RequestID)
+			}
+			if strings.Contains(problem.Detail, "connection refused") {
+				t.Errorf("lỗi nội bộ bị lộ ra client: %q", problem.Detail)
+			}
+		})
+	}
+}
+
+func TestErrorIgnoresClientRequestID(t *testing.T) {
+	h := newTestHandler()
+	req := asUser(httptest.NewRequest("GET", "/posts/khong-ton-tai", nil), "testuser")
+	req.Header.Set(res.RequestIDHeader, "id\r\ngiả mạo")
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.GET("/posts/:id", h.GetPost)
+	router.ServeHTTP(rr, req)
+
+	var problem res.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	// Không có middleware RequestID thì server tự tạo id, không lấy của client
+	if problem.RequestID == "" || strings.Contains(problem.RequestID, "giả mạo") {
+		t.Errorf("request_id không đúng: %q", problem.RequestID)
+	}
+	if id := rr.Header().Get(res.RequestIDHeader); id != problem.RequestID {
+		t.Errorf("header %q khác request_id %q", id, problem.RequestID)
+	}
+}
+
+func TestCreatePostStoresRawText(t *testing.T) {
+	h := newTestHandler()
+	testUserNamed(t, h, "testuser")
+
+	// Tiêu đề được lưu nguyên văn, chỉ được escape khi hiển thị
+	jsonBody, _ := json.Marshal(map[string]interface{}{"title": "  Tom & Jerry <3 ", "body": "a < b"})
+	req := asUser(httptest.NewRequest("POST", "/posts", bytes.NewBuffer(jsonBody)), "testuser")
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.POST("/posts", h.CreatePost)
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler trả về status code không đúng: nhận được %v muốn %v: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if strings.Contains(rr.Body.String(), "<") {
+		t.Errorf("JSON phải escape ký tự HTML: %s", rr.Body)
+	}
+	var created models.Post
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := h.Posts.FindByID(context.Background(), created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Tom & Jerry <3" || stored.Body != "a < b" {
+		t.Errorf("bài đăng bị thay đổi khi lưu: %q %q", stored.Title, stored.Body)
+	}
+}
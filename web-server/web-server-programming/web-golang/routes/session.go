@@ -0,0 +1,182 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	jwt "github.com/conglt10/web-golang/auth"
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
+	"github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TokenResponse is returned by login and refresh
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The presented token is rotated out, and presenting a rotated token
+// again revokes every session of its user.
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req, ok := decodeRefreshRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stored, err := h.RefreshTokens.FindByHash(ctx, jwt.HashRefreshToken(req.RefreshToken))
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Invalid refresh token"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	if stored.Rotated {
+		h.revokeAllSessions(ctx, w, r, stored)
+		return
+	}
+	if stored.Revoked {
+		res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Refresh token revoked"))
+		return
+	}
+	if stored.Expired(time.Now()) {
+		res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Refresh token expired"))
+		return
+	}
+
+	// Look the user up again so role changes apply from the next refresh
+	user, err := h.Users.FindByUsername(ctx, stored.Username)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Invalid refresh token"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	// Rotate only succeeds once, so a token raced by a thief is caught too
+	err = h.RefreshTokens.Rotate(ctx, stored.ID)
+	if err == repository.ErrNotFound {
+		h.revokeAllSessions(ctx, w, r, stored)
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, user, stored.Family)
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, tokens)
+}
+
+// Logout revokes the refresh token and every token rotated from the same login
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req, ok := decodeRefreshRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stored, err := h.RefreshTokens.FindByHash(ctx, jwt.HashRefreshToken(req.RefreshToken))
+	if err == nil {
+		err = h.RefreshTokens.RevokeFamily(ctx, stored.Family)
+	}
+	// Logging out with an unknown token leaves nothing to revoke
+	if err != nil && err != repository.ErrNotFound {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, "Logout successful")
+}
+
+func decodeRefreshRequest(w http.ResponseWriter, r *http.Request) (RefreshRequest, bool) {
+	var req RefreshRequest
+	if !decodeJSON(w, r, &req) {
+		return req, false
+	}
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return req, false
+	}
+	return req, true
+}
+
+// revokeAllSessions handles a refresh token that was presented after being
+// rotated. Either the client or an attacker holds a stolen copy and there is
+// no telling which, so every session of the user is ended.
+func (h *Handler) revokeAllSessions(ctx context.Context, w http.ResponseWriter, r *http.Request, stored *models.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, revoking all sessions", stored.Username)
+
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, stored.Username); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	res.Error(w, r, apierr.New(apierr.CodeInvalidToken, "Refresh token reuse detected, all sessions revoked"))
+}
+
+// issueTokens creates an access token and stores a new refresh token in the
+// family. An empty family starts a new one.
+func (h *Handler) issueTokens(ctx context.Context, user *models.User, family string) (*TokenResponse, error) {
+	token, err := h.Tokens.Create(user.Username, user.GetRoles())
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := jwt.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewV4().String()
+	if family == "" {
+		family = id
+	}
+	now := time.Now().UTC()
+	err = h.RefreshTokens.Create(ctx, &models.RefreshToken{
+		ID:        id,
+		Family:    family,
+		Username:  user.Username,
+		TokenHash: hash,
+		ExpiresAt: now.Add(jwt.RefreshTokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.Tokens.AccessTTL() / time.Second),
+	}, nil
+}
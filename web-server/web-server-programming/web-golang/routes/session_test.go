@@ -0,0 +1,208 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// testUserNamed returns the stored user with the username, creating it if
+// needed. Sessions only need the user to exist, so the password is not hashed.
+func testUserNamed(t *testing.T, h *Handler, username string) *models.User {
+	user, err := h.Users.FindByUsername(context.Background(), username)
+	if err == nil {
+		return user
+	}
+	user = &models.User{Username: username, Email: username + "@example.com", Password: "hashed"}
+	if err := h.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// postRefreshToken sends the refresh token to the handler mounted at path
+func postRefreshToken(path string, handle httprouter.Handle, refreshToken string) *httptest.ResponseRecorder {
+	jsonBody, _ := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	req := httptest.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.POST(path, handle)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRefresh(t *testing.T) {
+	h := newTestHandler()
+	login, err := h.issueTokens(context.Background(), testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		refreshToken   string
+		expectedStatus int
+	}{
+		{
+			name:           "Successful refresh",
+			refreshToken:   login.RefreshToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown token",
+			refreshToken:   "not-a-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Empty token",
+			refreshToken:   "",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postRefreshToken("/refresh", h.Refresh, tt.refreshToken)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	h := newTestHandler()
+	login, err := h.issueTokens(context.Background(), testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postRefreshToken("/refresh", h.Refresh, login.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh returned %v: %s", rr.Code, rr.Body)
+	}
+	var rotated TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Token == "" || rotated.RefreshToken == "" || rotated.RefreshToken == login.RefreshToken {
+		t.Errorf("refresh did not rotate the tokens: %+v", rotated)
+	}
+
+	// The new token works once, like the first one
+	if rr := postRefreshToken("/refresh", h.Refresh, rotated.RefreshToken); rr.Code != http.StatusOK {
+		t.Errorf("rotated token returned %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRefreshReuseRevokesAllSessions(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	stolen, err := h.issueTokens(ctx, testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDevice, err := h.issueTokens(ctx, testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherUser, err := h.issueTokens(ctx, testUserNamed(t, h, "otheruser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The legitimate client rotates, then the attacker replays the old token
+	rr := postRefreshToken("/refresh", h.Refresh, stolen.RefreshToken)
+	var rotated TokenResponse
+	json.NewDecoder(rr.Body).Decode(&rotated)
+
+	if rr := postRefreshToken("/refresh", h.Refresh, stolen.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed token returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	tests := []struct {
+		name           string
+		refreshToken   string
+		expectedStatus int
+	}{
+		{"Rotated token is revoked", rotated.RefreshToken, http.StatusUnauthorized},
+		{"Other session is revoked", otherDevice.RefreshToken, http.StatusUnauthorized},
+		{"Other user is unaffected", otherUser.RefreshToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postRefreshToken("/refresh", h.Refresh, tt.refreshToken)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	login, err := h.issueTokens(ctx, testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDevice, err := h.issueTokens(ctx, testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate once so logout has to revoke the whole family
+	rr := postRefreshToken("/refresh", h.Refresh, login.RefreshToken)
+	var rotated TokenResponse
+	json.NewDecoder(rr.Body).Decode(&rotated)
+
+	if rr := postRefreshToken("/logout", h.Logout, rotated.RefreshToken); rr.Code != http.StatusOK {
+		t.Fatalf("logout returned %v, want %v", rr.Code, http.StatusOK)
+	}
+	if rr := postRefreshToken("/logout", h.Logout, "not-a-token"); rr.Code != http.StatusOK {
+		t.Errorf("logout with unknown token returned %v, want %v", rr.Code, http.StatusOK)
+	}
+
+	if rr := postRefreshToken("/refresh", h.Refresh, rotated.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := postRefreshToken("/refresh", h.Refresh, otherDevice.RefreshToken); rr.Code != http.StatusOK {
+		t.Errorf("other session after logout returned %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRefreshPicksUpRoleChanges(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	login, err := h.issueTokens(ctx, testUserNamed(t, h, "testuser"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Users.SetRoles(ctx, "testuser", []string{models.RoleModerator}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postRefreshToken("/refresh", h.Refresh, login.RefreshToken)
+	var rotated TokenResponse
+	json.NewDecoder(rr.Body).Decode(&rotated)
+
+	claims, err := h.Tokens.Parse(rotated.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != models.RoleModerator {
+		t.Errorf("refreshed token has roles %v, want [moderator]", claims.Roles)
+	}
+}
@@ -0,0 +1,366 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	apierr "github.com/conglt10/web-golang/errors"
+	"github.com/conglt10/web-golang/mail"
+	"github.com/conglt10/web-golang/models"
+	"github.com/conglt10/web-golang/repository"
+	res "github.com/conglt10/web-golang/utils"
+	"github.com/conglt10/web-golang/validation"
+	"github.com/julienschmidt/httprouter"
+)
+
+const emailChangedText = `Hi %s,
+
+The email address of your account was changed to %s. If you did not
+change it, reset your password and contact us.
+`
+
+// Profile is what other users can see of a user
+type Profile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	PostCount   int64     `json:"post_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UpdateMeRequest changes the fields that are set. Changing the email needs
+// the current password.
+type UpdateMeRequest struct {
+	DisplayName *string `json:"display_name" validate:"max=50"`
+	Email       *string `json:"email" validate:"max=254,email"`
+	Password    string  `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72,password"`
+}
+
+// DeleteMeRequest needs the password, unless the account has none
+type DeleteMeRequest struct {
+	Password string `json:"password"`
+}
+
+// GetMe returns the account of the caller
+func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.currentAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	res.JSON(w, http.StatusOK, user)
+}
+
+// UpdateMe changes the display name and email of the caller. A new email
+// has to be verified again.
+func (h *Handler) UpdateMe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req UpdateMeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.DisplayName != nil {
+		*req.DisplayName = models.Normalize(*req.DisplayName)
+	}
+	var errs validation.Errors
+	if req.Email != nil {
+		*req.Email = models.Normalize(*req.Email)
+		if *req.Email == "" {
+			errs.Add("email", "is required")
+		}
+	}
+	errs = append(errs, validation.Struct(&req)...)
+	if err := errs.Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.currentAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	oldEmail := user.Email
+	emailChanged := req.Email != nil && *req.Email != user.Email
+	if emailChanged && !h.confirmPassword(w, r, user, "password", req.Password) {
+		return
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+	}
+	if emailChanged {
+		user.Email = *req.Email
+		user.EmailVerified = false
+	}
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	err := h.Users.UpdateProfile(ctx, user)
+	if err == repository.ErrConflict {
+		res.Error(w, r, apierr.Conflict("Email already exists"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	if emailChanged {
+		// Reset links went to the old address
+		if err := h.UserTokens.DeleteForUser(ctx, user.Username, models.TokenResetPassword); err != nil {
+			log.Printf("Warning: Failed to delete reset tokens of %s: %v", user.Username, err)
+		}
+		if err := h.sendVerification(ctx, user); err != nil {
+			log.Printf("Warning: Failed to send verification email to %s: %v", user.Username, err)
+		}
+		err := h.Mailer.Send(ctx, mail.Message{
+			To:      oldEmail,
+			Subject: "Your email address was changed",
+			Body:    fmt.Sprintf(emailChangedText, user.Username, user.Email),
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to notify %s of the email change: %v", user.Username, err)
+		}
+	}
+
+	res.JSON(w, http.StatusOK, user)
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// session is ended and the caller gets new tokens.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ChangePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := h.currentAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	// A first password is set through the reset link, which proves the
+	// email address
+	if user.Password == "" {
+		res.Error(w, r, apierr.BadRequest("Account has no password, use a password reset to set one"))
+		return
+	}
+	if !h.confirmPassword(w, r, user, "current_password", req.CurrentPassword) {
+		return
+	}
+
+	if err := user.SetPassword(req.NewPassword, h.passwordCost()); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.Users.UpdatePassword(ctx, user); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, user.Username); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	if err := h.UserTokens.DeleteForUser(ctx, user.Username, models.TokenResetPassword); err != nil {
+		log.Printf("Warning: Failed to delete reset tokens of %s: %v", user.Username, err)
+	}
+
+	tokens, err := h.issueTokens(ctx, user, "")
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, tokens)
+}
+
+// DeleteMe deletes the account of the caller after checking the password.
+// Their posts are deleted with the comments and likes on them, their
+// comments on other posts are kept without an author.
+func (h *Handler) DeleteMe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req DeleteMeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validation.Struct(&req).Err(); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	// Users with many posts take a while
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, ok := h.currentAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !h.confirmPassword(w, r, user, "password", req.Password) {
+		return
+	}
+
+	// The user goes last, so a failure part way can be retried
+	if err := h.deleteContentOf(ctx, user.Username); err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	err := h.Users.Delete(ctx, user.Username)
+	if err != nil && err != repository.ErrNotFound {
+		res.Error(w, r, err)
+		return
+	}
+	h.Logins.Reset(user.Username)
+
+	res.JSON(w, http.StatusOK, "Account deleted")
+}
+
+// GetUser returns the public profile of a user
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Users.FindByUsername(ctx, ps.ByName("username"))
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.NotFound("User not found"))
+		return
+	} else if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+	// Drafts only count for their author
+	count, err := h.Posts.Count(ctx, repository.PostFilter{Author: user.Username, VisibleTo: principal.Username})
+	if err != nil {
+		res.Error(w, r, err)
+		return
+	}
+
+	res.JSON(w, http.StatusOK, Profile{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		PostCount:   count,
+		CreatedAt:   user.CreatedAt,
+	})
+}
+
+// currentAccount loads the stored user of the caller. It writes the error
+// and returns false when there is none.
+func (h *Handler) currentAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	principal, ok := currentUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	user, err := h.Users.FindByUsername(ctx, principal.Username)
+	if err == repository.ErrNotFound {
+		res.Error(w, r, apierr.Unauthorized("User no longer exists"))
+		return nil, false
+	} else if err != nil {
+		res.Error(w, r, err)
+		return nil, false
+	}
+	user.Roles = user.GetRoles()
+	return user, true
+}
+
+// confirmPassword checks the password of the caller before a sensitive
+// change. Failures count like failed logins, so a stolen access token cannot
+// be used to guess the password. Users created through OIDC have no password,
+// so there is nothing to confirm. It writes the error and returns false when
+// the password is wrong.
+func (h *Handler) confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User, field, password string) bool {
+	if user.Password == "" {
+		return true
+	}
+
+	var errs validation.Errors
+	if password == "" {
+		errs.Add(field, "is required")
+		res.Error(w, r, errs.Err())
+		return false
+	}
+
+	ip := clientIP(r)
+	if wait := h.Logins.Attempt(user.Username, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		res.Error(w, r, apierr.New(apierr.CodeTooManyRequests, "Too many failed password attempts, try again later"))
+		return false
+	}
+	if _, err := user.CheckPassword(password, h.passwordCost()); err != nil {
+		errs.Add(field, "is incorrect")
+		res.Error(w, r, errs.Err())
+		return false
+	}
+	h.Logins.Succeeded(user.Username, ip)
+	return true
+}
+
+// deleteContentOf removes the posts, likes, sessions, mailed tokens, linked
+// identities and API keys of a user and anonymizes their comments
+func (h *Handler) deleteContentOf(ctx context.Context, username string) error {
+	posts, err := h.Posts.FindByAuthor(ctx, username)
+	if err != nil {
+		return err
+	}
+	for _, post := range posts {
+		if err := h.Comments.DeleteByPost(ctx, post.ID); err != nil {
+			return err
+		}
+		if err := h.Likes.DeleteByPost(ctx, post.ID); err != nil {
+			return err
+		}
+		if err := h.Posts.Delete(ctx, post.ID); err != nil && err != repository.ErrNotFound {
+			return err
+		}
+	}
+
+	if err := h.Comments.AnonymizeAuthor(ctx, username); err != nil {
+		return err
+	}
+	if err := h.Likes.DeleteByUser(ctx, username); err != nil {
+		return err
+	}
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, username); err != nil {
+		return err
+	}
+	for _, purpose := range []string{models.TokenVerifyEmail, models.TokenResetPassword} {
+		if err := h.UserTokens.DeleteForUser(ctx, username, purpose); err != nil {
+			return err
+		}
+	}
+	if err := h.Identities.DeleteForUser(ctx, username); err != nil {
+		return err
+	}
+	return h.APIKeys.DeleteForUser(ctx, username)
+}
@@ -0,0 +1,352 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conglt10/web-golang/mail"
+	"github.com/conglt10/web-golang/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// testUserWithPassword creates a user that can log in with the password
+func testUserWithPassword(t *testing.T, h *Handler, username, password string) *models.User {
+	user := &models.User{Username: username, Email: username + "@example.com"}
+	if err := user.SetPassword(password, h.passwordCost()); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serveAs sends body as JSON to the handler mounted at pattern, as the user
+func serveAs(method, pattern, path string, handle httprouter.Handle, username string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := asUser(httptest.NewRequest(method, path, &buf), username)
+	rr := httptest.NewRecorder()
+
+	router := httprouter.New()
+	router.Handle(method, pattern, handle)
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestGetMe(t *testing.T) {
+	h := newTestHandler()
+	testUserWithPassword(t, h, "alice", "password123")
+
+	rr := serveAs("GET", "/me", "/me", h.GetMe, "alice", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned %v: %s", rr.Code, rr.Body)
+	}
+	var got map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got["username"] != "alice" || got["email"] != "alice@example.com" || got["email_verified"] != false {
+		t.Errorf("got %v", got)
+	}
+	if _, ok := got["password"]; ok {
+		t.Error("password hash was returned")
+	}
+
+	if rr := serveAs("GET", "/me", "/me", h.GetMe, "nobody", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("deleted user: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUpdateMe(t *testing.T) {
+	h := newTestHandler()
+	testUserWithPassword(t, h, "alice", "password123")
+	testUserWithPassword(t, h, "bob", "password123")
+
+	tests := []struct {
+		name           string
+		request        UpdateMeRequest
+		expectedStatus int
+		expectedEmail  string
+		expectedName   string
+	}{
+		{
+			name:           "Display name",
+			request:        UpdateMeRequest{DisplayName: strPtr(" Alice Nguyễn ")},
+			expectedStatus: http.StatusOK,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "Alice Nguyễn",
+		},
+		{
+			name:           "Display name too long",
+			request:        UpdateMeRequest{DisplayName: strPtr(strings.Repeat("a", 51))},
+			expectedStatus: http.StatusBadRequest,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "Alice Nguyễn",
+		},
+		{
+			name:           "Email without password",
+			request:        UpdateMeRequest{Email: strPtr("new@example.com")},
+			expectedStatus: http.StatusBadRequest,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "Alice Nguyễn",
+		},
+		{
+			name:           "Email with wrong password",
+			request:        UpdateMeRequest{Email: strPtr("new@example.com"), Password: "wrong123"},
+			expectedStatus: http.StatusBadRequest,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "Alice Nguyễn",
+		},
+		{
+			name:           "Empty email",
+			request:        UpdateMeRequest{Email: strPtr(" "), Password: "password123"},
+			expectedStatus: http.StatusBadRequest,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "Alice Nguyễn",
+		},
+		{
+			name:           "Email taken",
+			request:        UpdateMeRequest{Email: strPtr("bob@example.com"), Password: "password123"},
+			expectedStatus: http.StatusConflict,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "Alice Nguyễn",
+		},
+		{
+			name:           "Same email needs no password",
+			request:        UpdateMeRequest{Email: strPtr("alice@example.com"), DisplayName: strPtr("")},
+			expectedStatus: http.StatusOK,
+			expectedEmail:  "alice@example.com",
+			expectedName:   "",
+		},
+		{
+			name:           "New email",
+			request:        UpdateMeRequest{Email: strPtr("new@example.com"), Password: "password123"},
+			expectedStatus: http.StatusOK,
+			expectedEmail:  "new@example.com",
+			expectedName:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs("PATCH", "/me", "/me", h.UpdateMe, "alice", tt.request)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s",
+					status, tt.expectedStatus, rr.Body)
+			}
+			user, _ := h.Users.FindByUsername(context.Background(), "alice")
+			if user.Email != tt.expectedEmail || user.DisplayName != tt.expectedName {
+				t.Errorf("stored email %q name %q, want %q and %q",
+					user.Email, user.DisplayName, tt.expectedEmail, tt.expectedName)
+			}
+		})
+	}
+}
+
+func TestUpdateMeEmailNeedsVerification(t *testing.T) {
+	h := newTestHandler()
+	var mailbox bytes.Buffer
+	h.Mailer = mail.NewLogMailer(&mailbox)
+	ctx := context.Background()
+	testUserWithPassword(t, h, "alice", "password123")
+	if err := h.Users.SetEmailVerified(ctx, "alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// A reset link sent to the old address
+	reset := storeUserToken(t, h, models.TokenResetPassword, "alice@example.com", time.Now().UTC().Add(time.Hour))
+
+	rr := serveAs("PATCH", "/me", "/me", h.UpdateMe, "alice", UpdateMeRequest{Email: strPtr("new@example.com"), Password: "password123"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned %v: %s", rr.Code, rr.Body)
+	}
+
+	if user, _ := h.Users.FindByUsername(ctx, "alice"); user.EmailVerified {
+		t.Error("new email is verified without a link")
+	}
+	if !strings.Contains(mailbox.String(), "To: alice@example.com\r\nSubject: Your email address was changed") {
+		t.Errorf("old address was not told:\n%s", mailbox.String())
+	}
+	if rr := postJSON("/auth/reset-password", h.ResetPassword, ResetPasswordRequest{Token: reset, Password: "newpassword1"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("reset link of the old address returned %v, want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := postJSON("/auth/verify-email", h.VerifyEmail, VerifyEmailRequest{Token: lastMailedToken(t, &mailbox)}); rr.Code != http.StatusOK {
+		t.Errorf("verification of the new address returned %v: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	user := testUserWithPassword(t, h, "alice", "password123")
+	session, err := h.issueTokens(ctx, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		request        ChangePasswordRequest
+		expectedStatus int
+	}{
+		{"Wrong current password", ChangePasswordRequest{CurrentPassword: "wrong123", NewPassword: "newpassword1"}, http.StatusBadRequest},
+		{"Weak new password", ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "short"}, http.StatusBadRequest},
+		{"Valid change", ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "newpassword1"}, http.StatusOK},
+		{"Old password no longer works", ChangePasswordRequest{CurrentPassword: "password123", NewPassword: "otherpassword1"}, http.StatusBadRequest},
+	}
+
+	var tokens TokenResponse
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs("PUT", "/me/password", "/me/password", h.ChangePassword, "alice", tt.request)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s",
+					status, tt.expectedStatus, rr.Body)
+			}
+			if rr.Code == http.StatusOK {
+				json.NewDecoder(rr.Body).Decode(&tokens)
+			}
+		})
+	}
+
+	if rr := postRefreshToken("/refresh", h.Refresh, session.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("session from before the change returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := postRefreshToken("/refresh", h.Refresh, tokens.RefreshToken); rr.Code != http.StatusOK {
+		t.Errorf("session returned by the change got %v, want %v", rr.Code, http.StatusOK)
+	}
+	if rr := postLogin(h, "alice", "newpassword1", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("new password returned %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestDeleteMe(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	alice := testUserWithPassword(t, h, "alice", "password123")
+	testUserWithPassword(t, h, "bob", "password123")
+	session, err := h.issueTokens(ctx, alice, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []models.Post{
+		{ID: "alice-post", Author: "alice", Title: "Alice's post", Status: models.PostPublished},
+		{ID: "bob-post", Author: "bob", Title: "Bob's post", Status: models.PostPublished},
+	} {
+		p := p
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []models.Comment{
+		{ID: "on-alice-post", PostID: "alice-post", Author: "bob", Body: "Nice"},
+		{ID: "on-bob-post", PostID: "bob-post", Author: "alice", AuthorID: alice.ID, Body: "Thanks"},
+		{ID: "reply", PostID: "bob-post", ParentID: "on-bob-post", Author: "bob", Body: "You're welcome"},
+	} {
+		c := c
+		if err := h.Comments.Create(ctx, &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Likes.Like(ctx, "alice-post", "bob")
+	h.Likes.Like(ctx, "bob-post", "alice")
+	h.Likes.Like(ctx, "bob-post", "bob")
+
+	for _, password := range []string{"", "wrong123"} {
+		if rr := serveAs("DELETE", "/me", "/me", h.DeleteMe, "alice", DeleteMeRequest{Password: password}); rr.Code != http.StatusBadRequest {
+			t.Fatalf("password %q: got %v want %v", password, rr.Code, http.StatusBadRequest)
+		}
+	}
+	if _, err := h.Users.FindByUsername(ctx, "alice"); err != nil {
+		t.Fatalf("user deleted with a wrong password: %v", err)
+	}
+
+	if rr := serveAs("DELETE", "/me", "/me", h.DeleteMe, "alice", DeleteMeRequest{Password: "password123"}); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned %v: %s", rr.Code, rr.Body)
+	}
+
+	if _, err := h.Users.FindByUsername(ctx, "alice"); err == nil {
+		t.Error("user was not deleted")
+	}
+	if _, err := h.Posts.FindByID(ctx, "alice-post"); err == nil {
+		t.Error("post of the user was not deleted")
+	}
+	if _, err := h.Comments.FindByID(ctx, "on-alice-post"); err == nil {
+		t.Error("comment on a deleted post was kept")
+	}
+	comment, err := h.Comments.FindByID(ctx, "on-bob-post")
+	if err != nil || comment.Author != "" || comment.AuthorID != "" || comment.Body != "Thanks" {
+		t.Errorf("comment on another post = %+v, %v, want it kept without author", comment, err)
+	}
+	if _, err := h.Comments.FindByID(ctx, "reply"); err != nil {
+		t.Errorf("reply to the user was deleted: %v", err)
+	}
+	if counts, _ := h.Likes.Count(ctx, []string{"bob-post"}); counts["bob-post"] != 1 {
+		t.Errorf("bob-post has %d likes, want 1", counts["bob-post"])
+	}
+	if rr := postRefreshToken("/refresh", h.Refresh, session.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("session of the deleted user returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	h := newTestHandler()
+	ctx := context.Background()
+	testUserWithPassword(t, h, "alice", "password123")
+	testUserWithPassword(t, h, "bob", "password123")
+	for _, p := range []models.Post{
+		{ID: "1", Author: "alice", Title: "Published", Status: models.PostPublished},
+		{ID: "2", Author: "alice", Title: "Draft", Status: models.PostDraft},
+	} {
+		p := p
+		if err := h.Posts.Create(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		caller         string
+		username       string
+		expectedStatus int
+		expectedPosts  int64
+	}{
+		{"Other user sees published posts", "bob", "alice", http.StatusOK, 1},
+		{"Author sees drafts", "alice", "alice", http.StatusOK, 2},
+		{"Unknown user", "bob", "nobody", http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAs("GET", "/users/:username", "/users/"+tt.username, h.GetUser, tt.caller, nil)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tt.expectedStatus)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var got map[string]interface{}
+			json.NewDecoder(rr.Body).Decode(&got)
+			if got["username"] != tt.username || got["post_count"] != float64(tt.expectedPosts) {
+				t.Errorf("got %v", got)
+			}
+			if _, ok := got["email"]; ok {
+				t.Error("public profile shows the email")
+			}
+		})
+	}
+}
@@ -3,7 +3,10 @@ package res
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+
+	apierr "github.com/conglt10/web-golang/errors"
 )
 
 func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
@@ -15,14 +18,42 @@ func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	}
 }
 
-func ERROR(w http.ResponseWriter, statusCode int, err error) {
-	if err != nil {
-		JSON(w, statusCode, struct {
-			Error string `json:"error"`
-		}{
-			Error: err.Error(),
-		})
-		return
+// Problem is an RFC 7807 problem details object, extended with the error
+// code, its details and the request id
+type Problem struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      apierr.Code `json:"code"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Error writes err as application/problem+json. Errors other than
+// *apierr.ServiceError are mapped by apierr.From. The cause of server errors
+// is logged, never sent.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	se := apierr.From(err)
+	status := se.Status()
+
+	requestID := requestID(w, r)
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s %s failed (request %s): %v", r.Method, r.URL.Path, requestID, se)
 	}
-	JSON(w, http.StatusBadRequest, nil)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	JSON(w, status, Problem{
+		// The code says more than a type URI would, so the type is left
+		// to its default
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    se.Message,
+		Instance:  r.URL.Path,
+		Code:      se.Code,
+		Details:   se.Details,
+		RequestID: requestID,
+	})
 }
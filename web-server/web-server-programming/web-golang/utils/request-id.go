@@ -0,0 +1,39 @@
+package res
+
+import (
+	"context"
+	"net/http"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// RequestIDHeader carries the id that ties a response to the server logs
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id carried by ctx, if any
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the id the server gave the request. The header sent by
+// the client is never used: only the RequestID middleware may accept it.
+// Requests that did not go through the middleware get a new id.
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := uuid.NewV4().String()
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
@@ -0,0 +1,253 @@
+// Package validation checks request structs against the rules in their
+// `validate` tags and reports every failing field at once:
+//
+//	type RegisterRequest struct {
+//		Username string `json:"username" validate:"required,min=3,max=30,username"`
+//		Email    string `json:"email" validate:"required,email"`
+//	}
+//
+// Rules are checked on the trimmed value and in order, the first one that
+// fails is the error of the field. Fields that are blank, or nil pointers,
+// are only checked by required.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/asaskevich/govalidator"
+	apierr "github.com/conglt10/web-golang/errors"
+)
+
+// FieldError is a field that failed validation. Field is the JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects the field errors of a request
+type Errors []FieldError
+
+// Add records a field error that the tags cannot express, for checks that
+// need the database or several fields
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Err is nil when there are no errors, otherwise a validation_failed error
+// with the field errors as details
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return apierr.Validation("Validation failed", e)
+}
+
+// rule is a check on a string value. It returns the message to report, or
+// "" when the value is valid.
+type rule func(value string) string
+
+var (
+	mu    sync.RWMutex
+	rules = map[string]rule{
+		"email": check(govalidator.IsEmail, "must be a valid email address"),
+		"username": check(func(s string) bool {
+			for _, r := range s {
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r) {
+					return false
+				}
+			}
+			return true
+		}, "may only contain letters, digits, dots, dashes and underscores"),
+		"password": check(func(s string) bool {
+			return strings.IndexFunc(s, unicode.IsLetter) >= 0 && strings.IndexFunc(s, unicode.IsDigit) >= 0
+		}, "must contain a letter and a digit"),
+	}
+)
+
+func check(valid func(string) bool, message string) rule {
+	return func(value string) string {
+		if valid(value) {
+			return ""
+		}
+		return message
+	}
+}
+
+// Register adds a rule that can be used in tags by name. It panics if the
+// name is taken, like registering the same route twice.
+func Register(name string, valid func(string) bool, message string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := rules[name]; ok || builtin(name) {
+		panic("validation: rule " + name + " is already registered")
+	}
+	rules[name] = check(valid, message)
+}
+
+func builtin(name string) bool {
+	switch name {
+	case "required", "min", "max", "oneof":
+		return true
+	}
+	return false
+}
+
+// Struct validates v, a struct or a pointer to one. Supported fields are
+// strings and slices of strings, or pointers to either. A tag that cannot be
+// parsed is a programming error and panics.
+func Struct(v interface{}) Errors {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation: %T is not a struct", v))
+	}
+
+	var errs Errors
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if message := validateField(value.Field(i), strings.Split(tag, ",")); message != "" {
+			errs.Add(jsonName(field), message)
+		}
+	}
+	return errs
+}
+
+func validateField(value reflect.Value, tags []string) string {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			if hasRule(tags, "required") {
+				return "is required"
+			}
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return validateString(strings.TrimSpace(value.String()), tags)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.String {
+			return validateList(value.Len(), tags)
+		}
+	}
+	panic("validation: unsupported field type " + value.Type().String())
+}
+
+func validateString(s string, tags []string) string {
+	if s == "" {
+		if hasRule(tags, "required") {
+			return "is required"
+		}
+		return ""
+	}
+
+	length := utf8.RuneCountInString(s)
+	for _, tag := range tags {
+		name, param := splitTag(tag)
+		switch name {
+		case "required":
+		case "min":
+			if n := atoi(tag, param); length < n {
+				return fmt.Sprintf("must be at least %d characters", n)
+			}
+		case "max":
+			if n := atoi(tag, param); length > n {
+				return fmt.Sprintf("must be at most %d characters", n)
+			}
+		case "oneof":
+			options := strings.Fields(param)
+			if !contains(options, s) {
+				return "must be one of " + strings.Join(options, ", ")
+			}
+		default:
+			mu.RLock()
+			r, ok := rules[name]
+			mu.RUnlock()
+			if !ok {
+				panic("validation: unknown rule " + name)
+			}
+			if message := r(s); message != "" {
+				return message
+			}
+		}
+	}
+	return ""
+}
+
+// validateList checks the number of items of a slice, its items are left to
+// the caller
+func validateList(length int, tags []string) string {
+	for _, tag := range tags {
+		name, param := splitTag(tag)
+		switch name {
+		case "required":
+			if length == 0 {
+				return "is required"
+			}
+		case "min":
+			if n := atoi(tag, param); length < n {
+				return fmt.Sprintf("must have at least %d items", n)
+			}
+		case "max":
+			if n := atoi(tag, param); length > n {
+				return fmt.Sprintf("must have at most %d items", n)
+			}
+		default:
+			panic("validation: rule " + name + " does not apply to lists")
+		}
+	}
+	return ""
+}
+
+func splitTag(tag string) (name, param string) {
+	if i := strings.IndexByte(tag, '='); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+func atoi(tag, param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic("validation: invalid rule " + tag)
+	}
+	return n
+}
+
+func hasRule(tags []string, name string) bool {
+	for _, tag := range tags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonName is the name clients know the field by
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
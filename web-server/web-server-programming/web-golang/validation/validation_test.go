@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testRequest struct {
+	Username string    `json:"username" validate:"required,min=3,max=10,username"`
+	Email    string    `json:"email" validate:"email"`
+	Status   *string   `json:"status" validate:"oneof=draft published"`
+	Body     *string   `json:"body" validate:"required"`
+	Tags     []string  `json:"tags" validate:"max=2"`
+	Roles    *[]string `validate:"required"`
+	Ignored  string    `json:"ignored"`
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestStruct(t *testing.T) {
+	valid := testRequest{
+		Username: "  alice_1 ",
+		Body:     strPtr("hello"),
+		Roles:    &[]string{"user"},
+	}
+
+	tests := []struct {
+		name     string
+		edit     func(r *testRequest)
+		expected Errors
+	}{
+		{name: "Valid", edit: func(r *testRequest) {}},
+		{name: "Blank is missing", edit: func(r *testRequest) { r.Username = "   " },
+			expected: Errors{{"username", "is required"}}},
+		{name: "Length counts characters", edit: func(r *testRequest) { r.Username = "ngườidùng" },
+			expected: nil},
+		{name: "Too long", edit: func(r *testRequest) { r.Username = "abcdefghijk" },
+			expected: Errors{{"username", "must be at most 10 characters"}}},
+		{name: "Charset", edit: func(r *testRequest) { r.Username = "a b c" },
+			expected: Errors{{"username", "may only contain letters, digits, dots, dashes and underscores"}}},
+		{name: "Optional email", edit: func(r *testRequest) { r.Email = "" }},
+		{name: "Invalid email", edit: func(r *testRequest) { r.Email = "not-an-email" },
+			expected: Errors{{"email", "must be a valid email address"}}},
+		{name: "Oneof", edit: func(r *testRequest) { r.Status = strPtr("archived") },
+			expected: Errors{{"status", "must be one of draft, published"}}},
+		{name: "Nil required pointer", edit: func(r *testRequest) { r.Body = nil; r.Roles = nil },
+			expected: Errors{{"body", "is required"}, {"Roles", "is required"}}},
+		{name: "Too many items", edit: func(r *testRequest) { r.Tags = []string{"a", "b", "c"} },
+			expected: Errors{{"tags", "must have at most 2 items"}}},
+		{name: "Every field is reported", edit: func(r *testRequest) { r.Username = ""; r.Email = "x"; r.Roles = &[]string{} },
+			expected: Errors{{"username", "is required"}, {"email", "must be a valid email address"}, {"Roles", "is required"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.edit(&req)
+			if got := Struct(&req); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("wrong errors: got %+v want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPasswordRule(t *testing.T) {
+	var req struct {
+		Password string `json:"password" validate:"password"`
+	}
+	for password, ok := range map[string]bool{"pass1234": true, "password": false, "12345678": false} {
+		req.Password = password
+		if got := Struct(&req).Err() == nil; got != ok {
+			t.Errorf("password %q: got valid=%v want %v", password, got, ok)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("lowercase", func(s string) bool { return s == strings.ToLower(s) }, "must be lowercase")
+
+	var req struct {
+		Slug string `json:"slug" validate:"lowercase"`
+	}
+	req.Slug = "Hello"
+	if got := Struct(req); !reflect.DeepEqual(got, Errors{{"slug", "must be lowercase"}}) {
+		t.Errorf("wrong errors: got %+v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a taken name should panic")
+		}
+	}()
+	Register("email", func(string) bool { return true }, "")
+}